@@ -0,0 +1,60 @@
+package kvdb
+
+import "time"
+
+// ApplySnapshotResult describes records merged by ApplySnapshot
+type ApplySnapshotResult struct {
+	Applied int
+	Skipped int
+}
+
+// ApplySnapshot merges records of the snapshot file at path into the database.
+// A record is applied if its key is missing or the current record
+// of the key has a lower LSN, other records are skipped as stale.
+// Applied records are written to the log with new LSNs by a single writer task.
+func (db *T) ApplySnapshot(path string) (ApplySnapshotResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var res ApplySnapshotResult
+	if db.closed {
+		return res, ErrClosed
+	}
+
+	batch := []*operation{}
+	collect := func(op *operation) (uint64, error) {
+		if op.Op != OPERATION_SET || op.Record == nil {
+			return 0, ErrOperationUnknownType
+		}
+		if err := db.checkSpace(op.Record.Tag); err != nil {
+			return 0, err
+		}
+		if space := db.space(op.Record.Tag, false); space != nil {
+			if cur, found := space.treeGet(op.Record); found && cur.LSN >= op.LSN {
+				res.Skipped++
+				return op.LSN, nil
+			}
+		}
+		op.upgradeRecord()
+		o := newOperationAt(op.Record, OPERATION_SET, time.Unix(op.Time, 0))
+		batch = append(batch, &o)
+		return op.LSN, nil
+	}
+	if _, _, err := loadDataFile(db.opts.storage(), path, collect, db.opts.codec(), nil, 0); err != nil {
+		return ApplySnapshotResult{}, err
+	}
+	if len(batch) == 0 {
+		return res, nil
+	}
+
+	if err := db.wr.WriteMany(batch); err != nil {
+		return ApplySnapshotResult{}, err
+	}
+	for _, op := range batch {
+		if _, err := db.applyTxn(op); err != nil {
+			return res, err
+		}
+		res.Applied++
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package kvdb
+
+import "time"
+
+// AtomicBatch buffers operations of db.Atomic.
+// Nothing is written until the batch is committed.
+type AtomicBatch struct {
+	ops []*operation
+}
+
+// Set buffers set of the value by key into the space with the given name
+func (b *AtomicBatch) Set(space string, key []byte, value any) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+	op := newOperation(&record{Key: key, Value: value, Tag: space}, OPERATION_SET)
+	b.ops = append(b.ops, &op)
+	return nil
+}
+
+// Del buffers delete of the key from the space with the given name
+func (b *AtomicBatch) Del(space string, key []byte) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+	op := newOperation(&record{Key: key, Tag: space}, OPERATION_DEL)
+	b.ops = append(b.ops, &op)
+	return nil
+}
+
+// Atomic calls fn with a new batch and, if fn returns nil, writes all
+// buffered operations to the log at once, wrapped into begin and commit
+// records, and then applies them to the spaces.
+// If fn returns an error nothing is written.
+// fn is called under the database lock, so it must not call methods of db.
+func (db *T) Atomic(fn func(*AtomicBatch) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	batch := &AtomicBatch{}
+	if err := fn(batch); err != nil {
+		return err
+	}
+	if len(batch.ops) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, op := range batch.ops {
+		if err := db.checkSpace(op.Record.Tag); err != nil {
+			return err
+		}
+		if op.Op != OPERATION_SET {
+			continue
+		}
+		opts := db.spaceOptions(op.Record.Tag)
+		value, err := opts.encode(op.Record.Value)
+		if err != nil {
+			return err
+		}
+		op.Record.Value = value
+		op.Record.Expires = opts.expires(now)
+		op.Expires = op.Record.Expires
+	}
+
+	beginOp := newOperation(nil, begin)
+	commitOp := newOperation(nil, commit)
+	ops := make([]*operation, 0, len(batch.ops)+2)
+	ops = append(ops, &beginOp)
+	ops = append(ops, batch.ops...)
+	ops = append(ops, &commitOp)
+
+	if err := db.wr.WriteMany(ops); err != nil {
+		return err
+	}
+	for _, op := range batch.ops {
+		if _, err := db.applyTxn(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spaceOptions returns options of the existing space with the given name
+// or options it is registered with, nil if there are none
+func (db *T) spaceOptions(name string) *SpaceOptions {
+	if sp, ok := db.spaces[name]; ok {
+		return sp.opts
+	}
+	if opts, ok := db.registry[name]; ok {
+		return &opts
+	}
+	return nil
+}
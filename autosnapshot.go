@@ -0,0 +1,98 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// AutoSnapshotter takes snapshots of the database periodically,
+// it is started by AutoSnapshot
+type AutoSnapshotter struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	lastTime atomic.Int64 // unix nanoseconds
+}
+
+// AutoSnapshot starts a goroutine calling Snapshot every interval
+// until ctx is done, Stop is called or the database is closed.
+// A snapshot is skipped if nothing was written since the previous one.
+// Results are logged to Options.Logger.
+func (db *T) AutoSnapshot(ctx context.Context, interval time.Duration) (*AutoSnapshotter, error) {
+	if interval <= 0 {
+		return nil, ErrIntervalNotPositive
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	as := &AutoSnapshotter{cancel: cancel, done: make(chan struct{})}
+	go as.run(ctx, db, interval)
+	return as, nil
+}
+
+// Stop stops taking snapshots and waits for the running one to finish
+func (as *AutoSnapshotter) Stop() {
+	as.cancel()
+	<-as.done
+}
+
+// LastSnapshotTime returns finish time of the last successful snapshot,
+// zero if none
+func (as *AutoSnapshotter) LastSnapshotTime() time.Time {
+	if ts := as.lastTime.Load(); ts != 0 {
+		return time.Unix(0, ts)
+	}
+	return time.Time{}
+}
+
+func (as *AutoSnapshotter) run(ctx context.Context, db *T, interval time.Duration) {
+	defer close(as.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger := db.opts.logger()
+	var lastLSN uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lsn, err := db.lsn()
+		if err == nil && lsn == lastLSN {
+			continue
+		}
+		if err == nil {
+			err = db.Snapshot()
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.Error("AutoSnapshotFailed", slog.String("error", err.Error()))
+			continue
+		}
+		lastLSN = lsn
+		as.lastTime.Store(time.Now().UnixNano())
+		logger.Info("AutoSnapshot", slog.Uint64("lsn", lsn))
+	}
+}
+
+// lsn returns LSN of the last written operation
+func (db *T) lsn() (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+	return db.wr.LSN(), nil
+}
@@ -0,0 +1,58 @@
+package kvdb
+
+import "path/filepath"
+
+// CHECKPOINT_FILE keeps the LSN of the last Checkpoint in the data directory
+const CHECKPOINT_FILE = "kvdb.checkpoint"
+
+// checkpointFile is the content of CHECKPOINT_FILE
+type checkpointFile struct {
+	LSN uint64 `json:"lsn"`
+}
+
+// Checkpoint syncs all operations written to the log like Flush
+// and records their LSN in CHECKPOINT_FILE, so it is known
+// after a crash up to which LSN the log is durable (see CommitPoint).
+// Writes are not blocked while the log is synced.
+func (db *T) Checkpoint() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	// concurrent checkpoints must not overwrite the file with an older LSN
+	db.checkpoints.Lock()
+	defer db.checkpoints.Unlock()
+
+	// operations up to lsn are written before the flush task
+	lsn := db.wr.LSN()
+	if err := db.wr.Flush(); err != nil {
+		return err
+	}
+	filePath := filepath.Join(db.dir, CHECKPOINT_FILE)
+	if err := writeJSONFile(db.opts.storage(), filePath, checkpointFile{LSN: lsn}); err != nil {
+		return err
+	}
+	db.commitPoint.Store(lsn)
+	return nil
+}
+
+// CommitPoint returns the LSN of the last Checkpoint, it is read
+// from CHECKPOINT_FILE on open. 0 if there was no checkpoint.
+func (db *T) CommitPoint() uint64 {
+	return db.commitPoint.Load()
+}
+
+// loadCommitPoint reads the commit point of the loaded data directory.
+// It is limited by the LSN of data files, which may be rewound
+// or restored to an earlier state after the checkpoint.
+func (db *T) loadCommitPoint() error {
+	var cp checkpointFile
+	filePath := filepath.Join(db.dir, CHECKPOINT_FILE)
+	if _, err := readJSONFile(db.opts.storage(), filePath, &cp); err != nil {
+		return err
+	}
+	db.commitPoint.Store(min(cp.LSN, db.wr.LSN()))
+	return nil
+}
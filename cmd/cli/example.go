@@ -31,7 +31,7 @@ func main() {
 	bob := User{Name: "Bob", Age: 28}
 
 	// Guarantee that the space is created
-	err = db.Update(func(space kvdb.GetSpace) (err error) {
+	_, err = db.Update(func(space kvdb.GetSpace) (err error) {
 		users := space("users")
 
 		if err = users.Set(bob.Key(), bob); err != nil {
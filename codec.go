@@ -0,0 +1,137 @@
+package kvdb
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Codec encodes operations stored in data files.
+// Every encoded operation is stored on its own line,
+// so Marshal must not produce newlines unless the codec is a BinaryCodec.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// BinaryCodec is a Codec producing arbitrary bytes. Operations
+// encoded by it are prefixed by their length instead of newline
+// terminated, and data files are named with Extension after their type,
+// like 0000000001.jlog.mp. Files without the extension are read as JSON Lines.
+type BinaryCodec interface {
+	Codec
+	Extension() string
+}
+
+// JSONCodec is the default codec, it stores operations as JSON Lines
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// Encoder encodes values of a space with custom serialisation
+type Encoder func(v any) ([]byte, error)
+
+// Decoder decodes values encoded by Encoder
+type Decoder func(data []byte, v any) error
+
+// SpaceOptions configures serialisation of space values.
+// If neither Encoder nor Decoder is set, values are stored as JSON.
+// If only one of them is set, the other one uses JSON.
+type SpaceOptions struct {
+	Encoder Encoder
+	Decoder Decoder
+
+	// DefaultTTL, if set, is applied to every record set
+	// without an explicit TTL (see Space.SetWithTTL)
+	DefaultTTL time.Duration
+
+	// Comparator orders keys of the space, bytewise by default.
+	// It is applied only when the space is created (see RegisterSpace).
+	Comparator func(a, b []byte) int
+
+	// Index, if set, returns the secondary index key of a value
+	// (see NewSpaceWithIndex)
+	Index func(value any) []byte
+
+	// LenBytesSampleRate is a fraction of records sampled by Space.LenBytes,
+	// DEFAULT_LEN_BYTES_SAMPLE_RATE if not set
+	LenBytesSampleRate float64
+
+	// LenBytesMinSample is a minimal number of records sampled
+	// by Space.LenBytes and Space.SizeBytesEstimate,
+	// DEFAULT_LEN_BYTES_MIN_SAMPLE if not set
+	LenBytesMinSample int
+}
+
+// configured reports whether options differ from the defaults
+func (o *SpaceOptions) configured() bool {
+	return o.custom() || (o != nil && (o.DefaultTTL > 0 || o.Comparator != nil || o.Index != nil ||
+		o.LenBytesSampleRate > 0 || o.LenBytesMinSample > 0))
+}
+
+// lenBytesSampleRate returns configured sample rate of LenBytes or the default one
+func (o *SpaceOptions) lenBytesSampleRate() float64 {
+	if o == nil || o.LenBytesSampleRate <= 0 {
+		return DEFAULT_LEN_BYTES_SAMPLE_RATE
+	}
+	return o.LenBytesSampleRate
+}
+
+// lenBytesMinSample returns configured minimal sample of LenBytes or the default one
+func (o *SpaceOptions) lenBytesMinSample() int {
+	if o == nil || o.LenBytesMinSample <= 0 {
+		return DEFAULT_LEN_BYTES_MIN_SAMPLE
+	}
+	return o.LenBytesMinSample
+}
+
+// expires returns expiration time of a record set at now
+// with the default TTL, 0 if it never expires
+func (o *SpaceOptions) expires(now time.Time) int64 {
+	if o == nil {
+		return 0
+	}
+	return expiresAt(now, o.DefaultTTL)
+}
+
+// custom reports whether values are encoded with custom serialisation
+func (o *SpaceOptions) custom() bool {
+	return o != nil && (o.Encoder != nil || o.Decoder != nil)
+}
+
+// encode returns the value which is stored in the space
+func (o *SpaceOptions) encode(value any) (any, error) {
+	if !o.custom() {
+		return value, nil
+	}
+	enc := o.Encoder
+	if enc == nil {
+		enc = json.Marshal
+	}
+	data, err := enc(value)
+	if err != nil {
+		return nil, err
+	}
+	return encodedValue(data), nil
+}
+
+// decodeInto decodes value of the record into into
+func (o *SpaceOptions) decodeInto(r *record, into any) error {
+	if !o.custom() {
+		return r.into(into)
+	}
+	dec := o.Decoder
+	if dec == nil {
+		dec = json.Unmarshal
+	}
+	data, err := r.encoded()
+	if err != nil {
+		return err
+	}
+	return dec(data, into)
+}
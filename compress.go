@@ -0,0 +1,53 @@
+package kvdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// Compression defines how snapshot files are compressed
+type Compression int32
+
+const (
+	// CompressionNone writes snapshots as plain operations
+	CompressionNone Compression = iota
+	// CompressionGzip writes snapshots as a gzip stream
+	CompressionGzip
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func (c Compression) valid() bool {
+	return c >= CompressionNone && c <= CompressionGzip
+}
+
+// compressor returns a writer compressing data written to w
+// and a function flushing the compressed stream into w
+func (c Compression) compressor(w io.Writer) (io.Writer, func() error) {
+	if c != CompressionGzip {
+		return w, func() error { return nil }
+	}
+	zw := gzip.NewWriter(w)
+	return zw, zw.Close
+}
+
+// decompressed returns a reader of data of r,
+// which is decompressed if it starts with the gzip header
+func decompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
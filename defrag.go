@@ -0,0 +1,104 @@
+package kvdb
+
+import (
+	"os"
+	"slices"
+)
+
+// Defrag rewrites the database into a single snapshot with alive records
+// numbered by LSNs from 1 without gaps, so data files form a contiguous
+// sequence: <N>.snap followed by <N+1>.jlog.
+// LSNs of all records change. The writer is stopped while data files
+// are rewritten, spaces obtained before Defrag must be obtained again.
+// If Defrag fails the database is closed.
+func (db *T) Defrag() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.wr.Lazy() {
+		return ErrLazyLoaded
+	}
+
+	spaces := db.views()
+	return db.reload(func() error {
+		return writeDefragSnapshot(db.opts.storage(), db.dir, spaces, db.opts.codec(), db.opts.Compression)
+	})
+}
+
+// writeDefragSnapshot replaces data files of dir with a snapshot of spaces
+func writeDefragSnapshot(st Storage, dir string, spaces map[string]Space, codec Codec, compression Compression) error {
+	if err := removeOrphanFiles(st, dir); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(spaces))
+	var total uint64
+	for name, space := range spaces {
+		names = append(names, name)
+		total += uint64(space.Len())
+	}
+	slices.Sort(names)
+
+	oldFiles, err := listDataFiles(st, dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return deleteDataFiles(st, oldFiles)
+	}
+
+	newFileName := dataFileName(dir, total, SNAP_EXTENSION, codec)
+	tmpFileName := newFileName + "." + INPROGRESS_EXTENSION
+	fh, err := st.OpenFile(tmpFileName, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	out, flush := compression.compressor(fh)
+
+	var lsn uint64
+	for _, name := range names {
+		space := spaces[name]
+		iter := space.Iter()
+		for iter.HasNext() && err == nil {
+			ops := operationsFromRecords(iter.collectNext(100), OPERATION_SET)
+			for _, op := range ops {
+				lsn++
+				op.LSN = lsn
+			}
+			err = writeManyTo(ops, out, codec)
+		}
+		iter.Release()
+		if err != nil {
+			fh.Close()
+			st.Remove(tmpFileName)
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		fh.Close()
+		st.Remove(tmpFileName)
+		return err
+	}
+	if err := closeFile(fh); err != nil {
+		st.Remove(tmpFileName)
+		return err
+	}
+
+	// old files are removed only when the snapshot is durable
+	if err := st.Rename(tmpFileName, newFileName); err != nil {
+		return err
+	}
+	if err := syncStorageDir(st, dir); err != nil {
+		return err
+	}
+	oldFiles = slices.DeleteFunc(oldFiles, func(filePath string) bool {
+		return filePath == newFileName
+	})
+	if err := deleteDataFiles(st, oldFiles); err != nil {
+		return err
+	}
+	return syncStorageDir(st, dir)
+}
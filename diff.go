@@ -0,0 +1,75 @@
+package kvdb
+
+import "bytes"
+
+// SpaceDiff lists keys of a space changed since a snapshot, in key order
+type SpaceDiff struct {
+	Added    [][]byte // keys missing in the snapshot
+	Modified [][]byte // keys with a different LSN than in the snapshot
+	Deleted  [][]byte // keys missing in the space
+}
+
+// SpaceDiff compares the space with its records in the snapshot file
+// at sinceSnapshotPath. The snapshot is loaded into a temporary space,
+// the database is not modified.
+func (db *T) SpaceDiff(name string, sinceSnapshotPath string) (*SpaceDiff, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	space := db.space(name, false)
+	if space == nil {
+		db.mu.RUnlock()
+		return nil, ErrSpaceNotFound
+	}
+	cur := space.View()
+	db.mu.RUnlock()
+
+	snap := newSpaceWithComparator(name, nil, cur.opts.Comparator)
+	load := func(op *operation) (uint64, error) {
+		if op.Record == nil || op.Record.Tag != name {
+			return op.LSN, nil
+		}
+		op.upgradeRecord()
+		switch op.Op {
+		case OPERATION_SET:
+			_, _ = snap.treeSet(op.Record)
+		case OPERATION_DEL:
+			_, _ = snap.treeDel(op.Record)
+		default:
+			return 0, ErrOperationUnknownType
+		}
+		return op.LSN, nil
+	}
+	if _, _, err := loadDataFile(db.opts.storage(), sinceSnapshotPath, load, db.opts.codec(), nil, 0); err != nil {
+		return nil, err
+	}
+	return diffSpaces(&snap, &cur), nil
+}
+
+// diffSpaces walks both trees in key order and compares their records
+func diffSpaces(old, cur *Space) *SpaceDiff {
+	diff := &SpaceDiff{}
+	oldIter, curIter := old.tree.Iter(), cur.tree.Iter()
+	defer oldIter.Release()
+	defer curIter.Release()
+
+	oldOk, curOk := oldIter.First(), curIter.First()
+	for oldOk || curOk {
+		switch {
+		case !curOk || (oldOk && cur.tree.Less(oldIter.Item(), curIter.Item())):
+			diff.Deleted = append(diff.Deleted, bytes.Clone(oldIter.Item().Key))
+			oldOk = oldIter.Next()
+		case !oldOk || cur.tree.Less(curIter.Item(), oldIter.Item()):
+			diff.Added = append(diff.Added, bytes.Clone(curIter.Item().Key))
+			curOk = curIter.Next()
+		default:
+			if oldIter.Item().LSN != curIter.Item().LSN {
+				diff.Modified = append(diff.Modified, bytes.Clone(curIter.Item().Key))
+			}
+			oldOk, curOk = oldIter.Next(), curIter.Next()
+		}
+	}
+	return diff
+}
@@ -0,0 +1,21 @@
+package kvdb
+
+// DropAllSpaces removes all records of all spaces without reopening
+// the database. Removal is written to the log, so spaces stay empty
+// after restart. Spaces of Options.Spaces and spaces with custom
+// SpaceOptions are kept empty, other spaces are removed.
+// System spaces such as __metadata__ keep their records.
+// Spaces returned before the call must be obtained again.
+func (db *T) DropAllSpaces() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.wr.TruncateAll(); err != nil {
+		return err
+	}
+	db.truncateAll()
+	return nil
+}
@@ -4,15 +4,39 @@ import "errors"
 
 var ErrClosed = errors.New("kvdb is already closed")
 var ErrNotFound = errors.New("record not found")
+var ErrSpaceNotFound = errors.New("space not found")
 var ErrKeyIsNil = errors.New("key is nil")
 var ErrIntoIsNotPointer = errors.New("into must be a pointer")
+var ErrNoIndex = errors.New("space has no secondary index")
 var ErrIntoInvalidPointer = errors.New("into must be a pointer to a slice")
 var ErrIntoInvalidType = errors.New("into has invalid type")
 var ErrIteratorNoNextValue = errors.New("iterator is finished: no next value")
+var ErrMergePanicked = errors.New("merge function panicked")
+var ErrLockTimeout = errors.New("timeout acquiring kvdb lock")
+var ErrMSetOddArgs = errors.New("mset requires an even number of arguments")
+var ErrMSetInvalidKey = errors.New("mset key must be []byte or string")
+var ErrExportFormatUnknown = errors.New("unknown export format")
+var ErrExportFormatMismatch = errors.New("values have different fields: can not export as csv")
+var ErrSyncModeUnknown = errors.New("unknown sync mode")
+var ErrCompressionUnknown = errors.New("unknown compression")
+var ErrAlreadyLoaded = errors.New("kvdb is already loaded")
+var ErrUnexpectedSpace = errors.New("space is not defined in options")
+var ErrWriterBusy = errors.New("kvdb writer queue is full")
+var ErrGracefulCloseTimeout = errors.New("kvdb close timed out: queued writes may be lost")
+var ErrLSNInSnapshot = errors.New("lsn is in a snapshot: can not rewind")
+var ErrSameDir = errors.New("database already uses the directory")
+var ErrSchemaMismatch = errors.New("space stores values of another schema")
+var ErrIntervalNotPositive = errors.New("interval must be positive")
+var ErrDataLossRisk = errors.New("kvdb closed without sync: recent writes may be lost")
+var ErrLSNNotFound = errors.New("lsn is not written")
+var ErrReadOnly = errors.New("space is read-only")
+var ErrDatabaseExists = errors.New("database already exists")
+var ErrLazyLoaded = errors.New("jlog files are not loaded by lazy load: they would be lost")
 
 // internalErrors
 var ErrRecordIsNil = errors.New("record is nil")
 var ErrOperationIsNil = errors.New("operation is nil")
+var ErrViewNotMatchSpace = errors.New("view is not taken from the space")
 var ErrOperationNotMatchSpace = errors.New("operation tag does not match space tag")
 var ErrOperationUnknownType = errors.New("unknown operation type")
 var ErrWriterInvalidStatus = errors.New("kvdb writer invalid status for operation")
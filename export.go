@@ -0,0 +1,145 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"slices"
+)
+
+// ExportFormat is a format of ExportSpace output
+type ExportFormat int
+
+const (
+	// ExportFormatJSONLines writes one set operation per line,
+	// the output can be imported back with NewSpaceFrom
+	ExportFormatJSONLines ExportFormat = iota
+	// ExportFormatCSV writes a header row with the key column and
+	// fields of the first value, then one row per record
+	ExportFormatCSV
+)
+
+// ExportSpace writes all records of the space into w in the given format.
+// Records are exported from a frozen view of the space, so concurrent
+// writes are not included.
+// CSV export fails with ErrExportFormatMismatch if values are not
+// JSON objects with the same fields.
+func (db *T) ExportSpace(name string, w io.Writer, format ExportFormat) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	space := db.space(name, false)
+	if space == nil {
+		db.mu.RUnlock()
+		return ErrSpaceNotFound
+	}
+	view := space.View()
+	db.mu.RUnlock()
+
+	switch format {
+	case ExportFormatJSONLines:
+		return exportJSONLines(&view, w)
+	case ExportFormatCSV:
+		return exportCSV(&view, w)
+	}
+	return ErrExportFormatUnknown
+}
+
+func exportJSONLines(space *Space, w io.Writer) error {
+	iter := space.Iter()
+	defer iter.Release()
+
+	enc := json.NewEncoder(w)
+	for iter.HasNext() {
+		for _, op := range operationsFromRecords(iter.collectNext(100), OPERATION_SET) {
+			if err := enc.Encode(op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func exportCSV(space *Space, w io.Writer) error {
+	iter := space.Iter()
+	defer iter.Release()
+
+	cw := csv.NewWriter(w)
+	var columns []string
+	for iter.HasNext() {
+		rec := iter.next()
+		raw, err := rec.rawValue()
+		if err != nil {
+			return err
+		}
+		names, fields, err := objectFields(raw)
+		if err != nil {
+			return err
+		}
+
+		if columns == nil {
+			columns = names
+			if err := cw.Write(append([]string{"key"}, columns...)); err != nil {
+				return err
+			}
+		}
+		if len(fields) != len(columns) {
+			return ErrExportFormatMismatch
+		}
+
+		row := make([]string, 0, len(columns)+1)
+		row = append(row, string(rec.Key))
+		for _, column := range columns {
+			field, ok := fields[column]
+			if !ok {
+				return ErrExportFormatMismatch
+			}
+			row = append(row, csvCell(field))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// objectFields returns field names of JSON object in order of appearance
+// and their raw values
+func objectFields(raw []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, ErrExportFormatMismatch
+	}
+
+	names := []string{}
+	fields := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		name := tok.(string)
+		var field json.RawMessage
+		if err := dec.Decode(&field); err != nil {
+			return nil, nil, err
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		fields[name] = field
+	}
+	return names, fields, nil
+}
+
+// csvCell returns JSON strings as is and other values as JSON
+func csvCell(field json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(field, &s); err == nil {
+		return s
+	}
+	return string(field)
+}
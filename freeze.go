@@ -0,0 +1,67 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+)
+
+// FrozenSpace is a serialised copy of a space made by Space.Freeze.
+// It does not share memory with the space and can be
+// marshalled with encoding/json.
+type FrozenSpace struct {
+	Name    string                     `json:"name"`
+	Records map[string]json.RawMessage `json:"records"` // JSON values by base64 encoded keys
+}
+
+// Freeze returns a serialised copy of all records of the space
+func (s *Space) Freeze() (FrozenSpace, error) {
+	frozen := FrozenSpace{
+		Name:    *s.name,
+		Records: make(map[string]json.RawMessage, s.tree.Len()),
+	}
+
+	var err error
+	s.tree.Scan(func(rec *record) bool {
+		var raw []byte
+		if raw, err = rec.rawValue(); err != nil {
+			return false
+		}
+		frozen.Records[base64.StdEncoding.EncodeToString(rec.Key)] = raw
+		return true
+	})
+	if err != nil {
+		return FrozenSpace{}, err
+	}
+	return frozen, nil
+}
+
+// UnfreezeSpace sets all records of f into the space with the given name,
+// the space is created if it does not exist.
+// Records are written to the log at once in key order.
+func (db *T) UnfreezeSpace(name string, f FrozenSpace) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkSpace(name); err != nil {
+		return err
+	}
+
+	entries := make([]KeyValuePair, 0, len(f.Records))
+	for encoded, raw := range f.Records {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, KeyValuePair{Key: key, Value: raw})
+	}
+	slices.SortFunc(entries, func(a, b KeyValuePair) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+
+	return db.space(name, true).SetMany(entries)
+}
@@ -0,0 +1,66 @@
+package kvdb
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+)
+
+// GCResult describes data files removed by GC
+type GCResult struct {
+	Removed    int
+	FreedBytes int64
+}
+
+// GC removes data files which are not loaded on open:
+// snapshots older than the latest one and jlog files written before it.
+// Such files are left behind when removing them after a snapshot fails.
+// Files of a snapshot in progress are not touched.
+// It is safe to call on a running database.
+func (db *T) GC() (GCResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var res GCResult
+	if db.closed {
+		return res, ErrClosed
+	}
+
+	// files created after the listing (by rotation or snapshot) are never removed
+	st := db.opts.storage()
+	filePathes, err := listDataFiles(st, db.dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
+	if err != nil {
+		return res, err
+	}
+	reachable, err := db.wr.DataFiles()
+	if err != nil {
+		return res, err
+	}
+	open, err := db.wr.OpenFiles()
+	if err != nil {
+		return res, err
+	}
+	for _, file := range open {
+		reachable = append(reachable, file.Path)
+	}
+
+	for _, filePath := range filePathes {
+		if slices.Contains(reachable, filePath) {
+			continue
+		}
+		fi, err := st.Stat(filePath)
+		if err == nil {
+			err = st.Remove(filePath)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed by a finished snapshot meanwhile
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Removed++
+		res.FreedBytes += fi.Size()
+	}
+	return res, nil
+}
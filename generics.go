@@ -0,0 +1,18 @@
+package kvdb
+
+// GETyped calls fn with every record of the space with key greater
+// than or equal to key in key order, like Space.GE, decoding values into V.
+// The walk stops when fn returns false or a value can not be decoded,
+// the decoding error is returned then.
+// It is a function, since Go methods can not have type parameters.
+func GETyped[V any](s *Space, key []byte, fn func(key []byte, value V) bool) error {
+	var err error
+	s.tree.Ascend(&record{Key: key}, func(r *record) bool {
+		var value V
+		if err = s.opts.decodeInto(r, &value); err != nil {
+			return false
+		}
+		return fn(r.Key, value)
+	})
+	return err
+}
@@ -2,4 +2,9 @@ module github.com/ochaton/kvdb
 
 go 1.24
 
-require github.com/tidwall/btree v1.7.0
+require (
+	github.com/tidwall/btree v1.7.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,110 @@
+package kvdb
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// hooks holds lifecycle hooks registered by OnCompact and OnSnapshot.
+// It has its own lock, since the snapshot hooks are called
+// by the writer while Compact holds the database lock.
+type hooks struct {
+	mu       sync.Mutex
+	logger   *slog.Logger
+	lastID   int
+	compact  []hook[func(CompactionResult)]
+	snapshot []hook[func(snapshotPath string, lsn uint64)]
+}
+
+// hook is a registered hook with its ID
+type hook[F any] struct {
+	id int
+	fn F
+}
+
+// OnCompact registers hook called after every successful Compact,
+// it returns ID of the hook for RemoveCompactHook.
+// Hooks are called in the order of registration and must not block,
+// their panics are recovered and logged.
+func (db *T) OnCompact(fn func(CompactionResult)) int {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+
+	db.hooks.lastID++
+	db.hooks.compact = append(db.hooks.compact, hook[func(CompactionResult)]{db.hooks.lastID, fn})
+	return db.hooks.lastID
+}
+
+// RemoveCompactHook removes the hook registered by OnCompact
+func (db *T) RemoveCompactHook(id int) {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+
+	db.hooks.compact = removeHook(db.hooks.compact, id)
+}
+
+// OnSnapshot registers hook called with the path and the LSN of every
+// snapshot written by Snapshot, Compact or SnapshotTo after the file
+// is renamed to its final name, it returns ID of the hook
+// for RemoveSnapshotHook. Hooks are called from the goroutine
+// writing the snapshot and must not block, their panics
+// are recovered and logged.
+func (db *T) OnSnapshot(fn func(snapshotPath string, lsn uint64)) int {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+
+	db.hooks.lastID++
+	db.hooks.snapshot = append(db.hooks.snapshot, hook[func(string, uint64)]{db.hooks.lastID, fn})
+	return db.hooks.lastID
+}
+
+// RemoveSnapshotHook removes the hook registered by OnSnapshot
+func (db *T) RemoveSnapshotHook(id int) {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+
+	db.hooks.snapshot = removeHook(db.hooks.snapshot, id)
+}
+
+func removeHook[F any](hooks []hook[F], id int) []hook[F] {
+	return slices.DeleteFunc(slices.Clone(hooks), func(h hook[F]) bool {
+		return h.id == id
+	})
+}
+
+// compacted calls compact hooks with res
+func (h *hooks) compacted(res CompactionResult) {
+	h.mu.Lock()
+	compact := h.compact
+	h.mu.Unlock()
+
+	for _, hook := range compact {
+		h.call("compact", func() { hook.fn(res) })
+	}
+}
+
+// snapshotted calls snapshot hooks with the written snapshot
+func (h *hooks) snapshotted(snapshotPath string, lsn uint64) {
+	h.mu.Lock()
+	snapshot := h.snapshot
+	h.mu.Unlock()
+
+	for _, hook := range snapshot {
+		h.call("snapshot", func() { hook.fn(snapshotPath, lsn) })
+	}
+}
+
+// call calls fn logging its panic
+func (h *hooks) call(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error("HookPanicked",
+				slog.String("hook", name),
+				slog.String("panic", fmt.Sprint(r)),
+			)
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,196 @@
+package kvdb
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DEFAULT_HTTP_KEYS_LIMIT is a page size of /spaces/{name}/keys if limit is not set
+const DEFAULT_HTTP_KEYS_LIMIT = 100
+
+// HTTPSpaceInfo describes a space listed by GET /spaces
+type HTTPSpaceInfo struct {
+	Name     string `json:"name"`
+	Len      int    `json:"len"`
+	LenBytes int64  `json:"lenBytes"`
+}
+
+// HTTPKeysPage is a page of keys returned by GET /spaces/{name}/keys
+type HTTPKeysPage struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// ListenAndServe serves read-only HTTP API of the database on addr
+// until ctx is done, see Handler. Returns nil if stopped by ctx.
+func (db *T) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: db.Handler()}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+		if err := srv.Shutdown(context.Background()); err != nil {
+			return err
+		}
+		if err := <-failed; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// Handler returns read-only HTTP API of the database, responses are JSON:
+//
+//	GET /spaces                                     spaces and their sizes
+//	GET /spaces/{name}/keys?prefix=&cursor=&limit=  page of keys greater than cursor
+//	GET /spaces/{name}/keys/{key}                   raw JSON value of the key
+//	GET /stats                                      DetailedStats of the database
+func (db *T) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /spaces", db.httpSpaces)
+	mux.HandleFunc("GET /spaces/{name}/keys", db.httpKeys)
+	mux.HandleFunc("GET /spaces/{name}/keys/{key...}", db.httpValue)
+	mux.HandleFunc("GET /stats", db.httpStats)
+	return mux
+}
+
+func (db *T) httpSpaces(w http.ResponseWriter, r *http.Request) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		writeHTTPError(w, ErrClosed)
+		return
+	}
+	spaces := make([]HTTPSpaceInfo, 0, len(db.spaces))
+	for name, space := range db.spaces {
+		if isSystemSpace(name) {
+			continue
+		}
+		spaces = append(spaces, HTTPSpaceInfo{Name: name, Len: space.Len(), LenBytes: space.LenBytes()})
+	}
+	db.mu.RUnlock()
+
+	slices.SortFunc(spaces, func(a, b HTTPSpaceInfo) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	writeHTTPJSON(w, spaces)
+}
+
+func (db *T) httpKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := DEFAULT_HTTP_KEYS_LIMIT
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeHTTPStatus(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	space, err := db.ExistingSpace(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	keys, next := space.keysPage([]byte(query.Get("prefix")), []byte(query.Get("cursor")), limit)
+
+	page := HTTPKeysPage{Keys: make([]string, 0, len(keys)), NextCursor: string(next)}
+	for _, key := range keys {
+		page.Keys = append(page.Keys, string(key))
+	}
+	writeHTTPJSON(w, page)
+}
+
+func (db *T) httpValue(w http.ResponseWriter, r *http.Request) {
+	space, err := db.ExistingSpace(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	raw, err := space.GetJSON([]byte(r.PathValue("key")))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(raw)
+}
+
+func (db *T) httpStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := db.Stats()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPJSON(w, stats)
+}
+
+// keysPage returns up to limit keys with the prefix greater than cursor
+// in key order, empty cursor starts from the first key.
+// next is the last returned key if there may be more keys.
+// Keys with the prefix are adjacent in bytewise order, so only them
+// are visited; spaces with a custom comparator are scanned from cursor.
+func (s *Space) keysPage(prefix, cursor []byte, limit int) (keys [][]byte, next []byte) {
+	bytewise := s.opts.Comparator == nil
+	from := cursor
+	if bytewise && bytes.Compare(prefix, from) > 0 {
+		from = prefix
+	}
+	now := time.Now()
+	collect := func(r *record) bool {
+		if !bytes.HasPrefix(r.Key, prefix) {
+			return !bytewise
+		}
+		if r.expired(now) || (len(cursor) > 0 && bytes.Equal(r.Key, cursor)) {
+			return true
+		}
+		if len(keys) == limit {
+			next = keys[len(keys)-1]
+			return false
+		}
+		keys = append(keys, bytes.Clone(r.Key))
+		return true
+	}
+	if len(from) == 0 {
+		s.tree.Scan(collect)
+	} else {
+		s.tree.Ascend(&record{Key: from}, collect)
+	}
+	return keys, next
+}
+
+func writeHTTPJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrSpaceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrClosed):
+		status = http.StatusServiceUnavailable
+	}
+	writeHTTPStatus(w, status, err.Error())
+}
+
+func writeHTTPStatus(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
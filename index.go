@@ -0,0 +1,127 @@
+package kvdb
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/tidwall/btree"
+)
+
+// indexEntry links the secondary index key to the record
+type indexEntry struct {
+	key []byte
+	rec *record
+}
+
+// spaceIndex is a secondary index of a space kept in memory only.
+// Entries are ordered by the index key and then by the primary key,
+// so different records may have the same index key.
+type spaceIndex struct {
+	mu   sync.Mutex // serialises updates of the space with the index
+	fn   func(value any) []byte
+	tree *btree.BTreeG[indexEntry]
+}
+
+// newSpaceIndex creates the index of records of tree
+func newSpaceIndex(fn func(value any) []byte, tree *btree.BTreeG[*record]) *spaceIndex {
+	idx := &spaceIndex{fn: fn, tree: btree.NewBTreeG(func(a, b indexEntry) bool {
+		if c := bytes.Compare(a.key, b.key); c != 0 {
+			return c < 0
+		}
+		// the entry without record is the least one with its key
+		if a.rec == nil || b.rec == nil {
+			return a.rec == nil && b.rec != nil
+		}
+		return tree.Less(a.rec, b.rec)
+	})}
+	tree.Scan(func(r *record) bool {
+		idx.add(r)
+		return true
+	})
+	return idx
+}
+
+func (idx *spaceIndex) add(r *record) {
+	if key := idx.fn(r.Value); key != nil {
+		idx.tree.Set(indexEntry{key: key, rec: r})
+	}
+}
+
+func (idx *spaceIndex) remove(r *record) {
+	if key := idx.fn(r.Value); key != nil {
+		idx.tree.Delete(indexEntry{key: key, rec: r})
+	}
+}
+
+// copy returns a frozen copy of the index
+func (idx *spaceIndex) copy() *spaceIndex {
+	return &spaceIndex{fn: idx.fn, tree: idx.tree.Copy()}
+}
+
+// NewSpaceWithIndex creates a new space like NewSpace with a secondary
+// index on keys returned by indexFn, see Space.GetByIndex.
+// indexFn returns nil for values without the index key.
+// It receives values as stored in the space: values given to Set
+// or, for records loaded from data files, values decoded from JSON.
+// The index is not written to data files, it is rebuilt from records
+// of the space, so indexFn must be given every time the database is opened.
+func (db *T) NewSpaceWithIndex(name string, indexFn func(value any) []byte) (*Space, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := db.checkSpace(name); err != nil {
+		return nil, err
+	}
+	space := db.space(name, true)
+	space.opts.Index = indexFn
+	space.initIndex()
+	db.spaces[name] = *space
+	return space, nil
+}
+
+// initIndex builds the secondary index of the space
+// if SpaceOptions.Index is set
+func (s *Space) initIndex() {
+	s.index = nil
+	if s.opts.Index != nil {
+		s.index = newSpaceIndex(s.opts.Index, s.tree)
+	}
+}
+
+// GetByIndex decodes into the value of the record with the given
+// secondary index key. If several records have the key, the one with
+// the least primary key is used. It returns ErrNoIndex if the space has no
+// secondary index and ErrNotFound if no record has the key.
+func (s *Space) GetByIndex(indexKey []byte, into any) error {
+	if indexKey == nil {
+		return ErrKeyIsNil
+	}
+	if reflect.ValueOf(into).Kind() != reflect.Ptr {
+		return ErrIntoIsNotPointer
+	}
+	if s.index == nil {
+		return ErrNoIndex
+	}
+
+	now := time.Now()
+	var found *record
+	s.index.tree.Ascend(indexEntry{key: indexKey}, func(ent indexEntry) bool {
+		if !bytes.Equal(ent.key, indexKey) {
+			return false
+		}
+		if ent.rec.expired(now) {
+			return true
+		}
+		found = ent.rec
+		return false
+	})
+	if found == nil {
+		return ErrNotFound
+	}
+	return s.opts.decodeInto(found, into)
+}
@@ -0,0 +1,67 @@
+package kvdb
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// Version is the version of the kvdb package
+const Version = "v0.1.0"
+
+// CREATED_FILE keeps the creation time of the database in the data directory
+const CREATED_FILE = "kvdb.created"
+
+// createdFile is the content of CREATED_FILE
+type createdFile struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DatabaseInfo describes the opened database
+type DatabaseInfo struct {
+	Path       string
+	Version    string    // Version of the package
+	CreatedAt  time.Time // zero if the database was created by a version without it
+	CurrentLSN uint64
+	SpaceCount int // including system spaces
+}
+
+// Info returns identity information of the database,
+// zero DatabaseInfo if it is closed
+func (db *T) Info() DatabaseInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return DatabaseInfo{}
+	}
+	return DatabaseInfo{
+		Path:       db.dir,
+		Version:    Version,
+		CreatedAt:  db.createdAt,
+		CurrentLSN: db.wr.LSN(),
+		SpaceCount: len(db.spaces),
+	}
+}
+
+// loadCreatedAt reads the creation time of the loaded data directory.
+// It is written when a database without data files is loaded,
+// the creation time of older databases is unknown.
+func (db *T) loadCreatedAt() error {
+	st := db.opts.storage()
+	filePath := filepath.Join(db.dir, CREATED_FILE)
+	var created createdFile
+	found, err := readJSONFile(st, filePath, &created)
+	if err != nil || found {
+		db.createdAt = created.CreatedAt
+		return err
+	}
+	if filePathes, err := db.wr.DataFiles(); err != nil || len(filePathes) > 0 {
+		return err
+	}
+	created.CreatedAt = time.Now()
+	if err = writeJSONFile(st, filePath, created); err != nil {
+		return err
+	}
+	db.createdAt = created.CreatedAt
+	return nil
+}
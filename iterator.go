@@ -0,0 +1,98 @@
+package kvdb
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ContextIterator wraps SpaceIterator and stops iteration
+// when its context is done
+type ContextIterator struct {
+	iter *SpaceIterator
+	ctx  context.Context
+	err  error
+}
+
+// WithContext returns an iterator which stops when ctx is done.
+// Release must still be called on either iterator.
+func (sIt *SpaceIterator) WithContext(ctx context.Context) *ContextIterator {
+	return &ContextIterator{iter: sIt, ctx: ctx}
+}
+
+func (cIt *ContextIterator) HasNext() bool {
+	if cIt.err != nil {
+		return false
+	}
+	if err := cIt.ctx.Err(); err != nil {
+		cIt.err = err
+		return false
+	}
+	return cIt.iter.HasNext()
+}
+
+func (cIt *ContextIterator) Next(into any) error {
+	if cIt.err != nil {
+		return cIt.err
+	}
+	if err := cIt.ctx.Err(); err != nil {
+		cIt.err = err
+		return err
+	}
+	if err := cIt.iter.Next(into); err != nil {
+		cIt.err = err
+		return err
+	}
+	return nil
+}
+
+// Error returns the context error if iteration was cancelled
+// or the error returned by Next
+func (cIt *ContextIterator) Error() error {
+	return cIt.err
+}
+
+func (cIt *ContextIterator) Release() {
+	cIt.iter.Release()
+}
+
+// TransformedIterator wraps SpaceIterator and passes the JSON encoding
+// of every value through transform before decoding it
+type TransformedIterator struct {
+	iter      *SpaceIterator
+	transform func(raw []byte) ([]byte, error)
+}
+
+// MapValues returns an iterator which applies transform to raw values.
+// Records stored in the space are not modified.
+// Release must still be called on either iterator.
+func (sIt *SpaceIterator) MapValues(transform func(raw []byte) ([]byte, error)) *TransformedIterator {
+	return &TransformedIterator{iter: sIt, transform: transform}
+}
+
+func (tIt *TransformedIterator) HasNext() bool {
+	return tIt.iter.HasNext()
+}
+
+func (tIt *TransformedIterator) Next(into any) error {
+	rec := tIt.iter.next()
+	if rec == nil {
+		return ErrIteratorNoNextValue
+	}
+
+	raw, err := rec.rawValue()
+	if err != nil {
+		return err
+	}
+	raw, err = tIt.transform(raw)
+	if err != nil {
+		return err
+	}
+
+	transformed := rec.clone()
+	transformed.Value = json.RawMessage(raw)
+	return transformed.into(into)
+}
+
+func (tIt *TransformedIterator) Release() {
+	tIt.iter.Release()
+}
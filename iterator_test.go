@@ -0,0 +1,45 @@
+package kvdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestContextIteratorCancel(t *testing.T) {
+	/* test iteration stops when context is cancelled */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 100000 {
+		space.Set([]byte(fmt.Sprintf("name-%06d", i)), i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iter := space.Iter()
+	cIter := iter.WithContext(ctx)
+	scanned := 0
+	for cIter.HasNext() {
+		var ret int
+		if err := cIter.Next(&ret); err != nil {
+			t.Fatalf("failed iter.Next with error: %v", err)
+		}
+		scanned++
+		if scanned == 1000 {
+			cancel()
+		}
+	}
+	cIter.Release()
+
+	if scanned != 1000 {
+		t.Fatalf("failed result check: scanned %d records, expected %d", scanned, 1000)
+	}
+	if cIter.Error() != context.Canceled {
+		t.Fatalf("failed iter.Error: have '%v', expected '%v'", cIter.Error(), context.Canceled)
+	}
+
+	// released iterator does not hold the tree
+	if err := space.Set([]byte("name-x"), 0); err != nil {
+		t.Fatalf("failed space.Set with error: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package key encodes composite keys of kvdb spaces.
+//
+// Every part is written as a type tag followed by its value:
+// signed integers as 8 big-endian bytes of int64 with the sign bit flipped,
+// unsigned integers as 8 big-endian bytes of uint64,
+// strings and byte slices as 4 big-endian bytes of length followed by the bytes.
+// So keys compare bytewise in the order of their parts, numeric parts
+// in numeric order and strings of the same length in bytewise order.
+package key
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidKey = errors.New("invalid composite key")
+var ErrUnsupportedPart = errors.New("unsupported composite key part type")
+var ErrPartTooLong = errors.New("composite key part is too long")
+
+const (
+	tagInt64  byte = 0x01
+	tagUint64 byte = 0x02
+	tagString byte = 0x03
+	tagBytes  byte = 0x04
+)
+
+// Encode returns the composite key of parts like EncodeErr,
+// it returns nil if a part fails to encode, so the key is
+// rejected by Space methods with ErrKeyIsNil.
+func Encode(parts ...any) []byte {
+	b, err := EncodeErr(parts...)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// EncodeErr returns the composite key of parts.
+// Parts must be integers, strings or byte slices, other parts fail
+// with ErrUnsupportedPart. Strings and byte slices longer than
+// math.MaxUint32 bytes fail with ErrPartTooLong.
+// Integers are decoded back as int64 and uint64 whatever their size is.
+func EncodeErr(parts ...any) ([]byte, error) {
+	b := []byte{}
+	for _, part := range parts {
+		var err error
+		switch v := part.(type) {
+		case int:
+			b = appendInt(b, int64(v))
+		case int8:
+			b = appendInt(b, int64(v))
+		case int16:
+			b = appendInt(b, int64(v))
+		case int32:
+			b = appendInt(b, int64(v))
+		case int64:
+			b = appendInt(b, v)
+		case uint:
+			b = appendUint(b, uint64(v))
+		case uint8:
+			b = appendUint(b, uint64(v))
+		case uint16:
+			b = appendUint(b, uint64(v))
+		case uint32:
+			b = appendUint(b, uint64(v))
+		case uint64:
+			b = appendUint(b, v)
+		case string:
+			b, err = appendBytes(b, tagString, []byte(v))
+		case []byte:
+			b, err = appendBytes(b, tagBytes, v)
+		default:
+			err = fmt.Errorf("%w: %T", ErrUnsupportedPart, part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendInt(b []byte, v int64) []byte {
+	b = append(b, tagInt64)
+	return binary.BigEndian.AppendUint64(b, uint64(v)^(1<<63))
+}
+
+func appendUint(b []byte, v uint64) []byte {
+	b = append(b, tagUint64)
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+func appendBytes(b []byte, tag byte, v []byte) ([]byte, error) {
+	if uint64(len(v)) > math.MaxUint32 {
+		return nil, ErrPartTooLong
+	}
+	b = append(b, tag)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+	return append(b, v...), nil
+}
+
+// Decode returns parts of the composite key encoded by Encode.
+// It returns ErrInvalidKey if b is not such a key.
+func Decode(b []byte) ([]any, error) {
+	parts := []any{}
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		switch tag {
+		case tagInt64, tagUint64:
+			if len(b) < 8 {
+				return nil, ErrInvalidKey
+			}
+			v := binary.BigEndian.Uint64(b)
+			if tag == tagInt64 {
+				parts = append(parts, int64(v^(1<<63)))
+			} else {
+				parts = append(parts, v)
+			}
+			b = b[8:]
+		case tagString, tagBytes:
+			if len(b) < 4 {
+				return nil, ErrInvalidKey
+			}
+			n := uint64(binary.BigEndian.Uint32(b))
+			b = b[4:]
+			if uint64(len(b)) < n {
+				return nil, ErrInvalidKey
+			}
+			if tag == tagString {
+				parts = append(parts, string(b[:n]))
+			} else {
+				parts = append(parts, append([]byte{}, b[:n]...))
+			}
+			b = b[n:]
+		default:
+			return nil, ErrInvalidKey
+		}
+	}
+	return parts, nil
+}
@@ -0,0 +1,70 @@
+package key
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeOrder(t *testing.T) {
+	/* test numeric parts are compared in numeric order */
+	ordered := [][]byte{
+		Encode(int64(-1), "z"),
+		Encode(int64(0), "z"),
+		Encode(int64(1), "a"),
+		Encode(int64(1), "b"),
+		Encode(int64(256), "a"),
+	}
+	for i := 1; i < len(ordered); i++ {
+		if bytes.Compare(ordered[i-1], ordered[i]) >= 0 {
+			t.Fatalf("failed order check: key %d %x is not less than key %d %x", i-1, ordered[i-1], i, ordered[i])
+		}
+	}
+	if bytes.Compare(Encode(uint64(255)), Encode(uint64(256))) >= 0 {
+		t.Fatalf("failed order check: uint64 255 is not less than 256")
+	}
+}
+
+func TestEncodeInts(t *testing.T) {
+	/* test integers of any size are encoded as int64 and uint64 */
+	if !bytes.Equal(Encode(-1, int8(2), int16(3), int32(4)), Encode(int64(-1), int64(2), int64(3), int64(4))) {
+		t.Fatalf("failed result check: signed integers are not encoded as int64")
+	}
+	if !bytes.Equal(Encode(uint(1), uint8(2), uint16(3), uint32(4)), Encode(uint64(1), uint64(2), uint64(3), uint64(4))) {
+		t.Fatalf("failed result check: unsigned integers are not encoded as uint64")
+	}
+	if bytes.Compare(Encode(-1), Encode(1)) >= 0 {
+		t.Fatalf("failed order check: int -1 is not less than 1")
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	/* test unsupported parts fail instead of panic */
+	for _, part := range []any{1.5, true, nil, struct{}{}} {
+		if _, err := EncodeErr("user", part); !errors.Is(err, ErrUnsupportedPart) {
+			t.Fatalf("failed EncodeErr check of %#v: err: %v, expected: %v", part, err, ErrUnsupportedPart)
+		}
+		if b := Encode("user", part); b != nil {
+			t.Fatalf("failed Encode check of %#v: key: %x, expected: nil", part, b)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	/* test parts are decoded back with their types */
+	parts := []any{int64(-42), uint64(42), "user", []byte{0, 1, 2}, ""}
+	got, err := Decode(Encode(parts...))
+	if err != nil {
+		t.Fatalf("failed Decode with error: %v", err)
+	}
+	if !reflect.DeepEqual(got, parts) {
+		t.Fatalf("failed result check: parts: %#v, expected: %#v", got, parts)
+	}
+
+	for _, b := range [][]byte{{0xff}, {tagInt64, 1}, {tagString, 0, 0, 0, 5, 'a'}} {
+		if _, err := Decode(b); err != ErrInvalidKey {
+			t.Fatalf("failed Decode check of %x: err: %v, expected: %v", b, err, ErrInvalidKey)
+		}
+	}
+}
@@ -1,14 +1,52 @@
 package kvdb
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type T struct {
-	mu     sync.RWMutex
-	spaces map[string]Space
-	closed bool
-	wr     writer
+	mu       sync.RWMutex
+	dir      string
+	spaces   map[string]Space
+	closed   bool
+	loaded   bool                    // Load is done
+	registry map[string]SpaceOptions // options of spaces given by RegisterSpace
+	wr       writer
+	opts     Options
+	lock     *os.File
+
+	hooks          hooks
+	compactions    atomic.Uint64
+	lastCompaction atomic.Int64 // unix nanoseconds
+	compactionRate float64      // bytes written per second by the last Compact
+	loadDuration   time.Duration
+	load           loadProgress
+	errs           errorStats
+	metrics        writerMetrics
+	aliveTotal     atomic.Uint64 // records of all spaces, see RecordCount
+	commitPoint    atomic.Uint64 // LSN of the last Checkpoint
+	createdAt      time.Time     // see CREATED_FILE
+
+	migrations  sync.Mutex // serialises RunMigration
+	checkpoints sync.Mutex // serialises writes of CHECKPOINT_FILE
+
+	watchers     watchers
+	reaper       chan struct{}  // closed to stop reapLoop
+	reapInterval time.Duration  // see Options.TTLReapInterval
+	reaping      sync.WaitGroup // done when reapLoop exits
 }
 
 type GetSpace func(name string) *Space
@@ -16,36 +54,193 @@ type applyTxnFunc func(txn *operation) (uint64, error)
 
 // Open opens a new database at the given path.
 // If the database does not exist, it will be created.
+// The database directory is locked exclusively,
+// Open blocks while it is locked by someone else.
 func Open(path string) (*T, error) {
-	db := &T{}
-	db.spaces = make(map[string]Space)
+	return OpenWithOptions(path, Options{})
+}
 
-	var err error
+// OpenWithOptions opens a new database at the given path with the given options.
+// If the database does not exist, it will be created.
+func OpenWithOptions(path string, opts Options) (*T, error) {
+	return open(path, opts, -1)
+}
 
-	db.wr = newWriter(path)
+// OpenWithLockTimeout opens a new database at the given path like Open,
+// but fails with ErrLockTimeout if the directory lock
+// can not be acquired within timeout.
+// Zero timeout fails immediately if the directory is locked,
+// negative timeout blocks forever (equivalent to Open).
+func OpenWithLockTimeout(path string, timeout time.Duration) (*T, error) {
+	return open(path, Options{}, timeout)
+}
 
-	if err = db.wr.Load(db.applyTxn); err != nil {
+// OpenExclusive creates a new database at the given path and opens it.
+// It fails with ErrDatabaseExists if the directory already holds
+// data files or is locked by another database, an existing
+// empty directory is used. Open opens existing databases.
+func OpenExclusive(path string) (*T, error) {
+	created := true
+	if err := os.Mkdir(path, 0755); errors.Is(err, fs.ErrExist) {
+		created = false
+	} else if err != nil {
 		return nil, err
 	}
 
-	if err = db.wr.Start(); err != nil {
-		_ = db.wr.Close()
+	db, err := newDB(path, Options{}, 0)
+	if errors.Is(err, ErrLockTimeout) && !created {
+		return nil, ErrDatabaseExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		// checked under the lock, as another process may be creating it
+		filePathes, err := listDataFiles(db.opts.storage(), path, []string{SNAP_EXTENSION, JLOG_EXTENSION})
+		if err == nil && len(filePathes) > 0 {
+			err = ErrDatabaseExists
+		}
+		if err != nil {
+			_ = releaseLock(db.lock)
+			return nil, err
+		}
+	}
+	if err = db.Load(); err != nil {
 		return nil, err
 	}
+	return db, nil
+}
 
+// New locks the database at the given path like OpenWithOptions,
+// but does not load it. Spaces may be declared with RegisterSpace,
+// then Load must be called before the database is used.
+func New(path string, opts Options) (*T, error) {
+	return newDB(path, opts, -1)
+}
+
+func open(path string, opts Options, lockTimeout time.Duration) (*T, error) {
+	db, err := newDB(path, opts, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Load(); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
 
-// Space returns the space with the given name.
-// If the space does not exist, it returns nil.
+func newDB(path string, opts Options, lockTimeout time.Duration) (*T, error) {
+	if !opts.SyncMode.valid() {
+		return nil, ErrSyncModeUnknown
+	}
+	if !opts.Compression.valid() {
+		return nil, ErrCompressionUnknown
+	}
+	db := &T{opts: opts, dir: path, registry: map[string]SpaceOptions{}}
+	db.watchers.bufSize = opts.watchBufSize()
+	db.hooks.logger = opts.logger()
+
+	var err error
+
+	if db.lock, err = acquireLock(path, lockTimeout); err != nil {
+		return nil, err
+	}
+
+	db.wr = db.newWriter(db.dir)
+	db.initSpaces(nil)
+	db.reaper = make(chan struct{})
+	db.reapInterval = opts.ttlReapInterval()
+
+	return db, nil
+}
+
+// Load replays data files of the database created by New
+// and starts writing. If Load fails the database is closed.
+func (db *T) Load() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.loaded {
+		return ErrAlreadyLoaded
+	}
+
+	start := time.Now()
+	if err := db.wr.Load(db.replayTxn); err != nil {
+		db.closed = true
+		_ = releaseLock(db.lock)
+		return err
+	}
+	db.loadDuration = time.Since(start)
+	err := db.loadCommitPoint()
+	if err == nil {
+		err = db.loadCreatedAt()
+	}
+	if err != nil {
+		db.closed = true
+		_ = releaseLock(db.lock)
+		return err
+	}
+
+	if err := db.wr.Start(); err != nil {
+		db.closed = true
+		_ = db.wr.Close()
+		_ = releaseLock(db.lock)
+		return err
+	}
+	db.loaded = true
+
+	db.reaping.Add(1)
+	go db.reapLoop(db.reaper, db.reapInterval)
+	return nil
+}
+
+// RegisterSpace declares options of the space before Load,
+// so records replayed from data files are stored in the space
+// ordered by opts.Comparator and decoded with its decoder.
+// It returns ErrAlreadyLoaded after Load.
+func (db *T) RegisterSpace(name string, opts SpaceOptions) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.loaded {
+		return ErrAlreadyLoaded
+	}
+	if err := db.checkSpace(name); err != nil {
+		return err
+	}
+	db.registry[name] = opts
+	return nil
+}
+
+// Space returns the space with the given name,
+// the space is created like NewSpace if it does not exist.
+// Use ExistingSpace to get only existing spaces.
 func (db *T) Space(name string) (*Space, error) {
+	if space, err := db.ExistingSpace(name); err != ErrSpaceNotFound {
+		return space, err
+	}
+	return db.NewSpace(name)
+}
+
+// ExistingSpace returns the space with the given name.
+// If the space does not exist, it returns ErrSpaceNotFound.
+func (db *T) ExistingSpace(name string) (*Space, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	if db.closed {
 		return nil, ErrClosed
 	}
-	return db.space(name, false), nil
+	if space := db.space(name, false); space != nil {
+		return space, nil
+	}
+	return nil, ErrSpaceNotFound
 }
 
 // NewSpace creates a new space with the given name.
@@ -57,36 +252,189 @@ func (db *T) NewSpace(name string) (*Space, error) {
 	if db.closed {
 		return nil, ErrClosed
 	}
+	if err := db.checkSpace(name); err != nil {
+		return nil, err
+	}
 	return db.space(name, true), nil
 }
 
-func (db *T) Snapshot() error {
+// NewSpaceWithEncoder creates a new space like NewSpace,
+// values of the space are serialised with enc and dec instead of JSON.
+// The encoders must be the same every time the database is opened.
+func (db *T) NewSpaceWithEncoder(name string, enc Encoder, dec Decoder) (*Space, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := db.checkSpace(name); err != nil {
+		return nil, err
+	}
+	space := db.space(name, true)
+	space.opts.Encoder = enc
+	space.opts.Decoder = dec
+	return space, nil
+}
+
+// NewSpaceFrom creates a new space with the given name (if it does not exist)
+// and imports operations read from r in JSON Lines format into it.
+// Operations are written to the log with LSNs rebased onto the current LSN,
+// so LSNs of the stream only define the order of operations.
+func (db *T) NewSpaceFrom(name string, r io.Reader) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.closed {
 		return ErrClosed
 	}
+	if err := db.checkSpace(name); err != nil {
+		return err
+	}
+	_ = db.space(name, true)
 
-	spaces := map[string]Space{}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var op operation
+		if err := dec.Decode(&op); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if op.Record == nil {
+			return ErrRecordIsNil
+		}
+		if op.Op != OPERATION_SET && op.Op != OPERATION_DEL {
+			return ErrOperationUnknownType
+		}
+		op.Record.Tag = name
+		// the writer assigns the next LSN of the log
+		op.LSN = 0
+
+		if err := db.wr.Write(&op); err != nil {
+			return err
+		}
+		if _, err := db.applyTxn(&op); err != nil {
+			return err
+		}
+		if db.opts.ProgressCallback != nil {
+			db.opts.ProgressCallback(op.LSN)
+		}
+	}
+	return nil
+}
+
+// SpaceLens returns number of records of every space.
+// System spaces are not included (see AllSpaceLens).
+func (db *T) SpaceLens() map[string]int {
+	return db.spaceLens(false)
+}
+
+// AllSpaceLens returns number of records of every space including system ones
+func (db *T) AllSpaceLens() map[string]int {
+	return db.spaceLens(true)
+}
+
+func (db *T) spaceLens(withSystem bool) map[string]int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	lens := make(map[string]int, len(db.spaces))
 	for name, space := range db.spaces {
-		spaces[name] = space.View()
+		if !withSystem && isSystemSpace(name) {
+			continue
+		}
+		lens[name] = space.Len()
 	}
+	return lens
+}
 
-	// Send to do snapshot
-	db.wr.Snapshot(&spaces)
+// Snapshot writes all spaces into a new snapshot file
+// and removes data files which are covered by it.
+func (db *T) Snapshot() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	return nil
+	if db.closed {
+		return ErrClosed
+	}
+
+	spaces := db.views()
+	return db.wr.Snapshot(&spaces)
 }
 
-func (db *T) Update(txn func(f GetSpace) error) error {
+// SnapshotTo writes all spaces into a new snapshot file inside destDir.
+// The directory is created if needed. Data files of the database
+// and files already present in destDir are left untouched.
+// Unlike CopyTo it does not copy CREATED_FILE and CHECKPOINT_FILE.
+func (db *T) SnapshotTo(destDir string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.closed {
 		return ErrClosed
 	}
-	return txn(db.getSpaceInner)
+
+	spaces := db.views()
+	return db.wr.SnapshotTo(&spaces, destDir)
+}
+
+// CopyTo writes a compact copy of the database into a new directory destPath.
+// The copy consists of a single snapshot file with all alive records
+// sorted by key per space, jlog files are not copied.
+// CREATED_FILE and CHECKPOINT_FILE are copied as they are.
+// destPath must not exist.
+func (db *T) CopyTo(destPath string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	st := db.opts.storage()
+	if _, err := st.Stat(destPath); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: destPath, Err: fs.ErrExist}
+	}
+	if err := st.MkdirAll(destPath, 0755); err != nil {
+		return err
+	}
+
+	spaces := db.views()
+	if err := db.wr.SnapshotTo(&spaces, destPath); err != nil {
+		return err
+	}
+	filePathes, err := listMetaFiles(st, db.dir)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range filePathes {
+		if err := copyFile(st, filePath, filepath.Join(destPath, filepath.Base(filePath))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update calls txn under the exclusive database lock.
+// It returns sorted names of spaces written by txn,
+// also if txn fails after some writes.
+func (db *T) Update(txn func(f GetSpace) error) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	written := map[string]struct{}{}
+	err := txn(func(name string) *Space {
+		space := db.space(name, false)
+		if space != nil {
+			space.wr = writeTracker{writer: space.wr, name: name, written: written}
+		}
+		return space
+	})
+	return slices.Sorted(maps.Keys(written)), err
 }
 
 func (db *T) View(txn func(f GetSpace) error) error {
@@ -100,24 +448,78 @@ func (db *T) View(txn func(f GetSpace) error) error {
 }
 
 // Close closes the database and releases all resources.
+// Writes queued before Close are written, their failures
+// are returned joined with errors of closing.
 func (db *T) Close() (err error) {
+	// reapLoop takes the lock, so it is waited for after the lock is released
+	defer db.reaping.Wait()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.closed {
 		return ErrClosed
 	}
-	err = db.wr.Close()
+	err = errors.Join(db.wr.Close(), releaseLock(db.lock))
+	db.watchers.stopAll()
+	close(db.reaper)
 	db.closed = true
 	db.spaces = nil
 	return
 }
 
-// called only on load, so we dont need additional locks
+// GracefulClose closes the database like Close, but waits for queued writes
+// only until ctx is done. Then ErrGracefulCloseTimeout is returned,
+// writes still in the queue are rejected and the current jlog file
+// is closed by the writer after the write it is busy with.
+// The directory lock is held until the writer has closed the file.
+func (db *T) GracefulClose(ctx context.Context) error {
+	defer db.reaping.Wait()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	lock := db.lock
+	err := db.wr.CloseContext(ctx, func() error { return releaseLock(lock) })
+	db.watchers.stopAll()
+	close(db.reaper)
+	db.closed = true
+	db.spaces = nil
+	return err
+}
+
+// HardClose closes the database without processing queued writes
+// and without syncing the current jlog file.
+// It always returns ErrDataLossRisk, joined with the close error if any.
+func (db *T) HardClose() error {
+	defer db.reaping.Wait()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	err := errors.Join(db.wr.HardClose(), releaseLock(db.lock))
+	db.watchers.stopAll()
+	close(db.reaper)
+	db.closed = true
+	db.spaces = nil
+	if err != nil {
+		return errors.Join(ErrDataLossRisk, err)
+	}
+	return ErrDataLossRisk
+}
+
+// applies operation to the spaces
+// called on load or under the database lock, so we dont need additional locks
 func (db *T) applyTxn(txn *operation) (uint64, error) {
-	txn.Record.LSN = txn.LSN
+	txn.upgradeRecord()
 	switch txn.Op {
 	case OPERATION_SET:
+		if err := db.checkSpace(txn.Record.Tag); err != nil {
+			return 0, err
+		}
 		space := db.space(txn.Record.Tag, true)
 		if _, err := space.treeSet(txn.Record); err != nil {
 			return 0, err
@@ -128,6 +530,8 @@ func (db *T) applyTxn(txn *operation) (uint64, error) {
 				return 0, err
 			}
 		}
+	case truncateAll:
+		db.truncateAll()
 	default:
 		return 0, ErrOperationUnknownType
 	}
@@ -135,6 +539,44 @@ func (db *T) applyTxn(txn *operation) (uint64, error) {
 	return txn.LSN, nil
 }
 
+// newWriter creates a writer of dir
+// which passes written operations to db.notify
+func (db *T) newWriter(dir string) writer {
+	w := newWriter(dir, db.opts)
+	w.onWrite = db.notify
+	w.load = &db.load
+	w.errs = &db.errs
+	w.metrics = &db.metrics
+	w.onSnapshot = db.hooks.snapshotted
+	return w
+}
+
+// notify passes operation written to the log to the OnWrite hook and watchers.
+// It is called from the writer goroutine.
+func (db *T) notify(op *operation) {
+	ev, ok := op.writeEvent(false)
+	if !ok {
+		return
+	}
+	if db.opts.OnWrite != nil {
+		db.opts.OnWrite(ev)
+	}
+	db.watchers.dispatch(op)
+}
+
+// replayTxn applies operation loaded from data files
+// and passes it to the OnWrite hook
+func (db *T) replayTxn(txn *operation) (uint64, error) {
+	lsn, err := db.applyTxn(txn)
+	if err != nil || db.opts.OnWrite == nil {
+		return lsn, err
+	}
+	if ev, ok := txn.writeEvent(true); ok {
+		db.opts.OnWrite(ev)
+	}
+	return lsn, nil
+}
+
 func (db *T) space(name string, create bool) *Space {
 	if sp, ok := db.spaces[name]; ok {
 		return &sp
@@ -143,10 +585,136 @@ func (db *T) space(name string, create bool) *Space {
 		return nil
 	}
 	sp := newSpace(name, db.wr)
+	if opts, ok := db.registry[name]; ok {
+		sp = newSpaceWithComparator(name, db.wr, opts.Comparator)
+		sp.opts = &opts
+		sp.initIndex()
+	}
+	db.bindSpace(&sp)
 	db.spaces[name] = sp
 	return &sp
 }
 
+// initSpaces creates empty spaces defined by Options.Spaces.
+// Spaces of prev with custom SpaceOptions are created as well
+// to keep their serialisation and TTL.
+func (db *T) initSpaces(prev map[string]Space) {
+	db.spaces = make(map[string]Space)
+	db.aliveTotal.Store(0)
+	for _, def := range db.opts.Spaces {
+		db.spaces[def.Name] = newSpaceWithComparator(def.Name, db.wr, def.Comparator)
+	}
+	for name, space := range prev {
+		sp, ok := db.spaces[name]
+		if !ok && !space.opts.configured() {
+			continue
+		}
+		if !ok {
+			sp = newSpaceWithComparator(name, db.wr, space.opts.Comparator)
+		}
+		sp.opts = space.opts
+		sp.initIndex()
+		db.spaces[name] = sp
+	}
+	for name, sp := range db.spaces {
+		db.bindSpace(&sp)
+		db.spaces[name] = sp
+	}
+}
+
+// truncateAll removes records of all spaces like initSpaces,
+// system spaces keep their records
+func (db *T) truncateAll() {
+	system := map[string]Space{}
+	for name, space := range db.spaces {
+		if isSystemSpace(name) {
+			system[name] = space
+		}
+	}
+	db.initSpaces(db.spaces)
+	for name, space := range system {
+		db.spaces[name] = space
+		db.aliveTotal.Add(uint64(space.Len()))
+	}
+}
+
+// bindSpace makes the space notify watchers
+// and count records of the database
+func (db *T) bindSpace(sp *Space) {
+	sp.watchers = &db.watchers
+	sp.alive = &db.aliveTotal
+}
+
+// checkSpace returns ErrUnexpectedSpace if spaces are defined
+// by Options.Spaces and name is not one of them.
+// System spaces are always allowed.
+func (db *T) checkSpace(name string) error {
+	if len(db.opts.Spaces) == 0 || isSystemSpace(name) {
+		return nil
+	}
+	for _, def := range db.opts.Spaces {
+		if def.Name == name {
+			return nil
+		}
+	}
+	return ErrUnexpectedSpace
+}
+
+// isSystemSpace reports whether the space is used by kvdb itself.
+// System spaces are named like __name__.
+func isSystemSpace(name string) bool {
+	return len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
+}
+
+// views returns frozen copies of all spaces
+func (db *T) views() map[string]Space {
+	spaces := map[string]Space{}
+	for name, space := range db.spaces {
+		spaces[name] = space.View()
+	}
+	return spaces
+}
+
 func (db *T) getSpaceInner(name string) *Space {
 	return db.space(name, false)
 }
+
+// writeTracker collects names of spaces written through it
+type writeTracker struct {
+	writer
+	name    string
+	written map[string]struct{}
+}
+
+func (t writeTracker) Write(op *operation) error {
+	return t.track(t.writer.Write(op))
+}
+
+func (t writeTracker) WriteMany(ops []*operation) error {
+	return t.track(t.writer.WriteMany(ops))
+}
+
+func (t writeTracker) Exec(fn execFunc) error {
+	written := false
+	err := t.writer.Exec(func(write func(*operation) error) error {
+		return fn(func(op *operation) error {
+			written = true
+			return write(op)
+		})
+	})
+	if written {
+		t.written[t.name] = struct{}{}
+	}
+	return err
+}
+
+func (t writeTracker) Upsert(fn upsertFunc, apply func(op *operation)) error {
+	return t.track(t.writer.Upsert(fn, apply))
+}
+
+func (t writeTracker) track(err error) error {
+	if err == nil {
+		t.written[t.name] = struct{}{}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package kvdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const LOCK_FILE = "kvdb.lock"
+
+const maxLockBackoff = 100 * time.Millisecond
+
+var errLockBusy = errors.New("lock is held by another owner")
+
+// acquireLock takes exclusive lock of the database directory.
+// Negative timeout blocks until the lock is acquired,
+// zero timeout fails immediately if the lock is held.
+func acquireLock(dir string, timeout time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fh, err := os.OpenFile(filepath.Join(dir, LOCK_FILE), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout < 0 {
+		if err := lockFile(fh, true); err != nil {
+			fh.Close()
+			return nil, err
+		}
+		return fh, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		err := lockFile(fh, false)
+		if err == nil {
+			return fh, nil
+		}
+		if err != errLockBusy {
+			fh.Close()
+			return nil, err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			fh.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(min(backoff, wait))
+		backoff = min(backoff*2, maxLockBackoff)
+	}
+}
+
+// releaseLock releases lock taken by acquireLock
+func releaseLock(fh *os.File) error {
+	if fh == nil {
+		return nil
+	}
+	if err := unlockFile(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package kvdb
+
+import "os"
+
+// file locking is not supported on this platform
+func lockFile(*os.File, bool) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
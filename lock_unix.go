@@ -0,0 +1,24 @@
+//go:build unix
+
+package kvdb
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(fh *os.File, block bool) error {
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(fh.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		return errLockBusy
+	}
+	return err
+}
+
+func unlockFile(fh *os.File) error {
+	return syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,28 @@
+package kvdb
+
+// CurrentLSN returns LSN of the last written operation,
+// 0 if the database is closed
+func (db *T) CurrentLSN() uint64 {
+	lsn, _ := db.lsn()
+	return lsn
+}
+
+// LastSnapshotLSN returns LSN covered by the latest snapshot file,
+// 0 if there is no snapshot or the database is closed
+func (db *T) LastSnapshotLSN() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0
+	}
+	filePathes, err := db.wr.DataFiles()
+	if err != nil || len(filePathes) == 0 || dataFileType(filePathes[0]) != SNAP_EXTENSION {
+		return 0
+	}
+	lsn, err := getFileLsn(filePathes[0])
+	if err != nil {
+		return 0
+	}
+	return lsn
+}
@@ -0,0 +1,88 @@
+package kvdb
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Metrics is a snapshot of operational counters of the database
+type Metrics struct {
+	RecordsAlive     uint64 // records stored in all spaces
+	RecordsDead      uint64 // operations in data files which do not back alive records
+	WritesTotal      uint64 // operations written to the log since open
+	WriteBytesTotal  uint64 // bytes written to the log since open
+	CompactionsTotal uint64 // successful Compact calls since open
+	SnapshotsTotal   uint64 // snapshots written since open
+	CurrentLSN       uint64
+	WriterQueueDepth int // tasks queued to the writer
+	JlogFileCount    int // number of actual jlog files
+}
+
+// writerMetrics collects counters of Metrics,
+// it is updated by the writer goroutine
+type writerMetrics struct {
+	writes     atomic.Uint64
+	writeBytes atomic.Uint64
+	snapshots  atomic.Uint64
+}
+
+// Metrics returns operational counters of the database,
+// zero Metrics if it is closed
+func (db *T) Metrics() Metrics {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return Metrics{}
+	}
+	m := Metrics{
+		WritesTotal:      db.metrics.writes.Load(),
+		WriteBytesTotal:  db.metrics.writeBytes.Load(),
+		CompactionsTotal: db.compactions.Load(),
+		SnapshotsTotal:   db.metrics.snapshots.Load(),
+		RecordsAlive:     db.aliveTotal.Load(),
+		CurrentLSN:       db.wr.LSN(),
+		WriterQueueDepth: db.wr.Pending(),
+	}
+	if ops := db.wr.Ops(); ops > m.RecordsAlive {
+		m.RecordsDead = ops - m.RecordsAlive
+	}
+	// data files can not be listed only if the directory is gone
+	filePathes, _ := db.wr.DataFiles()
+	for _, filePath := range filePathes {
+		if dataFileType(filePath) == JLOG_EXTENSION {
+			m.JlogFileCount++
+		}
+	}
+	return m
+}
+
+// WriteTo writes the metrics in Prometheus text format,
+// it returns the number of bytes written
+func (m Metrics) WriteTo(w io.Writer) (int64, error) {
+	metrics := []struct {
+		name, kind, help string
+		value            any
+	}{
+		{"kvdb_records_alive", "gauge", "Records stored in all spaces.", m.RecordsAlive},
+		{"kvdb_records_dead", "gauge", "Operations in data files which do not back alive records.", m.RecordsDead},
+		{"kvdb_writes_total", "counter", "Operations written to the log.", m.WritesTotal},
+		{"kvdb_write_bytes_total", "counter", "Bytes written to the log.", m.WriteBytesTotal},
+		{"kvdb_compactions_total", "counter", "Successful compactions.", m.CompactionsTotal},
+		{"kvdb_snapshots_total", "counter", "Snapshots written.", m.SnapshotsTotal},
+		{"kvdb_current_lsn", "gauge", "LSN of the last written operation.", m.CurrentLSN},
+		{"kvdb_writer_queue_depth", "gauge", "Tasks queued to the writer.", m.WriterQueueDepth},
+		{"kvdb_jlog_files", "gauge", "Number of actual jlog files.", m.JlogFileCount},
+	}
+	var total int64
+	for _, metric := range metrics {
+		n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n",
+			metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,73 @@
+package kvdb
+
+import "strconv"
+
+// SCHEMA_VERSION_KEY is a metadata key of the version of the last
+// migration done by RunMigration
+const SCHEMA_VERSION_KEY = "schema_version"
+
+// GetMetadata returns the value set by SetMetadata for the key,
+// ErrNotFound if there is none
+func (db *T) GetMetadata(key string) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return "", ErrClosed
+	}
+	meta := db.space(METADATA_SPACE, false)
+	if meta == nil {
+		return "", ErrNotFound
+	}
+	var value string
+	if err := meta.Get(metadataKey(key), &value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// SetMetadata stores the value for the key in METADATA_SPACE
+func (db *T) SetMetadata(key, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.space(METADATA_SPACE, true).Set(metadataKey(key), value)
+}
+
+// metadataKey returns the key of METADATA_SPACE storing
+// metadata of the database, keys of spaces metadata are
+// prefixed by their kind like schema:name
+func metadataKey(key string) []byte {
+	return []byte("meta:" + key)
+}
+
+// RunMigration calls fn once for every version of the schema. fn is not
+// called if the version stored under SCHEMA_VERSION_KEY is the same
+// or greater, otherwise the version is stored after fn succeeds.
+// If fn fails its error is returned and the version is kept.
+// Migrations are run one at a time, fn may use all methods of db.
+func (db *T) RunMigration(version int, fn func(db *T) error) error {
+	db.migrations.Lock()
+	defer db.migrations.Unlock()
+
+	current := 0
+	switch stored, err := db.GetMetadata(SCHEMA_VERSION_KEY); err {
+	case ErrNotFound:
+	case nil:
+		if current, err = strconv.Atoi(stored); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if version <= current {
+		return nil
+	}
+	if err := fn(db); err != nil {
+		return err
+	}
+	return db.SetMetadata(SCHEMA_VERSION_KEY, strconv.Itoa(version))
+}
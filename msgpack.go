@@ -0,0 +1,90 @@
+package kvdb
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MSGPACK_EXTENSION is added to names of data files written by MsgpackCodec
+const MSGPACK_EXTENSION = "mp"
+
+// MsgpackCodec stores operations in MessagePack, which is more compact
+// than JSON. Data files are named like 0000000001.jlog.mp.
+// Values are stored as their JSON representation, so values loaded
+// from data files are the same as with JSONCodec.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Extension() string {
+	return MSGPACK_EXTENSION
+}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (r *record) EncodeMsgpack(enc *msgpack.Encoder) error {
+	stored, err := r.stored()
+	if err != nil {
+		return err
+	}
+	return enc.Encode(stored)
+}
+
+func (r *record) DecodeMsgpack(dec *msgpack.Decoder) error {
+	var stored storedRecord
+	if err := dec.Decode(&stored); err != nil {
+		return err
+	}
+	stored.Value = jsonNumbers(stored.Value)
+	r.restore(stored)
+	return nil
+}
+
+// jsonNumbers converts numbers of a decoded MessagePack value
+// to float64, like they are decoded from JSON
+func jsonNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = jsonNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return v
+}
@@ -1,17 +1,21 @@
 package kvdb
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"time"
 )
 
 // operation is what actually stored in the log file
 type operation struct {
-	LSN    uint64  `json:"lsn"`
-	Op     oType   `json:"op"`
-	Time   int64   `json:"time"` // unix timestamp
-	Record *record `json:"record"`
+	LSN      uint64            `json:"lsn"`
+	Op       oType             `json:"op"`
+	Time     int64             `json:"time"` // unix timestamp
+	Record   *record           `json:"record"`
+	Metadata map[string]string `json:"meta,omitempty"`
+	Expires  int64             `json:"expires,omitempty"` // unix nanoseconds, 0 if never
 }
 
 // operation type
@@ -23,24 +27,36 @@ const (
 	begin         oType = "begin"
 	commit        oType = "commit"
 	rollback      oType = "rollback"
+	truncateAll   oType = "truncate_all"
 )
 
 func newOperation(r *record, op oType) operation {
-	return operation{
+	return newOperationAt(r, op, time.Now())
+}
+
+func newOperationAt(r *record, op oType, ts time.Time) operation {
+	o := operation{
 		Op:     op,
-		Time:   time.Now().Unix(),
+		Time:   ts.Unix(),
 		Record: r,
 	}
+	if r != nil {
+		o.Metadata = r.Meta
+		o.Expires = r.Expires
+	}
+	return o
 }
 
 func operationsFromRecords(records []*record, op oType) []*operation {
 	operations := make([]*operation, 0, len(records))
 	for _, r := range records {
 		operations = append(operations, &operation{
-			LSN:    r.LSN,
-			Op:     op,
-			Time:   r.Time,
-			Record: r,
+			LSN:      r.LSN,
+			Op:       op,
+			Time:     r.Time,
+			Record:   r,
+			Metadata: r.Meta,
+			Expires:  r.Expires,
 		})
 	}
 	return operations
@@ -52,6 +68,29 @@ func (op *operation) upgradeRecord() {
 	}
 	op.Record.LSN = op.LSN
 	op.Record.Time = op.Time
+	op.Record.Meta = op.Metadata
+	op.Record.Expires = op.Expires
+}
+
+// EncodeTo writes the operation as a JSON line to w
+// like it is stored in data files by default
+func (op *operation) EncodeTo(w io.Writer) error {
+	return writeTo(op, w, JSONCodec{})
+}
+
+// writeEvent describes set and del operations for Options.OnWrite
+func (op *operation) writeEvent(replay bool) (WriteEvent, bool) {
+	if op.Record == nil || (op.Op != OPERATION_SET && op.Op != OPERATION_DEL) {
+		return WriteEvent{}, false
+	}
+	return WriteEvent{
+		LSN:    op.LSN,
+		Op:     string(op.Op),
+		Space:  op.Record.Tag,
+		Key:    bytes.Clone(op.Record.Key),
+		Meta:   op.Metadata,
+		Replay: replay,
+	}, true
 }
 
 func (o oType) MarshalJSON() ([]byte, error) {
@@ -66,6 +105,8 @@ func (o oType) MarshalJSON() ([]byte, error) {
 		return []byte(`"commit"`), nil
 	case rollback:
 		return []byte(`"rollback"`), nil
+	case truncateAll:
+		return []byte(`"truncate_all"`), nil
 	default:
 		return nil, errors.New("unknown operation type")
 	}
@@ -87,6 +128,8 @@ func (o *oType) UnmarshalJSON(data []byte) error {
 		*o = commit
 	case "rollback":
 		*o = rollback
+	case "truncate_all":
+		*o = truncateAll
 	default:
 		return errors.New("unknown operation type")
 	}
@@ -0,0 +1,186 @@
+package kvdb
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Options configures the database opened with OpenWithOptions.
+// Zero value is valid and used by Open.
+type Options struct {
+	// ProgressCallback is called with the LSN of every operation
+	// imported by NewSpaceFrom
+	ProgressCallback func(lsn uint64)
+
+	// LoadProgressCallback is called while data files are loaded on open,
+	// every LoadProgressInterval bytes and when the file is read completely
+	LoadProgressCallback func(filePath string, bytesRead, totalBytes int64)
+
+	// LoadProgressInterval is a number of bytes read between calls
+	// of LoadProgressCallback, DEFAULT_LOAD_PROGRESS_INTERVAL if not set
+	LoadProgressInterval int64
+
+	// OnWrite is called with every set and del operation written to the log
+	// and with every operation replayed from data files on open.
+	// It is called from the writer goroutine, so it must not block
+	// and must not call methods of the database.
+	OnWrite func(ev WriteEvent)
+
+	// WatchBufSize is a capacity of channels of watchers of Space.Watch,
+	// DEFAULT_WATCH_BUF_SIZE if not set
+	WatchBufSize int
+
+	// SnapshotProgressCallback is called while a snapshot is written:
+	// before every space, every SnapshotProgressInterval records
+	// and when all spaces are written. recordsTotal is the number
+	// of records in all spaces when the snapshot started.
+	SnapshotProgressCallback func(spacesDone, spacesTotal int, recordsDone, recordsTotal int64)
+
+	// SnapshotProgressInterval is a number of records written between
+	// calls of SnapshotProgressCallback, DEFAULT_SNAPSHOT_PROGRESS_INTERVAL if not set
+	SnapshotProgressInterval int
+
+	// Logger receives structured events of the database,
+	// slog.Default() if not set
+	Logger *slog.Logger
+
+	// Codec encodes operations in data files, JSONCodec by default.
+	// See MsgpackCodec for a more compact binary encoding.
+	Codec Codec
+
+	// Storage stores data files, OSStorage by default.
+	// The directory lock is always taken in the local filesystem.
+	Storage Storage
+
+	// Compression defines how snapshots are compressed, CompressionNone
+	// by default. Snapshots are read whatever compression they were
+	// written with, so it may be changed between opens.
+	Compression Compression
+
+	// IncomingBufSize is a capacity of the writer queue,
+	// DEFAULT_INCOMING_BUF_SIZE if not set
+	IncomingBufSize int
+
+	// IncomingHighWaterMark, if set, makes writes fail with ErrWriterBusy
+	// instead of blocking when the writer queue holds so many tasks
+	IncomingHighWaterMark int
+
+	// SyncMode defines when the current jlog file is synced,
+	// SyncNone by default. It can be changed with SetSyncMode.
+	SyncMode SyncMode
+
+	// MaxPauseDuration limits a pause of PauseWrites,
+	// DEFAULT_MAX_PAUSE_DURATION if not set
+	MaxPauseDuration time.Duration
+
+	// TTLReapInterval is an interval between scans removing expired records,
+	// DEFAULT_TTL_REAP_INTERVAL if not set
+	TTLReapInterval time.Duration
+
+	// CompactionRatio, if set, makes Compact skip the snapshot
+	// while dead/(alive+dead) ratio of records is below it
+	CompactionRatio float64
+
+	// LazyLoad makes open load only the latest snapshot, jlog files
+	// written after it are not replayed but are available with ReplayRange.
+	// It is correct only if the snapshot is trusted to be complete.
+	// Without a snapshot all files are loaded. While jlog files are skipped
+	// Snapshot, Compact, Defrag and Space.Compact fail with ErrLazyLoaded,
+	// they would remove the skipped operations.
+	LazyLoad bool
+
+	// Spaces defines all spaces of the database.
+	// If set, spaces are created on open and any other space name
+	// (in data files or in NewSpace) fails with ErrUnexpectedSpace
+	Spaces []SpaceDefinition
+}
+
+// WriteEvent describes an operation passed to Options.OnWrite
+type WriteEvent struct {
+	LSN    uint64
+	Op     string // "set" or "del"
+	Space  string
+	Key    []byte
+	Meta   map[string]string // metadata given to Space.SetWithMeta
+	Replay bool              // operation is replayed from data files
+}
+
+// SpaceDefinition describes a space created on open
+type SpaceDefinition struct {
+	Name string
+
+	// Comparator orders keys of the space, bytewise by default
+	Comparator func(a, b []byte) int
+}
+
+// codec returns configured codec or the default one
+func (opts Options) codec() Codec {
+	if opts.Codec == nil {
+		return JSONCodec{}
+	}
+	return opts.Codec
+}
+
+// storage returns configured storage or the default one
+func (opts Options) storage() Storage {
+	if opts.Storage == nil {
+		return OSStorage{}
+	}
+	return opts.Storage
+}
+
+// logger returns configured logger or the default one
+func (opts Options) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.Default()
+	}
+	return opts.Logger
+}
+
+// watchBufSize returns configured watcher channel capacity or the default one
+func (opts Options) watchBufSize() int {
+	if opts.WatchBufSize <= 0 {
+		return DEFAULT_WATCH_BUF_SIZE
+	}
+	return opts.WatchBufSize
+}
+
+// loadProgressInterval returns configured load progress interval or the default one
+func (opts Options) loadProgressInterval() int64 {
+	if opts.LoadProgressInterval <= 0 {
+		return DEFAULT_LOAD_PROGRESS_INTERVAL
+	}
+	return opts.LoadProgressInterval
+}
+
+// snapshotProgressInterval returns configured snapshot progress interval or the default one
+func (opts Options) snapshotProgressInterval() int {
+	if opts.SnapshotProgressInterval <= 0 {
+		return DEFAULT_SNAPSHOT_PROGRESS_INTERVAL
+	}
+	return opts.SnapshotProgressInterval
+}
+
+// ttlReapInterval returns configured TTL reap interval or the default one
+func (opts Options) ttlReapInterval() time.Duration {
+	if opts.TTLReapInterval <= 0 {
+		return DEFAULT_TTL_REAP_INTERVAL
+	}
+	return opts.TTLReapInterval
+}
+
+// incomingBufSize returns configured writer queue capacity or the default one
+func (opts Options) incomingBufSize() int {
+	if opts.IncomingBufSize <= 0 {
+		return DEFAULT_INCOMING_BUF_SIZE
+	}
+	return opts.IncomingBufSize
+}
+
+// maxPauseDuration returns configured pause limit or the default one
+func (opts Options) maxPauseDuration() time.Duration {
+	if opts.MaxPauseDuration <= 0 {
+		return DEFAULT_MAX_PAUSE_DURATION
+	}
+	return opts.MaxPauseDuration
+}
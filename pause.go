@@ -0,0 +1,53 @@
+package kvdb
+
+import (
+	"sync"
+	"time"
+)
+
+// DEFAULT_MAX_PAUSE_DURATION is used if Options.MaxPauseDuration is not set
+const DEFAULT_MAX_PAUSE_DURATION = time.Minute
+
+// PauseWrites stops writing to data files, for example to copy them
+// consistently. It returns after all writes queued before it are written.
+// Writes issued while paused block until resume is called or
+// Options.MaxPauseDuration passes. resume may be called many times.
+// Close waits for the pause to end.
+func (db *T) PauseWrites() (resume func(), err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	resumed := make(chan struct{})
+	var once sync.Once
+	resume = func() {
+		once.Do(func() { close(resumed) })
+	}
+
+	paused := make(chan struct{})
+	failed := make(chan error, 1)
+	timeout := db.opts.maxPauseDuration()
+	go func() {
+		// the writer goroutine is held by the task until resumed
+		failed <- db.wr.Exec(func(func(*operation) error) error {
+			close(paused)
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-resumed:
+			case <-timer.C:
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-paused:
+		return resume, nil
+	case err := <-failed:
+		return nil, err
+	}
+}
@@ -0,0 +1,73 @@
+package kvdb
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// DEFAULT_LOAD_PROGRESS_INTERVAL is used if Options.LoadProgressInterval is not set
+const DEFAULT_LOAD_PROGRESS_INTERVAL = 1 << 20
+
+// DEFAULT_SNAPSHOT_PROGRESS_INTERVAL is used if Options.SnapshotProgressInterval is not set
+const DEFAULT_SNAPSHOT_PROGRESS_INTERVAL = 10000
+
+// ProgressReader wraps io.Reader and calls callback
+// every `every` bytes read and once more when reading is finished
+type ProgressReader struct {
+	r        io.Reader
+	total    int64
+	every    int64
+	read     int64
+	reported int64
+	callback func(bytesRead, totalBytes int64)
+}
+
+// NewProgressReader returns a new ProgressReader wrapping the given reader.
+// totalBytes is passed to callback as is, every <= 0 reports every Read.
+func NewProgressReader(r io.Reader, totalBytes, every int64, callback func(bytesRead, totalBytes int64)) *ProgressReader {
+	return &ProgressReader{r: r, total: totalBytes, every: every, callback: callback}
+}
+
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.read += int64(n)
+	if pr.read > pr.reported && (pr.read-pr.reported >= pr.every || err != nil) {
+		pr.reported = pr.read
+		pr.callback(pr.read, pr.total)
+	}
+	return n, err
+}
+
+// BytesRead returns number of bytes read
+func (pr *ProgressReader) BytesRead() int64 {
+	return pr.read
+}
+
+// loadProgress tracks bytes of data files read on load
+type loadProgress struct {
+	bytesLoaded atomic.Int64
+	bytesTotal  atomic.Int64
+}
+
+// start resets the progress to load the given files
+func (lp *loadProgress) start(st Storage, filePathes []string) error {
+	var total int64
+	for _, filePath := range filePathes {
+		fi, err := st.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		total += fi.Size()
+	}
+	lp.bytesLoaded.Store(0)
+	lp.bytesTotal.Store(total)
+	return nil
+}
+
+// LoadProgress returns number of bytes of data files loaded so far
+// and total size of data files to load on open.
+// It does not wait for the database lock, so it can be called
+// while Restore, Defrag or RewindTo loads the database again.
+func (db *T) LoadProgress() (bytesLoaded int64, bytesTotal int64) {
+	return db.load.bytesLoaded.Load(), db.load.bytesTotal.Load()
+}
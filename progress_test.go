@@ -0,0 +1,42 @@
+package kvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDataFileProgress(t *testing.T) {
+	/* test progress is reported while loading a file larger than one buffer */
+	var content strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&content, `{"lsn":%d,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-%d","value":{"name":"Alice-%d","age":%d}}}`+"\n", i+1, i, i, i)
+	}
+	filePath := filepath.Join(t.TempDir(), "0000000001.jlog")
+	if err := os.WriteFile(filePath, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("failed to write jlog file: %v", err)
+	}
+
+	calls := 0
+	var lastRead, lastTotal int64
+	_, _, err := loadDataFile(OSStorage{}, filePath, func(op *operation) (uint64, error) {
+		return op.LSN, nil
+	}, JSONCodec{}, func(bytesRead, totalBytes int64) {
+		if bytesRead < lastRead {
+			t.Fatalf("failed progress check: bytesRead decreased from %d to %d", lastRead, bytesRead)
+		}
+		calls++
+		lastRead, lastTotal = bytesRead, totalBytes
+	}, 8192)
+	if err != nil {
+		t.Fatalf("failed loadDataFile with error: %v", err)
+	}
+	if calls < 2 {
+		t.Fatalf("failed result check: progress called %d times, expected at least %d", calls, 2)
+	}
+	if lastRead != int64(content.Len()) || lastTotal != int64(content.Len()) {
+		t.Fatalf("failed result check: bytesRead: %d, totalBytes: %d, expected: %d", lastRead, lastTotal, content.Len())
+	}
+}
@@ -2,6 +2,7 @@ package kvdb
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"reflect"
 	"sync"
@@ -26,6 +27,42 @@ type record struct {
 	Key   []byte `json:"key"`
 	Tag   string `json:"tag"`
 	Value any    `json:"value"`
+
+	Meta    map[string]string `json:"-"` // metadata of the last operation
+	Expires int64             `json:"-"` // unix nanoseconds, 0 if never
+}
+
+// Record is a public copy of a record stored in a space
+type Record struct {
+	LSN   uint64
+	Time  int64 // unix timestamp
+	Key   []byte
+	Space string
+	Value any
+}
+
+// public returns a copy of the record safe to pass to users
+func (r *record) public() *Record {
+	return &Record{
+		LSN:   r.LSN,
+		Time:  r.Time,
+		Key:   bytes.Clone(r.Key),
+		Space: r.Tag,
+		Value: r.Value,
+	}
+}
+
+// clone returns a copy of the record
+func (r *record) clone() *record {
+	return &record{
+		LSN:     r.LSN,
+		Time:    r.Time,
+		Key:     r.Key,
+		Tag:     r.Tag,
+		Value:   r.Value,
+		Meta:    r.Meta,
+		Expires: r.Expires,
+	}
 }
 
 func (r *record) MarshalJSON() ([]byte, error) {
@@ -48,21 +85,71 @@ func (r *record) MarshalJSON() ([]byte, error) {
 }
 
 func (r *record) UnmarshalJSON(data []byte) error {
-	var tmp struct {
-		Key   string `json:"key"`
-		Tag   string `json:"tag"`
-		Value any    `json:"value"`
+	var stored storedRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
 	}
+	r.restore(stored)
+	return nil
+}
 
-	if err := json.Unmarshal(data, &tmp); err != nil {
-		return err
+// storedRecord is a record as it is stored in data files by any codec
+type storedRecord struct {
+	Tag   string `json:"tag"`
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// stored returns the record to store in data files. The value
+// is converted to its JSON representation decoded into any,
+// so it is stored by every codec as by JSONCodec.
+func (r *record) stored() (storedRecord, error) {
+	raw, err := json.Marshal(r.Value)
+	if err != nil {
+		return storedRecord{}, err
 	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return storedRecord{}, err
+	}
+	return storedRecord{Tag: r.Tag, Key: string(r.Key), Value: value}, nil
+}
 
-	r.Key = []byte(tmp.Key)
-	r.Tag = tmp.Tag
-	r.Value = tmp.Value
+// restore sets fields of the record read from data files
+func (r *record) restore(stored storedRecord) {
+	r.Key = []byte(stored.Key)
+	r.Tag = stored.Tag
+	r.Value = stored.Value
+}
 
-	return nil
+// encodedValue is a value encoded by the space Encoder,
+// it is stored in data files as a base64 string
+type encodedValue []byte
+
+// encoded returns the value encoded by the space Encoder.
+// Values loaded from data files are still base64 strings.
+func (r *record) encoded() ([]byte, error) {
+	switch v := r.Value.(type) {
+	case encodedValue:
+		return v, nil
+	case string:
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return nil, ErrIntoInvalidType
+}
+
+// rawValue returns the JSON encoding of the record value
+func (r *record) rawValue() ([]byte, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(r.Value); err != nil {
+		return nil, err
+	}
+
+	// returned slice must not share memory with the pooled buffer
+	return bytes.Clone(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})), nil
 }
 
 func (r *record) into(into any) error {
@@ -73,22 +160,15 @@ func (r *record) into(into any) error {
 	}
 	*/
 
-	buf := bufPool.Get().(*bytes.Buffer)
-	defer bufPool.Put(buf)
-
-	buf.Reset()
-	enc := json.NewEncoder(buf)
-	dec := json.NewDecoder(buf)
-
-	if err := enc.Encode(r.Value); err != nil {
-		return err
-	}
-	if err := dec.Decode(into); err != nil {
+	if err := r.decodeValue(into); err != nil {
 		return err
 	}
 
 	// if into has field Header type of kvdb.Header
 	v := reflect.ValueOf(into).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
 	num := v.NumField()
 
 	for i := range num {
@@ -104,3 +184,18 @@ func (r *record) into(into any) error {
 
 	return nil
 }
+
+// decodeValue decodes the value into into through its JSON encoding
+func (r *record) decodeValue(into any) error {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+
+	buf.Reset()
+	enc := json.NewEncoder(buf)
+	dec := json.NewDecoder(buf)
+
+	if err := enc.Encode(r.Value); err != nil {
+		return err
+	}
+	return dec.Decode(into)
+}
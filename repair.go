@@ -0,0 +1,130 @@
+package kvdb
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+)
+
+// RepairMode defines how compaction resolves records of spaces
+// which differ from operations stored in data files
+type RepairMode int
+
+const (
+	// RepairKeepBtree trusts records in memory, data files are not read
+	RepairKeepBtree RepairMode = iota
+	// RepairKeepWAL replays data files and rebuilds spaces from them
+	// before the snapshot is written
+	RepairKeepWAL
+)
+
+// CompactWithRepair compacts the database like Compact
+// resolving discrepancies between spaces and data files with mode.
+// Data files are replayed in both modes, every discrepancy is logged
+// as RepairDiscrepancy event, their number is returned in CompactionResult.Repaired.
+func (db *T) CompactWithRepair(mode RepairMode) (CompactionResult, error) {
+	return db.compactAndNotify(mode, true)
+}
+
+// repair replays data files and compares records of spaces with them.
+// With RepairKeepWAL records which differ are replaced.
+// Returns number of discrepancies.
+// Must be called under the database lock.
+func (db *T) repair(mode RepairMode) (int, error) {
+	filePathes, err := db.wr.DataFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	wal := map[string]map[string]*record{}
+	replay := func(op *operation) (uint64, error) {
+		op.upgradeRecord()
+		switch op.Op {
+		case OPERATION_SET:
+			if wal[op.Record.Tag] == nil {
+				wal[op.Record.Tag] = map[string]*record{}
+			}
+			wal[op.Record.Tag][string(op.Record.Key)] = op.Record
+		case OPERATION_DEL:
+			delete(wal[op.Record.Tag], string(op.Record.Key))
+		case truncateAll:
+			maps.DeleteFunc(wal, func(name string, _ map[string]*record) bool {
+				return !isSystemSpace(name)
+			})
+		default:
+			return 0, ErrOperationUnknownType
+		}
+		return op.LSN, nil
+	}
+	for _, filePath := range filePathes {
+		if _, _, err := loadDataFile(db.opts.storage(), filePath, replay, db.opts.codec(), nil, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	logger := db.opts.logger()
+	keep := "btree"
+	if mode == RepairKeepWAL {
+		keep = "wal"
+	}
+	logDiscrepancy := func(space string, key []byte, attrs ...slog.Attr) {
+		attrs = append([]slog.Attr{
+			slog.String("space", space),
+			slog.String("key", string(key)),
+			slog.String("keep", keep),
+		}, attrs...)
+		logger.LogAttrs(context.Background(), slog.LevelWarn, "RepairDiscrepancy", attrs...)
+	}
+
+	repaired := 0
+	for name, space := range db.spaces {
+		records := wal[name]
+		stale := []*record{}
+		space.tree.Scan(func(rec *record) bool {
+			walRec, ok := records[string(rec.Key)]
+			switch {
+			case !ok:
+				logDiscrepancy(name, rec.Key, slog.Uint64("btreeLSN", rec.LSN))
+				stale = append(stale, rec)
+			case walRec.LSN != rec.LSN:
+				logDiscrepancy(name, rec.Key, slog.Uint64("btreeLSN", rec.LSN), slog.Uint64("walLSN", walRec.LSN))
+				repaired++
+				if mode != RepairKeepWAL {
+					delete(records, string(rec.Key))
+				}
+			default:
+				delete(records, string(rec.Key))
+			}
+			return true
+		})
+		repaired += len(stale)
+		if mode != RepairKeepWAL {
+			continue
+		}
+		for _, rec := range stale {
+			_, _ = space.treeDel(rec)
+		}
+	}
+	// records left in wal are missing in spaces or differ from them
+	for name, records := range wal {
+		space := db.space(name, false)
+		for _, rec := range records {
+			found := false
+			if space != nil {
+				_, found = space.treeGet(rec)
+			}
+			if !found {
+				logDiscrepancy(name, rec.Key, slog.Uint64("walLSN", rec.LSN))
+				repaired++
+			}
+		}
+		if mode != RepairKeepWAL {
+			continue
+		}
+		space = db.space(name, true)
+		for _, rec := range records {
+			_, _ = space.treeSet(rec)
+		}
+	}
+	return repaired, nil
+}
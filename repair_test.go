@@ -0,0 +1,83 @@
+package kvdb
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestCompactWithRepair(t *testing.T) {
+	/* test compaction resolves records which differ from data files */
+	dir := t.TempDir()
+	for _, mode := range []RepairMode{RepairKeepBtree, RepairKeepWAL} {
+		logs := &bytes.Buffer{}
+		db, err := OpenWithOptions(dir, Options{Logger: slog.New(slog.NewJSONHandler(logs, nil))})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		users, _ := db.NewSpace("users")
+		for _, key := range []string{"Alice", "Bob"} {
+			if err := users.Set([]byte(key), key); err != nil {
+				t.Fatalf("failed to set %s: %v", key, err)
+			}
+		}
+
+		// inject records which are not in data files
+		_, _ = users.treeSet(&record{Key: []byte("Alice"), Tag: "users", Value: "corrupted", LSN: 1000})
+		_, _ = users.treeSet(&record{Key: []byte("Carol"), Tag: "users", Value: "Carol", LSN: 1001})
+		_, _ = users.treeDel(&record{Key: []byte("Bob")})
+
+		res, err := db.CompactWithRepair(mode)
+		if err != nil {
+			t.Fatalf("failed to compact: %v", err)
+		}
+		expected, keep := map[string]string{"Alice": "corrupted", "Carol": "Carol"}, "btree"
+		if mode == RepairKeepWAL {
+			expected, keep = map[string]string{"Alice": "Alice", "Bob": "Bob"}, "wal"
+		}
+		if res.Repaired != 3 {
+			t.Fatalf("failed result check: mode %d: repaired: %d, expected: %d", mode, res.Repaired, 3)
+		}
+		// every discrepancy is logged in both modes
+		events := strings.Count(logs.String(), `"msg":"RepairDiscrepancy"`)
+		kept := strings.Count(logs.String(), `"keep":"`+keep+`"`)
+		if events != 3 || kept != 3 {
+			t.Fatalf("failed result check: mode %d: logged: %d, kept %s: %d, expected: %d", mode, events, keep, kept, 3)
+		}
+		checkUsers := func(stage string) {
+			users, _ := db.Space("users")
+			if users.Len() != len(expected) {
+				t.Fatalf("failed result check: mode %d %s: len: %d, expected: %d", mode, stage, users.Len(), len(expected))
+			}
+			for key, value := range expected {
+				var got string
+				if err := users.Get([]byte(key), &got); err != nil || got != value {
+					t.Fatalf("failed result check: mode %d %s: %s: %q (%v), expected: %q", mode, stage, key, got, err, value)
+				}
+			}
+		}
+		checkUsers("after compact")
+
+		// snapshot matches spaces
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+		if db, err = Open(dir); err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		checkUsers("after reopen")
+		if res, err := db.CompactWithRepair(RepairKeepWAL); err != nil || res.Repaired != 0 {
+			t.Fatalf("failed to compact consistent db: repaired: %d, error: %v", res.Repaired, err)
+		}
+
+		// clean the database for the next mode
+		users, _ = db.Space("users")
+		for _, key := range users.SortedKeys() {
+			_ = users.Del(key)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	}
+}
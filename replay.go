@@ -0,0 +1,118 @@
+package kvdb
+
+// ReplayRange calls fn with every set and del operation of jlog files
+// with LSN from from to to inclusive in LSN order, zero to means no limit.
+// Events have Replay set. Operations of unfinished transactions are skipped.
+// It allows to read operations which are not loaded with Options.LazyLoad.
+// Iteration stops at the first error returned by fn.
+func (db *T) ReplayRange(from, to uint64, fn func(ev WriteEvent) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	filePathes, err := db.wr.DataFiles()
+	if err != nil {
+		return err
+	}
+	replay := func(op *operation) (uint64, error) {
+		if op.LSN < from || (to != 0 && op.LSN > to) {
+			return op.LSN, nil
+		}
+		if ev, ok := op.writeEvent(true); ok {
+			if err := fn(ev); err != nil {
+				return 0, err
+			}
+		}
+		return op.LSN, nil
+	}
+	for _, filePath := range filePathes {
+		if dataFileType(filePath) != JLOG_EXTENSION {
+			continue
+		}
+		lsn, err := getFileLsn(filePath)
+		if err != nil {
+			return err
+		}
+		if to != 0 && lsn > to {
+			break
+		}
+		if _, _, err := loadDataFile(db.opts.storage(), filePath, replay, db.opts.codec(), nil, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ViewAt calls fn with spaces in the state right after the operation
+// with the given LSN. Data files are replayed up to lsn into temporary
+// spaces, so it is expensive and meant for audit. Writes to the spaces
+// fail with ErrReadOnly. It fails with ErrLSNNotFound if lsn is not written yet
+// and with ErrLSNInSnapshot if the latest snapshot is newer than lsn.
+func (db *T) ViewAt(lsn uint64, fn func(f GetSpace) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if lsn == 0 || lsn > db.wr.LSN() {
+		return ErrLSNNotFound
+	}
+	filePathes, err := db.wr.DataFiles()
+	if err != nil {
+		return err
+	}
+
+	past := db.replayDB()
+	for _, filePath := range filePathes {
+		fileLSN, err := getFileLsn(filePath)
+		if err != nil {
+			return err
+		}
+		if dataFileType(filePath) == SNAP_EXTENSION && fileLSN > lsn {
+			return ErrLSNInSnapshot
+		}
+		if fileLSN > lsn {
+			break
+		}
+		if err := db.replayFile(past, filePath, lsn); err != nil {
+			return err
+		}
+	}
+	return fn(past.getSpaceInner)
+}
+
+// replayDB returns a temporary database with read-only spaces
+// configured like spaces of db, data files are loaded into it by replayFile
+func (db *T) replayDB() *T {
+	past := &T{opts: db.opts, registry: db.registry, wr: readOnlyWriter{}}
+	past.initSpaces(db.spaces)
+	return past
+}
+
+// replayFile applies operations of the data file with LSN up to upTo
+// to spaces of past, zero upTo means no limit
+func (db *T) replayFile(past *T, filePath string, upTo uint64) error {
+	replay := func(op *operation) (uint64, error) {
+		if upTo != 0 && op.LSN > upTo {
+			return op.LSN, nil
+		}
+		return past.applyTxn(op)
+	}
+	_, _, err := loadDataFile(db.opts.storage(), filePath, replay, db.opts.codec(), nil, 0)
+	return err
+}
+
+// readOnlyWriter fails every write of spaces of ViewAt
+type readOnlyWriter struct {
+	writer
+}
+
+func (readOnlyWriter) Write(*operation) error                    { return ErrReadOnly }
+func (readOnlyWriter) WriteMany([]*operation) error              { return ErrReadOnly }
+func (readOnlyWriter) Upsert(upsertFunc, func(*operation)) error { return ErrReadOnly }
+func (readOnlyWriter) Exec(execFunc) error                       { return ErrReadOnly }
+func (readOnlyWriter) TruncateAll() error                        { return ErrReadOnly }
+func (readOnlyWriter) CompactSpace(string, uint64) error         { return ErrReadOnly }
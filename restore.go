@@ -0,0 +1,129 @@
+package kvdb
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Restore replaces the state of the database with a backup stored
+// in srcDir (for example made by CopyTo or SnapshotTo).
+// Data files of srcDir are copied into the data directory under
+// temporary names first, if copying fails the database is left untouched.
+// CREATED_FILE and CHECKPOINT_FILE are restored if srcDir has them,
+// otherwise the creation time is kept and the checkpoint is removed.
+// Then the writer is closed, the copies replace data files
+// of the database and are loaded again.
+// Writes which are not finished before Restore are lost,
+// spaces obtained before Restore must be obtained again.
+// If Restore fails after the writer is closed the database is closed.
+func (db *T) Restore(srcDir string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	st := db.opts.storage()
+	extensions := []string{SNAP_EXTENSION, JLOG_EXTENSION}
+	srcFiles, err := listDataFiles(st, srcDir, extensions)
+	if err != nil {
+		return err
+	}
+	srcMetaFiles, err := listMetaFiles(st, srcDir)
+	if err != nil {
+		return err
+	}
+	srcFiles = append(srcFiles, srcMetaFiles...)
+
+	tmpFiles := make([]string, 0, len(srcFiles))
+	removeTmpFiles := func() {
+		for _, tmpFile := range tmpFiles {
+			_ = st.Remove(tmpFile)
+		}
+	}
+	for _, srcFile := range srcFiles {
+		tmpFile := filepath.Join(db.dir, filepath.Base(srcFile)+"."+INPROGRESS_EXTENSION)
+		tmpFiles = append(tmpFiles, tmpFile)
+		if err := copyFile(st, srcFile, tmpFile); err != nil {
+			removeTmpFiles()
+			return err
+		}
+	}
+	if err := syncStorageDir(st, db.dir); err != nil {
+		removeTmpFiles()
+		return err
+	}
+
+	err = db.reload(func() error {
+		oldFiles, err := listDataFiles(st, db.dir, extensions)
+		if err != nil {
+			removeTmpFiles()
+			return err
+		}
+		restored := make(map[string]struct{}, len(tmpFiles))
+		for _, tmpFile := range tmpFiles {
+			filePath := strings.TrimSuffix(tmpFile, "."+INPROGRESS_EXTENSION)
+			if err := st.Rename(tmpFile, filePath); err != nil {
+				return err
+			}
+			restored[filepath.Base(filePath)] = struct{}{}
+		}
+		if err := syncStorageDir(st, db.dir); err != nil {
+			return err
+		}
+		// old files are removed only when all restored files are in place,
+		// the checkpoint of the database does not match restored files
+		oldFiles = append(oldFiles, filepath.Join(db.dir, CHECKPOINT_FILE))
+		oldFiles = slices.DeleteFunc(oldFiles, func(filePath string) bool {
+			_, ok := restored[filepath.Base(filePath)]
+			return ok
+		})
+		for _, filePath := range oldFiles {
+			if err := st.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.loadCreatedAt()
+}
+
+// reload closes the writer, calls change to modify data files
+// and loads the database from them again.
+// Options of existing spaces are kept.
+// On failure the database is closed.
+// Must be called under the database lock.
+func (db *T) reload(change func() error) (err error) {
+	defer func() {
+		if err != nil {
+			_ = releaseLock(db.lock)
+			db.closed = true
+			db.spaces = nil
+		}
+	}()
+
+	if err = db.wr.Close(); err != nil {
+		return err
+	}
+	if err = change(); err != nil {
+		return err
+	}
+
+	db.wr = db.newWriter(db.dir)
+	db.initSpaces(db.spaces)
+
+	if err = db.wr.Load(db.replayTxn); err != nil {
+		return err
+	}
+	if err = db.loadCommitPoint(); err != nil {
+		return err
+	}
+	return db.wr.Start()
+}
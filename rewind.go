@@ -0,0 +1,65 @@
+package kvdb
+
+// RewindTo rolls the database back to the state right after
+// the operation with the given LSN. Operations written after it
+// are removed from jlog files and the database is loaded again.
+// It fails with ErrLSNInSnapshot if the latest snapshot is newer than lsn,
+// the database is left untouched then.
+// Writes which are not finished before RewindTo are lost,
+// spaces obtained before RewindTo must be obtained again.
+// If RewindTo fails otherwise the database is closed.
+func (db *T) RewindTo(lsn uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	filePathes, err := db.wr.DataFiles()
+	if err != nil {
+		return err
+	}
+	for _, filePath := range filePathes {
+		if dataFileType(filePath) != SNAP_EXTENSION {
+			continue
+		}
+		snapLSN, err := getFileLsn(filePath)
+		if err != nil {
+			return err
+		}
+		if snapLSN > lsn {
+			return ErrLSNInSnapshot
+		}
+	}
+
+	return db.reload(func() error {
+		filePathes, err := db.wr.DataFiles()
+		if err != nil {
+			return err
+		}
+		last := ""
+		toRemove := []string{}
+		for _, filePath := range filePathes {
+			if dataFileType(filePath) != JLOG_EXTENSION {
+				continue
+			}
+			fileLSN, err := getFileLsn(filePath)
+			if err != nil {
+				return err
+			}
+			if fileLSN > lsn {
+				toRemove = append(toRemove, filePath)
+			} else {
+				last = filePath
+			}
+		}
+		if err := deleteDataFiles(db.opts.storage(), toRemove); err != nil {
+			return err
+		}
+		if last == "" {
+			return nil
+		}
+		return truncateDataFile(db.opts.storage(), last, lsn, db.opts.codec())
+	})
+}
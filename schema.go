@@ -0,0 +1,87 @@
+package kvdb
+
+import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+)
+
+// METADATA_SPACE is a system space storing metadata of the database
+// and its spaces (see SetMetadata)
+const METADATA_SPACE = "__metadata__"
+
+// NewSpaceOrExisting creates a new space like NewSpace
+// and checks that values of schema type are stored in it.
+// A hash of JSON field names and types of schema is stored in
+// METADATA_SPACE on the first call, following calls fail with
+// ErrSchemaMismatch if the hash differs. Spaces without a stored
+// hash accept any schema. nil schema is not checked.
+func (db *T) NewSpaceOrExisting(name string, schema any) (*Space, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := db.checkSpace(name); err != nil {
+		return nil, err
+	}
+	if schema != nil {
+		if err := db.checkSchema(name, schemaHash(reflect.TypeOf(schema))); err != nil {
+			return nil, err
+		}
+	}
+	return db.space(name, true), nil
+}
+
+// checkSchema compares hash with the one stored for the space
+// and stores it if there is none.
+// Must be called under the database lock.
+func (db *T) checkSchema(name string, hash uint32) error {
+	meta := db.space(METADATA_SPACE, true)
+	key := []byte("schema:" + name)
+
+	var stored uint32
+	switch err := meta.Get(key, &stored); err {
+	case ErrNotFound:
+		return meta.Set(key, hash)
+	case nil:
+		if stored != hash {
+			return ErrSchemaMismatch
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// schemaHash returns CRC of JSON field names and types of t
+func schemaHash(t reflect.Type) uint32 {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return crc32.ChecksumIEEE([]byte(t.String()))
+	}
+
+	var b strings.Builder
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fmt.Fprintf(&b, "%s:%s;", name, field.Type)
+	}
+	return crc32.ChecksumIEEE([]byte(b.String()))
+}
@@ -2,37 +2,233 @@ package kvdb
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/tidwall/btree"
 )
 
+const (
+	// DEFAULT_LEN_BYTES_SAMPLE_RATE is used if SpaceOptions.LenBytesSampleRate is not set
+	DEFAULT_LEN_BYTES_SAMPLE_RATE = 0.1
+	// DEFAULT_LEN_BYTES_MIN_SAMPLE is used if SpaceOptions.LenBytesMinSample is not set
+	DEFAULT_LEN_BYTES_MIN_SAMPLE = 100
+)
+
 type Space struct {
-	name *string
-	tree *btree.BTreeG[*record]
-	wr   writer
+	name     *string
+	tree     *btree.BTreeG[*record]
+	wr       writer
+	opts     *SpaceOptions
+	watchers *watchers      // nil for views
+	index    *spaceIndex    // nil without SpaceOptions.Index
+	alive    *atomic.Uint64 // records of the database, nil for views
+	count    *atomic.Int64  // records of the space
+	expiring *atomic.Int64  // records of the space with expiration time
 }
 
 func newSpace(name string, wr writer) Space {
+	return newSpaceWithComparator(name, wr, nil)
+}
+
+// newSpaceWithComparator creates a space ordering keys by cmp,
+// keys are compared bytewise if cmp is nil.
+// cmp is kept in SpaceOptions.Comparator of the space.
+func newSpaceWithComparator(name string, wr writer, cmp func(a, b []byte) int) Space {
+	opts := &SpaceOptions{Comparator: cmp}
+	if cmp == nil {
+		cmp = bytes.Compare
+	}
 	return Space{
 		name: &name,
 		tree: btree.NewBTreeG(func(a, b *record) bool {
-			return bytes.Compare(a.Key, b.Key) < 0
+			return cmp(a.Key, b.Key) < 0
 		}),
-		wr: wr,
+		wr:       wr,
+		opts:     opts,
+		count:    &atomic.Int64{},
+		expiring: &atomic.Int64{},
 	}
 }
 
+// View returns a read-only copy of the space. The view shares
+// SpaceOptions of the space and orders keys by the same comparator.
 func (s *Space) View() Space {
-	return Space{
-		name: s.name,
-		tree: s.tree.Copy(),
-		wr:   nil,
+	if s.index != nil {
+		s.index.mu.Lock()
+		defer s.index.mu.Unlock()
+	}
+	view := Space{
+		name:     s.name,
+		tree:     s.tree.Copy(),
+		wr:       nil,
+		opts:     s.opts,
+		count:    &atomic.Int64{},
+		expiring: &atomic.Int64{},
+	}
+	// the copy is counted, records may be set since the copy started
+	view.count.Store(int64(view.tree.Len()))
+	if s.expiring.Load() > 0 {
+		view.tree.Scan(func(r *record) bool {
+			if r.Expires != 0 {
+				view.expiring.Add(1)
+			}
+			return true
+		})
+	}
+	if s.index != nil {
+		view.index = s.index.copy()
+	}
+	return view
+}
+
+// Compact removes dead operations of the space from jlog files
+// without the global pause of db.Compact. Operations covered by snap,
+// a View of the space taken before, are removed if a later operation
+// with the same key is stored in jlog files. Other spaces and
+// the latest snapshot file are not rewritten.
+// Writes of all spaces wait while jlog files are rewritten.
+func (s *Space) Compact(snap Space) error {
+	if snap.name != s.name {
+		return ErrViewNotMatchSpace
+	}
+	var upTo uint64
+	snap.tree.Scan(func(r *record) bool {
+		upTo = max(upTo, r.LSN)
+		return true
+	})
+	if upTo == 0 {
+		return nil
 	}
+	return s.wr.CompactSpace(*s.name, upTo)
 }
 
+// Len returns the number of records in the space which are not expired,
+// it is counted on every set of a new key and every delete.
+// Records are scanned only if some of them have expiration time.
 func (s *Space) Len() int {
-	return s.tree.Len()
+	n := int(s.count.Load())
+	if s.expiring.Load() == 0 {
+		return n
+	}
+	now := time.Now()
+	s.tree.Scan(func(r *record) bool {
+		if r.expired(now) {
+			n--
+		}
+		return true
+	})
+	return n
+}
+
+// LenBytes estimates total size of JSON encoded values of the space.
+// Only a sample of records is encoded (see SpaceOptions.LenBytesSampleRate),
+// the result is extrapolated to the whole space.
+func (s *Space) LenBytes() int64 {
+	return s.sampleBytes(s.opts.lenBytesSampleRate(), false)
+}
+
+// SizeBytesEstimate estimates total size of JSON encoded values
+// and keys of the space encoding only sampleRate fraction of records
+// (but at least SpaceOptions.LenBytesMinSample).
+func (s *Space) SizeBytesEstimate(sampleRate float64) int64 {
+	return s.sampleBytes(sampleRate, true)
+}
+
+// sampleBytes extrapolates size of sampled records to the whole space
+func (s *Space) sampleBytes(sampleRate float64, withKeys bool) int64 {
+	n := s.tree.Len()
+	size := min(max(int(float64(n)*sampleRate), s.opts.lenBytesMinSample()), n)
+	if size == n {
+		return s.exactBytes(withKeys)
+	}
+
+	var total int64
+	step := float64(n) / float64(size)
+	for i := range size {
+		rec, ok := s.tree.GetAt(int(float64(i) * step))
+		if !ok {
+			break
+		}
+		total += recordBytes(rec, withKeys)
+	}
+	return total * int64(n) / int64(size)
+}
+
+// EstimatedSize estimates memory used by the space values,
+// it is the same estimate as LenBytes
+func (s *Space) EstimatedSize() int64 {
+	return s.LenBytes()
+}
+
+// ExactLenBytes returns total size of JSON encoded values of the space.
+// Every record is encoded, so it is O(n).
+func (s *Space) ExactLenBytes() int64 {
+	return s.exactBytes(false)
+}
+
+// SizeBytes returns total size of JSON encoded values and keys of the space.
+// Every record is encoded, so it is O(n).
+func (s *Space) SizeBytes() int64 {
+	return s.exactBytes(true)
+}
+
+func (s *Space) exactBytes(withKeys bool) int64 {
+	var total int64
+	s.tree.Scan(func(rec *record) bool {
+		total += recordBytes(rec, withKeys)
+		return true
+	})
+	return total
+}
+
+// recordBytes returns size of JSON encoded value of the record
+func recordBytes(rec *record, withKey bool) int64 {
+	var size int64
+	if raw, err := rec.rawValue(); err == nil {
+		size = int64(len(raw))
+	}
+	if withKey {
+		size += int64(len(rec.Key))
+	}
+	return size
+}
+
+// SortedKeys returns copies of all keys of the space in the sort order of the space
+func (s *Space) SortedKeys() [][]byte {
+	keys := make([][]byte, 0, s.tree.Len())
+	iter := s.tree.Iter()
+	defer iter.Release()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		keys = append(keys, bytes.Clone(iter.Item().Key))
+	}
+	return keys
+}
+
+// CountRange returns number of keys k such that from <= k < to.
+// nil from counts from the first key, nil to counts up to the last key.
+func (s *Space) CountRange(from, to []byte) int {
+	now := time.Now()
+	count := 0
+	iter := func(r *record) bool {
+		if to != nil && !s.tree.Less(r, &record{Key: to}) {
+			return false
+		}
+		if !r.expired(now) {
+			count++
+		}
+		return true
+	}
+	if from == nil {
+		s.tree.Scan(iter)
+	} else {
+		s.tree.Ascend(&record{Key: from}, iter)
+	}
+	return count
 }
 
 func (s *Space) GE(key []byte, iter func(value any) bool) {
@@ -69,12 +265,56 @@ func (s *Space) Get(key []byte, into any) error {
 		return ErrIntoIsNotPointer
 	}
 
-	if rec, found := s.treeGet(&record{Key: key}); found {
-		return rec.into(into)
+	if rec, found := s.treeGet(&record{Key: key}); found && !rec.expired(time.Now()) {
+		return s.opts.decodeInto(rec, into)
 	}
 	return ErrNotFound
 }
 
+// Has reports whether the key exists in the space
+// without decoding its value
+func (s *Space) Has(key []byte) (bool, error) {
+	if key == nil {
+		return false, ErrKeyIsNil
+	}
+
+	rec, found := s.treeGet(&record{Key: key})
+	return found && !rec.expired(time.Now()), nil
+}
+
+// GetJSON returns the value by key encoded as JSON
+func (s *Space) GetJSON(key []byte) (json.RawMessage, error) {
+	if key == nil {
+		return nil, ErrKeyIsNil
+	}
+
+	if rec, found := s.treeGet(&record{Key: key}); found && !rec.expired(time.Now()) {
+		return rec.rawValue()
+	}
+	return nil, ErrNotFound
+}
+
+// SetJSON sets the value decoded from raw JSON like Set
+func (s *Space) SetJSON(key []byte, raw json.RawMessage) error {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	return s.Set(key, value)
+}
+
+// GetHeader returns LSN and time of the record without decoding its value
+func (s *Space) GetHeader(key []byte) (Header, error) {
+	if key == nil {
+		return Header{}, ErrKeyIsNil
+	}
+
+	if rec, found := s.treeGet(&record{Key: key}); found && !rec.expired(time.Now()) {
+		return Header{LSN: rec.LSN, Time: rec.Time, Key: bytes.Clone(rec.Key)}, nil
+	}
+	return Header{}, ErrNotFound
+}
+
 func (s *Space) List(into any) error {
 	intoValue := reflect.ValueOf(into)
 	if intoValue.Kind() != reflect.Ptr || intoValue.Elem().Kind() != reflect.Slice {
@@ -98,16 +338,54 @@ func (s *Space) List(into any) error {
 	return nil
 }
 
+// ListWhere appends to into values of records for which where returns true.
+// where receives the JSON encoded value of every record,
+// only matching records are decoded.
+func (s *Space) ListWhere(into any, where func(rawJSON []byte) bool) error {
+	intoValue := reflect.ValueOf(into)
+	if intoValue.Kind() != reflect.Ptr || intoValue.Elem().Kind() != reflect.Slice {
+		return ErrIntoInvalidPointer
+	}
+	slice := intoValue.Elem()
+	elemType := slice.Type().Elem()
+
+	iter := s.Iter()
+	defer iter.Release()
+
+	for iter.HasNext() {
+		rec := iter.next()
+		raw, err := rec.rawValue()
+		if err != nil {
+			return err
+		}
+		if !where(raw) {
+			continue
+		}
+		elemPtr := reflect.New(elemType)
+		if err := s.opts.decodeInto(rec, elemPtr.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elemPtr.Elem())
+	}
+	intoValue.Elem().Set(slice)
+	return nil
+}
+
 func (s *Space) Set(key []byte, value any) error {
 	if key == nil {
 		return ErrKeyIsNil
 	}
 
+	value, err := s.opts.encode(value)
+	if err != nil {
+		return err
+	}
 	rec := &record{
-		LSN:   0, // it will be set after successful write
-		Key:   key,
-		Value: value,
-		Tag:   *s.name,
+		LSN:     0, // it will be set after successful write
+		Key:     key,
+		Value:   value,
+		Tag:     *s.name,
+		Expires: s.opts.expires(time.Now()),
 	}
 	if err := s.writeSet(rec); err != nil {
 		return err
@@ -117,6 +395,212 @@ func (s *Space) Set(key []byte, value any) error {
 	return nil
 }
 
+// KeyValuePair is a single entry of SetMany
+type KeyValuePair struct {
+	Key   []byte
+	Value any
+}
+
+// SetMany sets all entries with a single write to the log
+func (s *Space) SetMany(entries []KeyValuePair) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	records := make([]*record, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Key == nil {
+			return ErrKeyIsNil
+		}
+		value, err := s.opts.encode(entry.Value)
+		if err != nil {
+			return err
+		}
+		records = append(records, &record{
+			Key:     entry.Key,
+			Value:   value,
+			Tag:     *s.name,
+			Expires: s.opts.expires(time.Now()),
+		})
+	}
+	if err := s.writeMany(records, OPERATION_SET); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		_, _ = s.treeSet(rec)
+	}
+
+	return nil
+}
+
+// MSet sets alternating key and value arguments like SetMany.
+// Keys must be []byte or string.
+func (s *Space) MSet(args ...any) error {
+	if len(args)%2 != 0 {
+		return ErrMSetOddArgs
+	}
+
+	entries := make([]KeyValuePair, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		var key []byte
+		switch k := args[i].(type) {
+		case []byte:
+			key = k
+		case string:
+			key = []byte(k)
+		default:
+			return ErrMSetInvalidKey
+		}
+		entries = append(entries, KeyValuePair{Key: key, Value: args[i+1]})
+	}
+	return s.SetMany(entries)
+}
+
+// SetWithMeta sets the value like Set and stores meta along with
+// the operation in the log. Metadata is not returned by Get,
+// it is passed to the Options.OnWrite hook and kept in snapshots.
+func (s *Space) SetWithMeta(key []byte, value any, meta map[string]string) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+
+	value, err := s.opts.encode(value)
+	if err != nil {
+		return err
+	}
+	rec := &record{
+		Key:     key,
+		Value:   value,
+		Tag:     *s.name,
+		Meta:    maps.Clone(meta),
+		Expires: s.opts.expires(time.Now()),
+	}
+	if err := s.writeSet(rec); err != nil {
+		return err
+	}
+	_, _ = s.treeSet(rec)
+
+	return nil
+}
+
+// SetWithTimestamp sets the value like Set, but stores the given
+// timestamp as the operation time instead of the current one.
+func (s *Space) SetWithTimestamp(key []byte, value any, ts time.Time) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+
+	value, err := s.opts.encode(value)
+	if err != nil {
+		return err
+	}
+	rec := &record{
+		Key:     key,
+		Value:   value,
+		Tag:     *s.name,
+		Expires: s.opts.expires(time.Now()),
+	}
+	if err := s.writeSetAt(rec, ts); err != nil {
+		return err
+	}
+	_, _ = s.treeSet(rec)
+
+	return nil
+}
+
+// SetOrUpdate sets the value by key. If the key already exists,
+// merge is called with the existing and the incoming values
+// and its result is stored instead.
+// Reading, merging and writing happen in the writer goroutine,
+// so concurrent calls do not lose updates.
+// A panic in merge is recovered and returned as ErrMergePanicked.
+// In spaces with custom SpaceOptions existing is the encoded value.
+func (s *Space) SetOrUpdate(key []byte, value any, merge func(existing, incoming any) any) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+
+	return s.wr.Exec(func(write func(*operation) error) error {
+		rec := &record{
+			Key:     key,
+			Value:   value,
+			Tag:     *s.name,
+			Expires: s.opts.expires(time.Now()),
+		}
+		if cur, found := s.treeGet(rec); found && !cur.expired(time.Now()) {
+			merged, err := callMerge(merge, cur.Value, value)
+			if err != nil {
+				return err
+			}
+			rec.Value = merged
+		}
+		encoded, err := s.opts.encode(rec.Value)
+		if err != nil {
+			return err
+		}
+		rec.Value = encoded
+
+		op := newOperation(rec, OPERATION_SET)
+		if err := write(&op); err != nil {
+			return err
+		}
+		op.upgradeRecord()
+		_, _ = s.treeSet(rec)
+		return nil
+	})
+}
+
+// Upsert sets insertVal by key if the key is missing,
+// otherwise stores the value returned by updateFn called with the existing one.
+// The check and the write are done by a single writer task,
+// so concurrent upserts of the same key do not lose updates.
+// An error of updateFn is returned and nothing is written.
+// In spaces with custom SpaceOptions existing is the encoded value.
+func (s *Space) Upsert(key []byte, insertVal any, updateFn func(existing any) (any, error)) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+
+	upsert := func() (*operation, error) {
+		now := time.Now()
+		rec := &record{
+			Key:     key,
+			Value:   insertVal,
+			Tag:     *s.name,
+			Expires: s.opts.expires(now),
+		}
+		if cur, found := s.treeGet(rec); found && !cur.expired(now) {
+			value, err := updateFn(cur.Value)
+			if err != nil {
+				return nil, err
+			}
+			rec.Value = value
+		}
+		encoded, err := s.opts.encode(rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		rec.Value = encoded
+
+		op := newOperation(rec, OPERATION_SET)
+		return &op, nil
+	}
+	return s.wr.Upsert(upsert, func(op *operation) {
+		op.upgradeRecord()
+		_, _ = s.treeSet(op.Record)
+	})
+}
+
+// callMerge calls merge and converts its panic into an error
+func callMerge(merge func(existing, incoming any) any, existing, incoming any) (v any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrMergePanicked, r)
+		}
+	}()
+	return merge(existing, incoming), nil
+}
+
 func (s *Space) Del(key []byte) error {
 	if key == nil {
 		return ErrKeyIsNil
@@ -134,9 +618,307 @@ func (s *Space) Del(key []byte) error {
 	return nil
 }
 
+// DeleteIf deletes all records for which predicate returns true.
+// predicate receives the key and the JSON encoded value of every record.
+// All deletes are written to the log as a single batch.
+// Returns the number of deleted records.
+func (s *Space) DeleteIf(predicate func(key []byte, rawValue []byte) bool) (int, error) {
+	var err error
+	records := []*record{}
+	s.tree.Scan(func(r *record) bool {
+		var raw []byte
+		if raw, err = r.rawValue(); err != nil {
+			return false
+		}
+		if predicate(r.Key, raw) {
+			records = append(records, &record{Key: r.Key, Tag: *s.name})
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := s.writeMany(records, OPERATION_DEL); err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		_, _ = s.treeDel(rec)
+	}
+
+	return len(records), nil
+}
+
+// BulkDelete deletes records with the given keys, all deletes are written
+// to the log as a single batch. Keys missing in the space (or expired)
+// are skipped and returned in notFound, repeated keys are deleted once.
+func (s *Space) BulkDelete(keys [][]byte) (deleted int, notFound [][]byte, err error) {
+	now := time.Now()
+	seen := make(map[string]struct{}, len(keys))
+	records := make([]*record, 0, len(keys))
+	for _, key := range keys {
+		if key == nil {
+			return 0, nil, ErrKeyIsNil
+		}
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		if rec, found := s.treeGet(&record{Key: key}); !found || rec.expired(now) {
+			notFound = append(notFound, key)
+			continue
+		}
+		records = append(records, &record{Key: key, Tag: *s.name})
+	}
+	if len(records) == 0 {
+		return 0, notFound, nil
+	}
+
+	if err := s.writeMany(records, OPERATION_DEL); err != nil {
+		return 0, nil, err
+	}
+	for _, rec := range records {
+		if prev, _ := s.treeDel(rec); prev != nil {
+			deleted++
+		}
+	}
+	return deleted, notFound, nil
+}
+
+// DelByValue deletes all records whose JSON encoded value matches predicate,
+// like DeleteIf without the key. Returns the number of deleted records.
+func (s *Space) DelByValue(predicate func(rawJSON []byte) bool) (int, error) {
+	return s.DeleteIf(func(_ []byte, rawValue []byte) bool {
+		return predicate(rawValue)
+	})
+}
+
+// SetRange replaces values of records with keys k such that from <= k <= to
+// by the result of transform, records for which it returns nil are deleted.
+// nil from starts from the first key, nil to goes up to the last key.
+// Expiration time of records is kept. All changes are written
+// to the log as a single batch. Returns the number of changed records.
+// In spaces with custom SpaceOptions transform receives the encoded value.
+func (s *Space) SetRange(from, to []byte, transform func(key []byte, value any) any) (int, error) {
+	now := time.Now()
+	var err error
+	batch := []*operation{}
+	collect := func(r *record) bool {
+		if to != nil && s.tree.Less(&record{Key: to}, r) {
+			return false
+		}
+		if r.expired(now) {
+			return true
+		}
+		value := transform(r.Key, r.Value)
+		if value == nil {
+			op := newOperation(&record{Key: r.Key, Tag: *s.name}, OPERATION_DEL)
+			batch = append(batch, &op)
+			return true
+		}
+		if value, err = s.opts.encode(value); err != nil {
+			return false
+		}
+		op := newOperation(&record{Key: r.Key, Value: value, Tag: *s.name, Expires: r.Expires}, OPERATION_SET)
+		batch = append(batch, &op)
+		return true
+	}
+	if from == nil {
+		s.tree.Scan(collect)
+	} else {
+		s.tree.Ascend(&record{Key: from}, collect)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := s.wr.WriteMany(batch); err != nil {
+		return 0, err
+	}
+	for _, op := range batch {
+		op.upgradeRecord()
+		if op.Op == OPERATION_SET {
+			_, _ = s.treeSet(op.Record)
+		} else {
+			_, _ = s.treeDel(op.Record)
+		}
+	}
+	return len(batch), nil
+}
+
+// Merge writes all records of src into the space.
+// src is expected to be a View of the space, modified outside of it.
+// Records of src older than the current version of the same key
+// in the space (by LSN) are skipped to prevent stale writes,
+// records not modified in src are skipped as well.
+// If writing fails the returned error reports how many records were merged.
+func (s *Space) Merge(src Space) error {
+	iter := src.Iter()
+	defer iter.Release()
+
+	merged := 0
+	for iter.HasNext() {
+		r := iter.next()
+		if cur, found := s.treeGet(r); found && (cur.LSN > r.LSN || unmodified(cur, r)) {
+			continue
+		}
+
+		rec := &record{
+			Key:     r.Key,
+			Value:   r.Value,
+			Tag:     *s.name,
+			Expires: r.Expires,
+		}
+		if err := s.writeSet(rec); err != nil {
+			return fmt.Errorf("merge is incomplete: %d records merged: %w", merged, err)
+		}
+		_, _ = s.treeSet(rec)
+		merged++
+	}
+	return nil
+}
+
+// unmodified reports whether r of a view is the live version cur:
+// the same record or a record of the same LSN with an equal value
+func unmodified(cur, r *record) bool {
+	if cur == r {
+		return true
+	}
+	return cur.LSN == r.LSN && cur.Expires == r.Expires && reflect.DeepEqual(cur.Value, r.Value)
+}
+
+// ApplyAll applies a list of set and delete operations to the space.
+// The whole list is written to the log by a single writer task,
+// the space is updated only after all operations are written.
+func (s *Space) ApplyAll(ops []operation) error {
+	now := time.Now()
+	batch := make([]*operation, 0, len(ops))
+	for i := range ops {
+		op := ops[i]
+		if op.Record == nil {
+			return ErrRecordIsNil
+		}
+		if op.Record.Tag != *s.name {
+			return ErrOperationNotMatchSpace
+		}
+		if op.Op != OPERATION_SET && op.Op != OPERATION_DEL {
+			return ErrOperationUnknownType
+		}
+		op.Record = op.Record.clone()
+		if op.Op == OPERATION_SET {
+			value, err := s.opts.encode(op.Record.Value)
+			if err != nil {
+				return err
+			}
+			op.Record.Value = value
+			if op.Expires == 0 {
+				op.Expires = s.opts.expires(now)
+			}
+		}
+		batch = append(batch, &op)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := s.wr.WriteMany(batch); err != nil {
+		return err
+	}
+	for _, op := range batch {
+		op.upgradeRecord()
+		if op.Op == OPERATION_SET {
+			_, _ = s.treeSet(op.Record)
+		} else {
+			_, _ = s.treeDel(op.Record)
+		}
+	}
+	return nil
+}
+
+// Iter returns an iterator over records of the space in key order.
+// It iterates a copy of the tree made on the call, so records written
+// during the iteration are not seen and writes are not blocked by it.
+// Records expired at the call are skipped. Release must be called.
 func (s *Space) Iter() SpaceIterator {
-	iter := s.tree.Iter()
-	return SpaceIterator{iter, !iter.First()}
+	iter := s.tree.Copy().Iter()
+	return SpaceIterator{iter: iter, finished: !iter.First(), opts: s.opts, now: time.Now()}
+}
+
+// SortedRange returns an iterator over keys k such that from <= k <= to
+// in key order. nil from starts from the first key,
+// nil to iterates up to the last key. Release must be called.
+func (s *Space) SortedRange(from, to []byte) SpaceIterator {
+	iter := s.Iter()
+	iter.Seek(from)
+	if to != nil {
+		iter.to, iter.less = &record{Key: to}, s.tree.Less
+	}
+	return iter
+}
+
+// Keys returns copies of keys k such that from <= k <= to in key order
+// without decoding values. nil from starts from the first key,
+// nil to returns keys up to the last one.
+func (s *Space) Keys(from, to []byte) [][]byte {
+	keys := [][]byte{}
+	collect := func(r *record) bool {
+		if to != nil && s.tree.Less(&record{Key: to}, r) {
+			return false
+		}
+		keys = append(keys, bytes.Clone(r.Key))
+		return true
+	}
+	if from == nil {
+		s.tree.Scan(collect)
+	} else {
+		s.tree.Ascend(&record{Key: from}, collect)
+	}
+	return keys
+}
+
+// ScanItem is a single item returned by Space.Scan
+type ScanItem struct {
+	Key      []byte
+	RawValue json.RawMessage
+}
+
+// Scan returns up to limit items with keys greater than cursor
+// in key order, nil cursor starts from the first key.
+// nextCursor is the key of the last returned item,
+// it is nil if there are no more items.
+func (s *Space) Scan(cursor []byte, limit int) (items []ScanItem, nextCursor []byte, err error) {
+	if limit <= 0 {
+		return nil, nil, nil
+	}
+
+	iter := s.Iter()
+	defer iter.Release()
+
+	if cursor != nil {
+		iter.Seek(cursor)
+		if iter.HasNext() && bytes.Equal(iter.iter.Item().Key, cursor) {
+			iter.next()
+		}
+	}
+
+	items = make([]ScanItem, 0, limit)
+	for _, rec := range iter.collectNext(limit) {
+		raw, err := rec.rawValue()
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, ScanItem{Key: bytes.Clone(rec.Key), RawValue: raw})
+	}
+	if len(items) > 0 && iter.HasNext() {
+		nextCursor = items[len(items)-1].Key
+	}
+	return items, nextCursor, nil
 }
 
 /******************************************************************************
@@ -145,7 +927,12 @@ func (s *Space) Iter() SpaceIterator {
 
 // Writes a set operation to the writer.
 func (s *Space) writeSet(record *record) error {
-	op := newOperation(record, OPERATION_SET)
+	return s.writeSetAt(record, time.Now())
+}
+
+// Writes a set operation with the given timestamp to the writer.
+func (s *Space) writeSetAt(record *record, ts time.Time) error {
+	op := newOperationAt(record, OPERATION_SET, ts)
 	if err := s.wr.Write(&op); err != nil {
 		return err
 	}
@@ -163,6 +950,22 @@ func (s *Space) writeDel(record *record) error {
 	return nil
 }
 
+// Writes a batch of operations of the same type to the writer.
+func (s *Space) writeMany(records []*record, op oType) error {
+	ops := make([]*operation, 0, len(records))
+	for _, r := range records {
+		o := newOperation(r, op)
+		ops = append(ops, &o)
+	}
+	if err := s.wr.WriteMany(ops); err != nil {
+		return err
+	}
+	for _, o := range ops {
+		o.upgradeRecord()
+	}
+	return nil
+}
+
 /******************************************************************************
  * inner tree operations
  */
@@ -173,7 +976,29 @@ func (s *Space) treeSet(r *record) (prev *record, err error) {
 		return nil, ErrRecordIsNil
 	}
 
+	if s.index != nil {
+		s.index.mu.Lock()
+		defer s.index.mu.Unlock()
+	}
 	prev, _ = s.tree.Set(r)
+	if s.index != nil {
+		if prev != nil {
+			s.index.remove(prev)
+		}
+		s.index.add(r)
+	}
+	if prev != nil && prev.Expires != 0 {
+		s.expiring.Add(-1)
+	}
+	if r.Expires != 0 {
+		s.expiring.Add(1)
+	}
+	if prev == nil {
+		s.count.Add(1)
+		if s.alive != nil {
+			s.alive.Add(1)
+		}
+	}
 	return
 }
 
@@ -183,7 +1008,23 @@ func (s *Space) treeDel(r *record) (prev *record, err error) {
 		return nil, ErrRecordIsNil
 	}
 
+	if s.index != nil {
+		s.index.mu.Lock()
+		defer s.index.mu.Unlock()
+	}
 	prev, _ = s.tree.Delete(r)
+	if prev != nil && s.index != nil {
+		s.index.remove(prev)
+	}
+	if prev != nil {
+		s.count.Add(-1)
+		if prev.Expires != 0 {
+			s.expiring.Add(-1)
+		}
+		if s.alive != nil {
+			s.alive.Add(^uint64(0))
+		}
+	}
 	return
 }
 
@@ -199,14 +1040,26 @@ func (s *Space) treeGet(r *record) (rec *record, found bool) {
 type SpaceIterator struct {
 	iter     btree.IterG[*record]
 	finished bool
+	opts     *SpaceOptions
+	err      error
+
+	to   *record // upper bound of SortedRange, nil if none
+	less func(a, b *record) bool
+	now  time.Time // records expired at now are skipped
 }
 
 func (sIt *SpaceIterator) HasNext() bool {
+	for !sIt.finished && sIt.iter.Item().expired(sIt.now) {
+		sIt.finished = !sIt.iter.Next()
+	}
+	if !sIt.finished && sIt.to != nil && sIt.less(sIt.to, sIt.iter.Item()) {
+		sIt.finished = true
+	}
 	return !sIt.finished
 }
 
 func (sIt *SpaceIterator) next() *record {
-	if sIt.finished {
+	if !sIt.HasNext() {
 		return nil
 	}
 
@@ -219,11 +1072,22 @@ func (sIt *SpaceIterator) next() *record {
 
 func (sIt *SpaceIterator) Next(into any) error {
 	if record := sIt.next(); record != nil {
-		return record.into(into)
+		if err := sIt.opts.decodeInto(record, into); err != nil {
+			sIt.err = err
+			sIt.finished = true
+			return err
+		}
+		return nil
 	}
 	return ErrIteratorNoNextValue
 }
 
+// Error returns the error which stopped iteration, if any.
+// Natural end of iteration is not an error.
+func (sIt *SpaceIterator) Error() error {
+	return sIt.err
+}
+
 func (sIt *SpaceIterator) collectNext(size int) []*record {
 	records := make([]*record, 0, size)
 	for len(records) < size {
@@ -236,6 +1100,35 @@ func (sIt *SpaceIterator) collectNext(size int) []*record {
 	return records
 }
 
+// Peek returns a copy of the next key without advancing the iterator.
+// It returns (nil, false) if the iterator is finished.
+func (sIt *SpaceIterator) Peek() (key []byte, hasNext bool) {
+	if !sIt.HasNext() {
+		return nil, false
+	}
+	return bytes.Clone(sIt.iter.Item().Key), true
+}
+
+// Seek moves the iterator to the first key greater or equal to key
+func (sIt *SpaceIterator) Seek(key []byte) {
+	sIt.finished = !sIt.iter.Seek(&record{Key: key})
+}
+
+// NextBatch returns up to size next records and
+// reports whether more records remain.
+// It returns (nil, false) if the iterator is finished.
+func (sIt *SpaceIterator) NextBatch(size int) ([]*Record, bool) {
+	if !sIt.HasNext() {
+		return nil, false
+	}
+	records := sIt.collectNext(size)
+	batch := make([]*Record, 0, len(records))
+	for _, rec := range records {
+		batch = append(batch, rec.public())
+	}
+	return batch, sIt.HasNext()
+}
+
 func (sIt *SpaceIterator) Release() {
 	sIt.iter.Release()
 }
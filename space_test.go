@@ -1,8 +1,20 @@
 package kvdb
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/tidwall/btree"
 )
 
 const (
@@ -11,12 +23,65 @@ const (
 
 type mockWriter struct{}
 
-func (mockWriter) Load(func(*operation) (uint64, error)) error { return nil }
-func (mockWriter) Start() error                                { return nil }
-func (mockWriter) Close() error                                { return nil }
-func (mockWriter) Write(*operation) error                      { return nil }
-func (mockWriter) Rotate() error                               { return nil }
-func (mockWriter) Snapshot(*map[string]Space) error            { return nil }
+func (mockWriter) Load(func(*operation) (uint64, error)) error      { return nil }
+func (mockWriter) Start() error                                     { return nil }
+func (mockWriter) Close() error                                     { return nil }
+func (mockWriter) CloseContext(context.Context, func() error) error { return nil }
+func (mockWriter) HardClose() error                                 { return nil }
+func (mockWriter) Lazy() bool                                       { return false }
+func (mockWriter) Write(*operation) error                           { return nil }
+func (mockWriter) WriteMany([]*operation) error                     { return nil }
+func (w mockWriter) Exec(fn execFunc) error                         { return fn(w.Write) }
+func (mockWriter) Upsert(fn upsertFunc, apply func(*operation)) error {
+	op, err := fn()
+	if err == nil {
+		apply(op)
+	}
+	return err
+}
+func (mockWriter) Rotate() error                              { return nil }
+func (mockWriter) Flush() error                               { return nil }
+func (mockWriter) TruncateAll() error                         { return nil }
+func (mockWriter) CompactSpace(string, uint64) error          { return nil }
+func (mockWriter) Snapshot(*map[string]Space) error           { return nil }
+func (mockWriter) SnapshotTo(*map[string]Space, string) error { return nil }
+func (mockWriter) SetSyncMode(SyncMode) error                 { return nil }
+func (mockWriter) Ops() uint64                                { return 0 }
+func (mockWriter) LSN() uint64                                { return 0 }
+func (mockWriter) Pending() int                               { return 0 }
+func (mockWriter) OpenFiles() ([]OpenFileInfo, error)         { return nil, nil }
+func (mockWriter) DataFiles() ([]string, error)               { return nil, nil }
+
+// lsnMockWriter assigns increasing LSNs to written operations
+type lsnMockWriter struct {
+	mockWriter
+	lsn *uint64
+}
+
+func newLsnMockWriter() lsnMockWriter {
+	return lsnMockWriter{lsn: new(uint64)}
+}
+
+func (w lsnMockWriter) Write(op *operation) error {
+	*w.lsn++
+	op.LSN = *w.lsn
+	return nil
+}
+
+func (w lsnMockWriter) LSN() uint64 {
+	return *w.lsn
+}
+
+func (w lsnMockWriter) Exec(fn execFunc) error {
+	return fn(w.Write)
+}
+
+func (w lsnMockWriter) WriteMany(ops []*operation) error {
+	for _, op := range ops {
+		_ = w.Write(op)
+	}
+	return nil
+}
 
 type TestUser struct {
 	Name string `json:"name"`
@@ -57,6 +122,58 @@ func TestSpaceIterator(t *testing.T) {
 	}
 }
 
+func TestSpaceIteratorIsolation(t *testing.T) {
+	/* test iterator does not see and does not block writes made during iteration */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	iter := space.Iter()
+	defer iter.Release()
+	scanned := 0
+	for iter.HasNext() {
+		var value int
+		if err := iter.Next(&value); err != nil {
+			t.Fatalf("failed iter.Next with error: %v", err)
+		}
+		if value != scanned {
+			t.Fatalf("failed result check: value: %d, expected: %d", value, scanned)
+		}
+		space.Set([]byte(fmt.Sprintf("name-%d", scanned)), -1)
+		space.Set([]byte(fmt.Sprintf("name-%da", scanned)), -1)
+		scanned++
+	}
+	if scanned != 10 || space.Len() != 20 {
+		t.Fatalf("failed result check: scanned: %d, len: %d, expected: 10 and 20", scanned, space.Len())
+	}
+}
+
+func TestSpaceIteratorError(t *testing.T) {
+	/* test iterator stops and keeps the error of failed Next */
+	space := newSpace(spaceName, mockWriter{})
+	space.Set([]byte("name-1"), TestUser{Name: "name-1", Age: 1})
+	space.treeSet(&record{Key: []byte("name-2"), Tag: spaceName, Value: json.RawMessage(`{"name":`)})
+	space.Set([]byte("name-3"), TestUser{Name: "name-3", Age: 3})
+
+	iter := space.Iter()
+	defer iter.Release()
+
+	var user TestUser
+	if err := iter.Next(&user); err != nil {
+		t.Fatalf("failed iter.Next with error: %v", err)
+	}
+	if iter.Error() != nil {
+		t.Fatalf("failed result check: Error: %v, expected nil", iter.Error())
+	}
+	if err := iter.Next(&user); err == nil {
+		t.Fatalf("failed iter.Next: expected error for malformed value")
+	}
+	if iter.Error() == nil || iter.HasNext() {
+		t.Fatalf("failed result check: Error: %v, HasNext: %v, expected error and no next", iter.Error(), iter.HasNext())
+	}
+}
+
 func TestSpaceIteratorEmpty(t *testing.T) {
 	/* test success iterator run for empty space */
 	space := newSpace(spaceName, mockWriter{})
@@ -68,6 +185,391 @@ func TestSpaceIteratorEmpty(t *testing.T) {
 	}
 }
 
+func TestSpaceIteratorNextBatch(t *testing.T) {
+	/* test NextBatch splits the space into batches of the given size */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 25 {
+		space.Set([]byte(fmt.Sprintf("name-%02d", i)), i)
+	}
+
+	iter := space.Iter()
+	defer iter.Release()
+
+	sizes := []int{}
+	keys := []string{}
+	for more := true; more; {
+		var batch []*Record
+		batch, more = iter.NextBatch(10)
+		sizes = append(sizes, len(batch))
+		for _, rec := range batch {
+			keys = append(keys, string(rec.Key))
+		}
+	}
+	if !reflect.DeepEqual(sizes, []int{10, 10, 5}) {
+		t.Fatalf("failed result check: batch sizes: %v, expected: %v", sizes, []int{10, 10, 5})
+	}
+	if len(keys) != 25 || keys[0] != "name-00" || keys[24] != "name-24" {
+		t.Fatalf("failed result check: keys: %v", keys)
+	}
+
+	if iter.HasNext() {
+		t.Fatalf("failed iter.HasNext(): result should be false after the last batch")
+	}
+	if batch, more := iter.NextBatch(10); batch != nil || more {
+		t.Fatalf("failed result check: NextBatch after finish: %v, %v, expected: nil, false", batch, more)
+	}
+}
+
+func TestSpaceIteratorPeek(t *testing.T) {
+	/* test merge join of two spaces with Peek and Next */
+	left := newSpace(spaceName, mockWriter{})
+	right := newSpace(spaceName, mockWriter{})
+	expected := []string{}
+	for i := range 20 {
+		key := fmt.Sprintf("name-%02d", i)
+		if i%3 != 0 {
+			left.Set([]byte(key), i)
+		}
+		if i%2 == 0 {
+			right.Set([]byte(key), i)
+		}
+		if i%3 != 0 || i%2 == 0 {
+			expected = append(expected, key)
+		}
+	}
+
+	lIter, rIter := left.Iter(), right.Iter()
+	defer lIter.Release()
+	defer rIter.Release()
+
+	merged := []string{}
+	var value int
+	for {
+		lKey, lOk := lIter.Peek()
+		rKey, rOk := rIter.Peek()
+		if !lOk && !rOk {
+			break
+		}
+		cmp := bytes.Compare(lKey, rKey)
+		switch {
+		case !rOk || (lOk && cmp < 0):
+			merged = append(merged, string(lKey))
+			lIter.Next(&value)
+		case !lOk || cmp > 0:
+			merged = append(merged, string(rKey))
+			rIter.Next(&value)
+		default:
+			merged = append(merged, string(lKey))
+			lIter.Next(&value)
+			rIter.Next(&value)
+		}
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("failed result check: merged keys: %q, expected: %q", merged, expected)
+	}
+	if key, ok := lIter.Peek(); key != nil || ok {
+		t.Fatalf("failed Peek of finished iterator: key %q, hasNext %v", key, ok)
+	}
+}
+
+func TestSpaceSortedRange(t *testing.T) {
+	/* test SortedRange iterates only over keys from from to to inclusive */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	tests := []struct {
+		from, to string
+		expected []int
+	}{
+		{"name-2", "name-5", []int{2, 3, 4, 5}},
+		{"name-7", "name-99", []int{7, 8, 9}},
+		{"name-3", "name-3", []int{3}},
+		{"name-5", "name-2", []int{}},
+	}
+	for _, tt := range tests {
+		iter := space.SortedRange([]byte(tt.from), []byte(tt.to))
+		values := []int{}
+		for iter.HasNext() {
+			var value int
+			if err := iter.Next(&value); err != nil {
+				t.Fatalf("failed iter.Next with error: %v", err)
+			}
+			values = append(values, value)
+		}
+		iter.Release()
+		if !reflect.DeepEqual(values, tt.expected) {
+			t.Fatalf("failed result check of range [%s, %s]: values: %v, expected: %v", tt.from, tt.to, values, tt.expected)
+		}
+	}
+}
+
+func TestSpaceLenCounter(t *testing.T) {
+	/* test Len counter matches the tree after random sets and deletes and after load */
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer func() { db.Close() }()
+	space, _ := db.NewSpace("users")
+	rnd := rand.New(rand.NewSource(1))
+	for i := range 1000 {
+		key := []byte(fmt.Sprintf("name-%d", rnd.Intn(100)))
+		if rnd.Intn(3) == 0 {
+			err = space.Del(key)
+		} else {
+			err = space.Set(key, i)
+		}
+		if err != nil {
+			t.Fatalf("failed operation %d with error: %v", i, err)
+		}
+		if space.Len() != space.tree.Len() {
+			t.Fatalf("failed result check: len after %d operations: %d, expected: %d", i+1, space.Len(), space.tree.Len())
+		}
+	}
+	view := space.View()
+	if view.Len() != space.Len() {
+		t.Fatalf("failed result check: view len: %d, expected: %d", view.Len(), space.Len())
+	}
+
+	expected := space.Len()
+	dir := db.dir
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = Open(dir); err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	space, _ = db.ExistingSpace("users")
+	if space.Len() != expected || space.tree.Len() != expected {
+		t.Fatalf("failed result check: len after load: %d, tree: %d, expected: %d", space.Len(), space.tree.Len(), expected)
+	}
+}
+
+func TestSpaceKeys(t *testing.T) {
+	/* test Keys returns sorted copies of keys from from to to inclusive */
+	space := newSpace(spaceName, mockWriter{})
+	for _, i := range []int{5, 1, 8, 3, 0, 9, 2, 7, 4, 6} {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	tests := []struct {
+		from, to []byte
+		expected []string
+	}{
+		{[]byte("name-2"), []byte("name-5"), []string{"name-2", "name-3", "name-4", "name-5"}},
+		{nil, []byte("name-1"), []string{"name-0", "name-1"}},
+		{[]byte("name-75"), nil, []string{"name-8", "name-9"}},
+		{[]byte("name-5"), []byte("name-2"), []string{}},
+	}
+	for _, tt := range tests {
+		keys := []string{}
+		for _, key := range space.Keys(tt.from, tt.to) {
+			keys = append(keys, string(key))
+		}
+		if !reflect.DeepEqual(keys, tt.expected) {
+			t.Fatalf("failed result check of range [%s, %s]: keys: %v, expected: %v", tt.from, tt.to, keys, tt.expected)
+		}
+	}
+
+	if keys := space.Keys(nil, nil); len(keys) != 10 {
+		t.Fatalf("got %d keys of the whole space, expected 10", len(keys))
+	}
+	keys := space.Keys([]byte("name-0"), []byte("name-0"))
+	keys[0][0] = 'X'
+	if ok, err := space.Has([]byte("name-0")); err != nil || !ok {
+		t.Fatalf("modifying returned key changed the space: found %v, err %v", ok, err)
+	}
+}
+
+// batchMockWriter records batches written by WriteMany
+type batchMockWriter struct {
+	mockWriter
+	batches *[][]*operation
+}
+
+func (w batchMockWriter) WriteMany(ops []*operation) error {
+	*w.batches = append(*w.batches, ops)
+	return nil
+}
+
+func TestSpaceSetRange(t *testing.T) {
+	/* test SetRange transforms records of the range by a single batch */
+	batches := [][]*operation{}
+	space := newSpace(spaceName, batchMockWriter{batches: &batches})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	n, err := space.SetRange([]byte("name-2"), []byte("name-5"), func(key []byte, value any) any {
+		if string(key) == "name-4" {
+			return nil
+		}
+		return value.(int) + 10
+	})
+	if err != nil {
+		t.Fatalf("failed SetRange with error: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("failed result check: changed: %d, expected: 4", n)
+	}
+	if len(batches) != 1 || len(batches[0]) != 4 {
+		t.Fatalf("failed batch check: batches: %v, expected one batch of 4 operations", batches)
+	}
+	if op := batches[0][2]; op.Op != OPERATION_DEL || string(op.Record.Key) != "name-4" {
+		t.Fatalf("failed batch check: operation: %+v, expected del of name-4", op)
+	}
+
+	values := []int{}
+	iter := space.Iter()
+	for iter.HasNext() {
+		var value int
+		if err := iter.Next(&value); err != nil {
+			t.Fatalf("failed iter.Next with error: %v", err)
+		}
+		values = append(values, value)
+	}
+	iter.Release()
+	if expected := []int{0, 1, 12, 13, 15, 6, 7, 8, 9}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("failed result check: values: %v, expected: %v", values, expected)
+	}
+}
+
+func TestSpaceScan(t *testing.T) {
+	/* test Scan pages through the space by cursor */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 25 {
+		space.Set([]byte(fmt.Sprintf("name-%02d", i*2)), i*2)
+	}
+
+	// first page
+	items, cursor, err := space.Scan(nil, 10)
+	if err != nil {
+		t.Fatalf("failed Scan with error: %v", err)
+	}
+	if len(items) != 10 || string(items[0].Key) != "name-00" || string(cursor) != "name-18" {
+		t.Fatalf("failed result check: first page: %d items, cursor: %s", len(items), cursor)
+	}
+	if string(items[1].RawValue) != "2" {
+		t.Fatalf("failed result check: raw value: %s, expected: %s", items[1].RawValue, "2")
+	}
+
+	// cursor between keys
+	items, _, _ = space.Scan([]byte("name-19"), 1)
+	if len(items) != 1 || string(items[0].Key) != "name-20" {
+		t.Fatalf("failed result check: mid page: %v", items)
+	}
+
+	// writes between pages do not repeat items
+	seen := map[string]bool{}
+	pages := 0
+	for cursor = nil; pages == 0 || cursor != nil; pages++ {
+		items, cursor, err = space.Scan(cursor, 7)
+		if err != nil {
+			t.Fatalf("failed Scan with error: %v", err)
+		}
+		for _, item := range items {
+			if seen[string(item.Key)] {
+				t.Fatalf("failed result check: key %s is returned twice", item.Key)
+			}
+			seen[string(item.Key)] = true
+		}
+		// before and after the cursor
+		space.Set([]byte(fmt.Sprintf("name-%02d", pages*2+1)), 0)
+		space.Set([]byte("name-99"), pages)
+	}
+	if pages != 4 || len(seen) != 26 || !seen["name-99"] {
+		t.Fatalf("failed result check: pages: %d, items: %d, expected: %d pages, %d items", pages, len(seen), 4, 26)
+	}
+
+	// last page
+	items, cursor, _ = space.Scan([]byte("name-40"), 100)
+	if len(items) != 5 || cursor != nil {
+		t.Fatalf("failed result check: last page: %d items, cursor: %s, expected: 5 items, nil cursor", len(items), cursor)
+	}
+}
+
+func TestSpaceGetHeader(t *testing.T) {
+	/* test GetHeader returns LSN of the last Set and a copy of the key */
+	var lsn uint64
+	space := newSpace(spaceName, lsnMockWriter{lsn: &lsn})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i%3)), i)
+	}
+
+	header, err := space.GetHeader([]byte("name-1"))
+	if err != nil {
+		t.Fatalf("failed GetHeader with error: %v", err)
+	}
+	// name-1 is set last by the 8th Set
+	if header.LSN != 8 || string(header.Key) != "name-1" || header.Time == 0 {
+		t.Fatalf("failed result check: header: %+v, expected LSN %d", header, 8)
+	}
+
+	header.Key[0] = 'X'
+	if _, err := space.GetHeader([]byte("name-1")); err != nil {
+		t.Fatalf("failed result check: header key is not a copy: %v", err)
+	}
+
+	if _, err := space.GetHeader([]byte("name-x")); err != ErrNotFound {
+		t.Fatalf("failed GetHeader: have error '%v', expected '%v'", err, ErrNotFound)
+	}
+}
+
+func TestSpaceReapExpired(t *testing.T) {
+	/* test reapExpired deletes only expired records */
+	space := newSpace(spaceName, mockWriter{})
+	space.opts.DefaultTTL = time.Minute
+	space.Set([]byte("default"), 1)
+	space.SetWithTTL([]byte("short"), 2, time.Millisecond)
+	space.SetWithTTL([]byte("forever"), 3, 0)
+
+	if deleted, err := space.reapExpired(time.Now().Add(time.Second)); err != nil || deleted != 1 {
+		t.Fatalf("failed reapExpired: deleted %d, error: %v, expected 1", deleted, err)
+	}
+	if keys := space.SortedKeys(); len(keys) != 2 || string(keys[0]) != "default" || string(keys[1]) != "forever" {
+		t.Fatalf("failed result check: keys: %q", keys)
+	}
+
+	if deleted, err := space.reapExpired(time.Now().Add(time.Hour)); err != nil || deleted != 1 {
+		t.Fatalf("failed reapExpired: deleted %d, error: %v, expected 1", deleted, err)
+	}
+	var v int
+	if err := space.Get([]byte("forever"), &v); err != nil || v != 3 {
+		t.Fatalf("failed Get: value %d, error: %v", v, err)
+	}
+}
+
+func TestSpaceSkipsExpired(t *testing.T) {
+	/* test expired records are not counted, listed or scanned */
+	space := newSpace(spaceName, mockWriter{})
+	space.Set([]byte("name-1"), 1)
+	space.SetWithTTL([]byte("name-2"), 2, time.Millisecond)
+	space.Set([]byte("name-3"), 3)
+	time.Sleep(5 * time.Millisecond)
+
+	if n := space.Len(); n != 2 {
+		t.Fatalf("failed result check: len: %d, expected: 2", n)
+	}
+	if n := space.CountRange(nil, nil); n != 2 {
+		t.Fatalf("failed result check: count range: %d, expected: 2", n)
+	}
+	var values []int
+	if err := space.List(&values); err != nil || !reflect.DeepEqual(values, []int{1, 3}) {
+		t.Fatalf("failed List: values: %v, error: %v", values, err)
+	}
+	items, next, err := space.Scan([]byte("name-1"), 10)
+	if err != nil || len(items) != 1 || string(items[0].Key) != "name-3" || next != nil {
+		t.Fatalf("failed Scan: items: %v, next: %q, error: %v", items, next, err)
+	}
+	iter := space.SortedRange([]byte("name-2"), nil)
+	defer iter.Release()
+	if key, ok := iter.Peek(); !ok || string(key) != "name-3" {
+		t.Fatalf("failed Peek: key: %q, has next: %v, expected: name-3", key, ok)
+	}
+}
+
 func TestSpaceList(t *testing.T) {
 	/* test success List
 	- check full scan result
@@ -91,6 +593,30 @@ func TestSpaceList(t *testing.T) {
 	}
 }
 
+func TestSpaceListWhere(t *testing.T) {
+	/* test ListWhere returns only records matching raw JSON */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), TestUser{Name: fmt.Sprintf("name-%d", i), Age: i % 4})
+	}
+
+	scannedData := []TestUser{}
+	err := space.ListWhere(&scannedData, func(rawJSON []byte) bool {
+		return bytes.Contains(rawJSON, []byte(`"age":3`))
+	})
+	if err != nil {
+		t.Fatalf("failed space.ListWhere with error: %v", err)
+	}
+	expectedData := []TestUser{{Name: "name-3", Age: 3}, {Name: "name-7", Age: 3}}
+	if !reflect.DeepEqual(scannedData, expectedData) {
+		t.Fatalf("failed result check: loaded data: '%v', expected data: '%v'", scannedData, expectedData)
+	}
+
+	if err := space.ListWhere(scannedData, func([]byte) bool { return true }); err != ErrIntoInvalidPointer {
+		t.Fatalf("failed space.ListWhere: have error '%v', expected '%v'", err, ErrIntoInvalidPointer)
+	}
+}
+
 func TestSpaceListEmpty(t *testing.T) {
 	/* test success space.List run for empty space */
 
@@ -178,6 +704,22 @@ func TestSpaceGet(t *testing.T) {
 	}
 }
 
+func TestSpaceHas(t *testing.T) {
+	/* test Has reports existence of keys */
+	space := newSpace(spaceName, mockWriter{})
+	space.Set([]byte("name-1"), TestUser{Name: "name-1", Age: 1})
+
+	if found, err := space.Has([]byte("name-1")); err != nil || !found {
+		t.Fatalf("failed space.Has of existing key: found: %v, err: %v", found, err)
+	}
+	if found, err := space.Has([]byte("name-2")); err != nil || found {
+		t.Fatalf("failed space.Has of absent key: found: %v, err: %v", found, err)
+	}
+	if _, err := space.Has(nil); err != ErrKeyIsNil {
+		t.Fatalf("failed space.Has: have error '%v', expected '%v'", err, ErrKeyIsNil)
+	}
+}
+
 func TestSpaceGetFailedKeyIsNil(t *testing.T) {
 	/* test error: get from space with nil key */
 	space := newSpace(spaceName, mockWriter{})
@@ -235,3 +777,436 @@ func TestSpaceGetFailedNotFound(t *testing.T) {
 		t.Fatalf("failed scan.Get invalid error: have '%v', expected '%v'", err, ErrNotFound)
 	}
 }
+
+func TestSpaceDeleteIf(t *testing.T) {
+	/* test success DeleteIf: delete all records with even age */
+	space := newSpace(spaceName, mockWriter{})
+	expectedData := []TestUser{}
+	for i := 1; i <= 10; i++ {
+		user := TestUser{Name: fmt.Sprintf("name-%02d", i), Age: i}
+		space.Set([]byte(user.Name), user)
+		if i%2 == 1 {
+			expectedData = append(expectedData, user)
+		}
+	}
+
+	deleted, err := space.DeleteIf(func(key []byte, rawValue []byte) bool {
+		var user TestUser
+		if err := json.Unmarshal(rawValue, &user); err != nil {
+			t.Fatalf("failed to unmarshal raw value: %v", err)
+		}
+		return user.Age%2 == 0
+	})
+	if err != nil {
+		t.Fatalf("failed space.DeleteIf with error: %v", err)
+	}
+	if deleted != 5 {
+		t.Fatalf("failed result check: deleted %d records, expected %d", deleted, 5)
+	}
+
+	scannedData := []TestUser{}
+	if err := space.List(&scannedData); err != nil {
+		t.Fatalf("failed space.List with error: %v", err)
+	}
+	if !reflect.DeepEqual(scannedData, expectedData) {
+		t.Fatalf("failed result check: loaded data: '%v', expected data: '%v'", scannedData, expectedData)
+	}
+}
+
+func TestSpaceBulkDelete(t *testing.T) {
+	/* test BulkDelete deletes present keys by a single batch and reports absent ones */
+	batches := [][]*operation{}
+	space := newSpace(spaceName, batchMockWriter{batches: &batches})
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	keys := [][]byte{[]byte("name-1"), []byte("absent-1"), []byte("name-3"), []byte("name-1"), []byte("absent-2"), []byte("name-9")}
+	deleted, notFound, err := space.BulkDelete(keys)
+	if err != nil {
+		t.Fatalf("failed BulkDelete with error: %v", err)
+	}
+	if deleted != 3 {
+		t.Fatalf("failed result check: deleted: %d, expected: 3", deleted)
+	}
+	if expected := [][]byte{[]byte("absent-1"), []byte("absent-2")}; !reflect.DeepEqual(notFound, expected) {
+		t.Fatalf("failed result check: not found: %q, expected: %q", notFound, expected)
+	}
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("failed result check: batches: %d, expected: 1 batch of 3 deletes", len(batches))
+	}
+	for _, op := range batches[0] {
+		if op.Op != OPERATION_DEL {
+			t.Fatalf("failed result check: operation %s, expected %s", op.Op, OPERATION_DEL)
+		}
+	}
+	if space.Len() != 7 {
+		t.Fatalf("failed result check: len: %d, expected: 7", space.Len())
+	}
+
+	if deleted, notFound, _ = space.BulkDelete([][]byte{[]byte("name-1")}); deleted != 0 || len(notFound) != 1 || len(batches) != 1 {
+		t.Fatalf("failed result check: deleted: %d, not found: %q, batches: %d, expected nothing written", deleted, notFound, len(batches))
+	}
+	if _, _, err = space.BulkDelete([][]byte{[]byte("name-2"), nil}); err != ErrKeyIsNil {
+		t.Fatalf("failed BulkDelete invalid error: have '%v', expected '%v'", err, ErrKeyIsNil)
+	}
+}
+
+func TestSpaceDelByValue(t *testing.T) {
+	/* test success DelByValue: delete all records with age above 500 */
+	space := newSpace(spaceName, mockWriter{})
+	for i := 1; i <= 1000; i++ {
+		user := TestUser{Name: fmt.Sprintf("name-%04d", i), Age: i}
+		space.Set([]byte(user.Name), user)
+	}
+
+	deleted, err := space.DelByValue(func(rawJSON []byte) bool {
+		_, age, found := bytes.Cut(rawJSON, []byte(`"age":`))
+		if !found {
+			return false
+		}
+		n, err := strconv.Atoi(string(bytes.TrimSuffix(age, []byte("}"))))
+		if err != nil {
+			t.Fatalf("failed to parse age of %s: %v", rawJSON, err)
+		}
+		return n > 500
+	})
+	if err != nil {
+		t.Fatalf("failed space.DelByValue with error: %v", err)
+	}
+	if deleted != 500 || space.Len() != 500 {
+		t.Fatalf("failed result check: deleted %d records, left %d, expected %d and %d", deleted, space.Len(), 500, 500)
+	}
+	if last := fmt.Sprint(space.Max()); last != fmt.Sprint(TestUser{Name: "name-0500", Age: 500}) {
+		t.Fatalf("failed result check: max record: %s, expected name-0500", last)
+	}
+}
+
+func TestSpaceLenBytes(t *testing.T) {
+	/* test ExactLenBytes matches encoded size and LenBytes estimates it
+	with the sample rate of SpaceOptions */
+	space := newSpace(spaceName, mockWriter{})
+	rnd := rand.New(rand.NewSource(1))
+
+	var expected int64
+	for i := range 5000 {
+		size := max(int(50+10*rnd.NormFloat64()), 1)
+		user := TestUser{Name: fmt.Sprintf("%d-%s", i, strings.Repeat("x", size)), Age: i}
+		space.Set([]byte(user.Name), user)
+
+		raw, err := json.Marshal(user)
+		if err != nil {
+			t.Fatalf("failed json.Marshal with error: %v", err)
+		}
+		expected += int64(len(raw))
+	}
+
+	exact := space.ExactLenBytes()
+	if exact != expected {
+		t.Fatalf("failed result check: ExactLenBytes: %d, expected: %d", exact, expected)
+	}
+
+	estimate := space.LenBytes()
+	if diff := math.Abs(float64(estimate-exact)) / float64(exact); diff > 0.3 {
+		t.Fatalf("failed result check: LenBytes: %d differs from %d by %.2f", estimate, exact, diff)
+	}
+
+	// sampling every record is exact
+	space.opts.LenBytesSampleRate = 1
+	if estimate := space.LenBytes(); estimate != exact {
+		t.Fatalf("failed result check: LenBytes: %d with sample rate 1, expected: %d", estimate, exact)
+	}
+}
+
+func TestSpaceSizeBytes(t *testing.T) {
+	/* test SizeBytes sums encoded values and keys */
+	space := newSpace(spaceName, mockWriter{})
+
+	var expected int64
+	for i := range 100 {
+		user := TestUser{Name: fmt.Sprintf("name-%d", i), Age: i}
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		space.Set(key, user)
+
+		raw, err := json.Marshal(user)
+		if err != nil {
+			t.Fatalf("failed json.Marshal with error: %v", err)
+		}
+		expected += int64(len(raw) + len(key))
+	}
+
+	if size := space.SizeBytes(); size != expected {
+		t.Fatalf("failed result check: SizeBytes: %d, expected: %d", size, expected)
+	}
+	// space is smaller than the minimal sample
+	if size := space.SizeBytesEstimate(0.1); size != expected {
+		t.Fatalf("failed result check: SizeBytesEstimate: %d, expected: %d", size, expected)
+	}
+}
+
+func TestSpaceMerge(t *testing.T) {
+	/* test success Merge of a modified view:
+	- records modified in the view are written back
+	- records changed in the space after the view are kept
+	- records not modified in the view are not written
+	*/
+	wr := newLsnMockWriter()
+	space := newSpace(spaceName, wr)
+	for i := 1; i <= 3; i++ {
+		user := TestUser{Name: fmt.Sprintf("name-%d", i), Age: i}
+		space.Set([]byte(user.Name), user)
+	}
+
+	view := space.View()
+	// modify view externally
+	rec, _ := view.treeGet(&record{Key: []byte("name-1")})
+	view.treeSet(&record{LSN: rec.LSN, Key: rec.Key, Tag: rec.Tag, Value: TestUser{Name: "name-1", Age: 10}})
+	rec, _ = view.treeGet(&record{Key: []byte("name-2")})
+	view.treeSet(&record{LSN: rec.LSN, Key: rec.Key, Tag: rec.Tag, Value: TestUser{Name: "name-2", Age: 20}})
+
+	// change space after the view was taken
+	space.Set([]byte("name-2"), TestUser{Name: "name-2", Age: 200})
+
+	if err := space.Merge(view); err != nil {
+		t.Fatalf("failed space.Merge with error: %v", err)
+	}
+	// only name-1 is written back
+	if lsn := wr.LSN(); lsn != 5 {
+		t.Fatalf("failed result check: LSN after Merge: %d, expected: %d", lsn, 5)
+	}
+
+	expectedData := []TestUser{
+		{Name: "name-1", Age: 10},
+		{Name: "name-2", Age: 200},
+		{Name: "name-3", Age: 3},
+	}
+	scannedData := []TestUser{}
+	if err := space.List(&scannedData); err != nil {
+		t.Fatalf("failed space.List with error: %v", err)
+	}
+	if !reflect.DeepEqual(scannedData, expectedData) {
+		t.Fatalf("failed result check: loaded data: '%v', expected data: '%v'", scannedData, expectedData)
+	}
+}
+
+func TestSpaceMergeUnmodified(t *testing.T) {
+	/* test Merge of an unmodified view writes nothing */
+	wr := newLsnMockWriter()
+	space := newSpace(spaceName, wr)
+	for i := 1; i <= 100; i++ {
+		user := TestUser{Name: fmt.Sprintf("name-%d", i), Age: i}
+		space.Set([]byte(user.Name), user)
+	}
+
+	if err := space.Merge(space.View()); err != nil {
+		t.Fatalf("failed space.Merge with error: %v", err)
+	}
+	if lsn := wr.LSN(); lsn != 100 {
+		t.Fatalf("failed result check: LSN after Merge: %d, expected: %d", lsn, 100)
+	}
+}
+
+func TestSpaceSortedKeys(t *testing.T) {
+	/* test SortedKeys returns all keys in byte order */
+	space := newSpace(spaceName, mockWriter{})
+	rnd := rand.New(rand.NewSource(1))
+
+	keys := [][]byte{}
+	unique := map[string]bool{}
+	for len(keys) < 1000 {
+		key := make([]byte, 1+rnd.Intn(16))
+		rnd.Read(key)
+		if unique[string(key)] {
+			continue
+		}
+		unique[string(key)] = true
+		keys = append(keys, key)
+		space.Set(key, len(keys))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	sortedKeys := space.SortedKeys()
+	if !reflect.DeepEqual(sortedKeys, keys) {
+		t.Fatalf("failed result check: SortedKeys does not match sorted keys")
+	}
+}
+
+func TestSpaceViewKeepsOptions(t *testing.T) {
+	/* test View iterates in the same order and decodes like the space */
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	space := newSpaceWithComparator(spaceName, mockWriter{}, reverse)
+	space.opts.Encoder = func(v any) ([]byte, error) { return []byte(fmt.Sprint(v)), nil }
+	space.opts.Decoder = func(data []byte, v any) error {
+		*v.(*string) = "decoded:" + string(data)
+		return nil
+	}
+	for i := range 10 {
+		space.Set([]byte(fmt.Sprintf("name-%d", i)), i)
+	}
+
+	view := space.View()
+	if !reflect.DeepEqual(view.SortedKeys(), space.SortedKeys()) {
+		t.Fatalf("failed result check: view keys: %q, space keys: %q", view.SortedKeys(), space.SortedKeys())
+	}
+	if keys := view.SortedKeys(); string(keys[0]) != "name-9" {
+		t.Fatalf("failed result check: view keys are not in reverse order: %q", keys)
+	}
+
+	var value string
+	iter := view.Iter()
+	defer iter.Release()
+	if err := iter.Next(&value); err != nil || value != "decoded:9" {
+		t.Fatalf("failed view iteration: value %q, error: %v", value, err)
+	}
+}
+
+func TestSpaceCountRange(t *testing.T) {
+	/* test CountRange matches filtering of SortedKeys */
+	space := newSpace(spaceName, mockWriter{})
+	rnd := rand.New(rand.NewSource(1))
+	for range 1000 {
+		key := make([]byte, 1+rnd.Intn(8))
+		rnd.Read(key)
+		space.Set(key, 1)
+	}
+	keys := space.SortedKeys()
+
+	if space.CountRange(nil, nil) != space.Len() {
+		t.Fatalf("failed result check: CountRange(nil, nil): %d, expected: %d", space.CountRange(nil, nil), space.Len())
+	}
+	for range 100 {
+		from, to := make([]byte, 2), make([]byte, 2)
+		rnd.Read(from)
+		rnd.Read(to)
+		expected := 0
+		for _, key := range keys {
+			if bytes.Compare(key, from) >= 0 && bytes.Compare(key, to) < 0 {
+				expected++
+			}
+		}
+		if count := space.CountRange(from, to); count != expected {
+			t.Fatalf("failed result check: CountRange(%x, %x): %d, expected: %d", from, to, count, expected)
+		}
+	}
+
+	bound := keys[len(keys)/2]
+	if count := space.CountRange(nil, bound); count != len(keys)/2 {
+		t.Fatalf("failed result check: CountRange(nil, to): %d, expected: %d", count, len(keys)/2)
+	}
+	if count := space.CountRange(bound, nil); count != len(keys)-len(keys)/2 {
+		t.Fatalf("failed result check: CountRange(from, nil): %d, expected: %d", count, len(keys)-len(keys)/2)
+	}
+}
+
+func TestSpaceApplyAll(t *testing.T) {
+	/* test ApplyAll produces the same state as individual Set/Del calls */
+	expected := newSpace(spaceName, mockWriter{})
+	space := newSpace(spaceName, mockWriter{})
+
+	ops := make([]operation, 0, 10000)
+	for i := range 10000 {
+		key := []byte(fmt.Sprintf("name-%d", i%3000))
+		if i%4 == 3 {
+			expected.Del(key)
+			ops = append(ops, newOperation(&record{Key: key, Tag: spaceName}, OPERATION_DEL))
+			continue
+		}
+		user := TestUser{Name: string(key), Age: i}
+		expected.Set(key, user)
+		ops = append(ops, newOperation(&record{Key: key, Tag: spaceName, Value: user}, OPERATION_SET))
+	}
+
+	if err := space.ApplyAll(ops); err != nil {
+		t.Fatalf("failed space.ApplyAll with error: %v", err)
+	}
+
+	expectedData, scannedData := []TestUser{}, []TestUser{}
+	if err := expected.List(&expectedData); err != nil {
+		t.Fatalf("failed space.List with error: %v", err)
+	}
+	if err := space.List(&scannedData); err != nil {
+		t.Fatalf("failed space.List with error: %v", err)
+	}
+	if !reflect.DeepEqual(scannedData, expectedData) {
+		t.Fatalf("failed result check: applied data differs from expected data")
+	}
+}
+
+func TestSpaceApplyAllWithEncoder(t *testing.T) {
+	/* test ApplyAll encodes values and sets DefaultTTL like Set */
+	space := newSpace(spaceName, mockWriter{})
+	space.opts.Encoder = func(v any) ([]byte, error) { return []byte(fmt.Sprint(v)), nil }
+	space.opts.Decoder = func(data []byte, v any) error {
+		*v.(*string) = "decoded:" + string(data)
+		return nil
+	}
+	space.opts.DefaultTTL = time.Minute
+	ops := []operation{
+		newOperation(&record{Key: []byte("name-1"), Tag: spaceName, Value: 1}, OPERATION_SET),
+	}
+	if err := space.ApplyAll(ops); err != nil {
+		t.Fatalf("failed space.ApplyAll with error: %v", err)
+	}
+
+	var value string
+	if err := space.Get([]byte("name-1"), &value); err != nil || value != "decoded:1" {
+		t.Fatalf("failed Get: value %q, error: %v", value, err)
+	}
+	if deleted, err := space.reapExpired(time.Now().Add(time.Hour)); err != nil || deleted != 1 {
+		t.Fatalf("failed reapExpired: deleted %d, error: %v, expected 1", deleted, err)
+	}
+}
+
+func TestSpaceApplyAllFailedNotMatchSpace(t *testing.T) {
+	/* test error: operation of another space */
+	space := newSpace(spaceName, mockWriter{})
+	ops := []operation{
+		newOperation(&record{Key: []byte("name-1"), Tag: spaceName, Value: 1}, OPERATION_SET),
+		newOperation(&record{Key: []byte("name-2"), Tag: "other", Value: 2}, OPERATION_SET),
+	}
+
+	err := space.ApplyAll(ops)
+	if err != ErrOperationNotMatchSpace {
+		t.Fatalf("failed space.ApplyAll with invalid error: have '%v', expected '%v'", err, ErrOperationNotMatchSpace)
+	}
+	if space.Len() != 0 {
+		t.Fatalf("failed result check: space must stay empty, has %d records", space.Len())
+	}
+}
+
+func BenchmarkSpaceIter(b *testing.B) {
+	/* benchmark the copy made by Iter against iterating the live tree */
+	space := newSpace(spaceName, mockWriter{})
+	for i := range 100_000 {
+		space.Set([]byte(fmt.Sprintf("name-%06d", i)), i)
+	}
+	walk := func(b *testing.B, iter btree.IterG[*record]) {
+		n := 0
+		for ok := iter.First(); ok; ok = iter.Next() {
+			n++
+		}
+		iter.Release()
+		if n != 100_000 {
+			b.Fatalf("failed result check: iterated: %d, expected: %d", n, 100_000)
+		}
+	}
+	b.Run("live", func(b *testing.B) {
+		for range b.N {
+			walk(b, space.tree.Iter())
+		}
+	})
+	b.Run("copy", func(b *testing.B) {
+		for range b.N {
+			walk(b, space.tree.Copy().Iter())
+		}
+	})
+	// writes after the copy clone shared nodes of the tree
+	b.Run("copy_and_write", func(b *testing.B) {
+		for i := range b.N {
+			walk(b, space.tree.Copy().Iter())
+			space.Set([]byte(fmt.Sprintf("name-%06d", i%100_000)), i)
+		}
+	})
+}
@@ -0,0 +1,364 @@
+package kvdb
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes records and data files of the database
+type Stats struct {
+	Alive uint64 // records stored in all spaces
+	Dead  uint64 // operations in data files which do not back alive records
+	Files int    // number of actual data files
+	Bytes int64  // total size of actual data files
+
+	WriterPending uint64 // tasks queued to the writer
+
+	ErrorStats
+}
+
+// ErrorStats describes failed writes, rotations and compactions
+type ErrorStats struct {
+	ErrorCount uint64 // number of failures since open
+	LastError  string // message of the last failure, empty if none
+}
+
+// errorStats collects ErrorStats, it is updated by the writer goroutine
+type errorStats struct {
+	count atomic.Uint64
+	last  atomic.Pointer[string]
+}
+
+func (es *errorStats) record(err error) {
+	msg := err.Error()
+	es.last.Store(&msg)
+	es.count.Add(1)
+}
+
+func (es *errorStats) load() ErrorStats {
+	res := ErrorStats{ErrorCount: es.count.Load()}
+	if last := es.last.Load(); last != nil {
+		res.LastError = *last
+	}
+	return res
+}
+
+// ErrorStats returns number of failed writes, rotations and compactions
+// and the last error. It does not wait for the database lock.
+func (db *T) ErrorStats() ErrorStats {
+	return db.errs.load()
+}
+
+// DetailedStats extends Stats with data files and compaction history
+type DetailedStats struct {
+	Stats
+
+	JlogFileCount  int   // number of actual jlog files
+	SnapFileCount  int   // number of actual snapshot files
+	TotalFileBytes int64 // total size of actual data files
+
+	CompactionCount    uint64        // number of successful Compact calls
+	LastCompactionTime time.Time     // finish time of the last Compact, zero if none
+	LoadDuration       time.Duration // time spent loading data files on open
+}
+
+// CompactionEstimate describes what Compact would do now
+type CompactionEstimate struct {
+	DeadRecords         uint64 // operations removed by Compact
+	EstimatedBytesFreed int64  // size of dead operations by their average size
+	EstimatedDurationMs int64  // by the throughput of the last Compact, 0 if none
+}
+
+// OpenFileInfo describes a file held open by the database
+type OpenFileInfo struct {
+	Path      string
+	Mode      string // "r" or "rw"
+	SizeBytes int64
+}
+
+// CompactionResult describes finished compaction
+type CompactionResult struct {
+	Before       Stats
+	After        Stats
+	Duration     time.Duration
+	FilesRemoved int
+	BytesFreed   int64
+	Repaired     int  // discrepancies found by CompactWithRepair
+	Skipped      bool // dead ratio is below Options.CompactionRatio, nothing is written
+}
+
+// TotalStats returns stats of the whole database
+func (db *T) TotalStats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return Stats{}, ErrClosed
+	}
+	stats, _, err := db.stats()
+	return stats, err
+}
+
+// RecordCount returns the number of records in all spaces
+// without walking them, 0 if the database is closed
+func (db *T) RecordCount() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0
+	}
+	return db.aliveTotal.Load()
+}
+
+// DeadCount returns the number of operations in data files
+// which do not back records of spaces (overwritten and deleted records),
+// 0 if the database is closed
+func (db *T) DeadCount() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0
+	}
+	ops, alive := db.wr.Ops(), db.aliveTotal.Load()
+	if ops < alive {
+		return 0
+	}
+	return ops - alive
+}
+
+// Stats returns detailed stats of the whole database
+func (db *T) Stats() (DetailedStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return DetailedStats{}, ErrClosed
+	}
+	stats, sizes, err := db.stats()
+	if err != nil {
+		return DetailedStats{}, err
+	}
+
+	res := DetailedStats{
+		Stats:           stats,
+		TotalFileBytes:  stats.Bytes,
+		CompactionCount: db.compactions.Load(),
+		LoadDuration:    db.loadDuration,
+	}
+	if ts := db.lastCompaction.Load(); ts != 0 {
+		res.LastCompactionTime = time.Unix(0, ts)
+	}
+	for filePath := range sizes {
+		switch dataFileType(filePath) {
+		case JLOG_EXTENSION:
+			res.JlogFileCount++
+		case SNAP_EXTENSION:
+			res.SnapFileCount++
+		}
+	}
+	return res, nil
+}
+
+// TotalSize returns total size of actual data files:
+// the latest snapshot and jlog files written after it,
+// including the one which is currently written
+func (db *T) TotalSize() (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+	stats, _, err := db.stats()
+	return stats.Bytes, err
+}
+
+// OpenFiles returns data files held open by the database,
+// it is the current jlog file. Data files are opened only
+// while loaded or snapshotted otherwise.
+// Closed database holds no files.
+func (db *T) OpenFiles() ([]OpenFileInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return []OpenFileInfo{}, nil
+	}
+	return db.wr.OpenFiles()
+}
+
+// WriterPending returns number of tasks queued to the writer
+func (db *T) WriterPending() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return 0
+	}
+	return db.wr.Pending()
+}
+
+// Compact writes a snapshot of all spaces and removes data files covered by it,
+// so dead records do not occupy disk anymore.
+// It is skipped while the dead ratio is below Options.CompactionRatio.
+// Hooks registered by OnCompact are called with the result.
+func (db *T) Compact() (CompactionResult, error) {
+	return db.compactAndNotify(RepairKeepBtree, false)
+}
+
+// compactAndNotify compacts the database and calls hooks with the result,
+// data files are checked against spaces only if repair is set
+func (db *T) compactAndNotify(mode RepairMode, repair bool) (CompactionResult, error) {
+	res, hook, err := db.compact(mode, repair)
+	if err != nil {
+		return res, err
+	}
+	if hook != nil {
+		hook(res)
+	}
+	return res, nil
+}
+
+// CompactEstimate estimates savings of Compact without writing anything.
+// Dead operations are assumed to have the average size of operations
+// in data files, the duration is estimated by the size of the snapshot
+// to write and the throughput of the last Compact.
+func (db *T) CompactEstimate() (CompactionEstimate, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return CompactionEstimate{}, ErrClosed
+	}
+	stats, _, err := db.stats()
+	if err != nil {
+		return CompactionEstimate{}, err
+	}
+	est := CompactionEstimate{DeadRecords: stats.Dead}
+	if ops := stats.Alive + stats.Dead; ops > 0 {
+		est.EstimatedBytesFreed = int64(float64(stats.Bytes) / float64(ops) * float64(stats.Dead))
+	}
+	if db.compactionRate > 0 {
+		written := float64(stats.Bytes - est.EstimatedBytesFreed)
+		est.EstimatedDurationMs = int64(written / db.compactionRate * 1000)
+	}
+	return est, nil
+}
+
+func (db *T) compact(mode RepairMode, repair bool) (res CompactionResult, hook func(CompactionResult), err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return res, nil, ErrClosed
+	}
+	if db.wr.Lazy() {
+		return res, nil, ErrLazyLoaded
+	}
+
+	start := time.Now()
+	var before, after map[string]int64
+	if res.Before, before, err = db.stats(); err != nil {
+		return res, nil, err
+	}
+	if repair {
+		if res.Repaired, err = db.repair(mode); err != nil {
+			return res, nil, err
+		}
+	}
+	if mode == RepairKeepBtree && res.Before.deadRatio() < db.opts.CompactionRatio {
+		res.After, res.Skipped = res.Before, true
+		res.Duration = time.Since(start)
+		return res, nil, nil
+	}
+
+	spaces := db.views()
+	var estimated int64
+	for _, space := range spaces {
+		estimated += space.SizeBytesEstimate(space.opts.lenBytesSampleRate())
+	}
+	logger := db.opts.logger()
+	logger.Info("CompactionStarted",
+		slog.Int("spaces", len(spaces)),
+		slog.Uint64("totalRecords", res.Before.Alive),
+		slog.Uint64("totalDeadRecords", res.Before.Dead),
+		slog.Int64("estimatedBytesToWrite", estimated),
+	)
+
+	if err = db.wr.Snapshot(&spaces); err != nil {
+		db.errs.record(err)
+		return res, nil, err
+	}
+
+	if res.After, after, err = db.stats(); err != nil {
+		return res, nil, err
+	}
+	for filePath, size := range before {
+		if _, ok := after[filePath]; !ok {
+			res.FilesRemoved++
+			res.BytesFreed += size
+		}
+	}
+	var written int64
+	for filePath, size := range after {
+		if _, ok := before[filePath]; !ok && dataFileType(filePath) == SNAP_EXTENSION {
+			written += size
+		}
+	}
+	res.Duration = time.Since(start)
+	if secs := res.Duration.Seconds(); secs > 0 && written > 0 {
+		db.compactionRate = float64(written) / secs
+	}
+	logger.Info("CompactionFinished",
+		slog.Duration("duration", res.Duration),
+		slog.Int("filesRemoved", res.FilesRemoved),
+		slog.Int64("bytesFreed", res.BytesFreed),
+		slog.Int64("bytesWritten", written),
+		slog.String("throughput", humanize(float64(written)/res.Duration.Seconds())),
+	)
+
+	db.compactions.Add(1)
+	db.lastCompaction.Store(time.Now().UnixNano())
+
+	return res, db.hooks.compacted, nil
+}
+
+// deadRatio returns dead/(alive+dead) ratio of records, 0 if there are none
+func (s Stats) deadRatio() float64 {
+	if s.Alive+s.Dead == 0 {
+		return 0
+	}
+	return float64(s.Dead) / float64(s.Alive+s.Dead)
+}
+
+// stats collects database stats and sizes of actual data files
+func (db *T) stats() (Stats, map[string]int64, error) {
+	var stats Stats
+	for _, space := range db.spaces {
+		stats.Alive += uint64(space.Len())
+	}
+	if ops := db.wr.Ops(); ops > stats.Alive {
+		stats.Dead = ops - stats.Alive
+	}
+	stats.WriterPending = uint64(db.wr.Pending())
+	stats.ErrorStats = db.errs.load()
+
+	filePathes, err := db.wr.DataFiles()
+	if err != nil {
+		return stats, nil, err
+	}
+	sizes := make(map[string]int64, len(filePathes))
+	for _, filePath := range filePathes {
+		fi, err := db.opts.storage().Stat(filePath)
+		if err != nil {
+			return stats, nil, err
+		}
+		sizes[filePath] = fi.Size()
+		stats.Files++
+		stats.Bytes += fi.Size()
+	}
+	return stats, sizes, nil
+}
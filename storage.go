@@ -0,0 +1,89 @@
+package kvdb
+
+import (
+	"io"
+	"os"
+)
+
+// Storage stores data files of the database.
+// Paths are given as they are built from the database directory.
+type Storage interface {
+	OpenFile(path string, flag int, perm os.FileMode) (StorageFile, error)
+	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(path string) ([]os.DirEntry, error)
+	Remove(path string) error
+	Rename(oldpath, newpath string) error
+	Stat(path string) (os.FileInfo, error)
+}
+
+// DirSyncer is implemented by Storage which can make created, renamed
+// and removed entries of a directory durable. Directories of Storage
+// which does not implement it are not synced.
+type DirSyncer interface {
+	SyncDir(path string) error
+}
+
+// syncStorageDir syncs the directory if st implements DirSyncer
+func syncStorageDir(st Storage, path string) error {
+	if ds, ok := st.(DirSyncer); ok {
+		return ds.SyncDir(path)
+	}
+	return nil
+}
+
+// StorageFile is a data file opened by Storage
+type StorageFile interface {
+	io.Reader
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// OSStorage stores data files in the local filesystem
+type OSStorage struct{}
+
+func (OSStorage) OpenFile(path string, flag int, perm os.FileMode) (StorageFile, error) {
+	fh, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return fh, nil
+}
+
+func (OSStorage) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OSStorage) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func (OSStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (OSStorage) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSStorage) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (OSStorage) SyncDir(path string) error {
+	return syncDir(path)
+}
+
+// syncDir fsyncs the directory, so renames of its files
+// survive a power failure
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = fh.Sync(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
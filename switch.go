@@ -0,0 +1,74 @@
+package kvdb
+
+import (
+	"errors"
+	"maps"
+	"path/filepath"
+)
+
+// SwitchDir replaces the database with the one stored in newDir.
+// newDir is locked and loaded while the database keeps serving requests,
+// then the current writer is stopped (queued writes are written
+// to the current directory) and all following writes go to newDir.
+// Spaces obtained before SwitchDir must be obtained again.
+// It fails with ErrLockTimeout if newDir is locked by another database.
+// If SwitchDir fails after the current writer is stopped the database is closed.
+func (db *T) SwitchDir(newDir string) (err error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	same := filepath.Clean(newDir) == filepath.Clean(db.dir)
+	prev := maps.Clone(db.spaces)
+	db.mu.RUnlock()
+	if same {
+		return ErrSameDir
+	}
+
+	next := &T{opts: db.opts, dir: newDir, registry: db.registry}
+	if next.lock, err = acquireLock(newDir, 0); err != nil {
+		return err
+	}
+	next.wr = db.newWriter(newDir)
+	next.initSpaces(prev)
+	if err = next.wr.Load(next.replayTxn); err == nil {
+		err = next.loadCommitPoint()
+	}
+	if err == nil {
+		err = next.loadCreatedAt()
+	}
+	if err != nil {
+		_ = releaseLock(next.lock)
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		_ = releaseLock(next.lock)
+		return ErrClosed
+	}
+
+	err = errors.Join(db.wr.Close(), releaseLock(db.lock))
+	db.dir, db.wr, db.lock, db.spaces = next.dir, next.wr, next.lock, next.spaces
+	for name, sp := range db.spaces {
+		db.bindSpace(&sp)
+		db.spaces[name] = sp
+	}
+	db.aliveTotal.Store(next.aliveTotal.Load())
+	db.commitPoint.Store(next.commitPoint.Load())
+	db.createdAt = next.createdAt
+	if err == nil {
+		err = db.wr.Start()
+	}
+	if err != nil {
+		_ = releaseLock(db.lock)
+		db.watchers.stopAll()
+		close(db.reaper)
+		db.closed = true
+		db.spaces = nil
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package kvdb
+
+// SyncMode defines when the current jlog file is synced to disk
+type SyncMode int32
+
+const (
+	// SyncNone syncs the file only on rotation and close
+	SyncNone SyncMode = iota
+	// SyncFull syncs the file after every write request
+	// (Write, WriteMany batch or SetOrUpdate)
+	SyncFull
+	// SyncAll syncs the file after every written operation
+	SyncAll
+)
+
+func (m SyncMode) valid() bool {
+	return m >= SyncNone && m <= SyncAll
+}
+
+// SetSyncMode changes the sync mode of the writer.
+// Already written operations are not affected.
+func (w *defaultWriter) SetSyncMode(mode SyncMode) error {
+	if !mode.valid() {
+		return ErrSyncModeUnknown
+	}
+	w.syncMode.Store(int32(mode))
+	return nil
+}
+
+// synced syncs the current file after a successful write request
+// if the writer is in SyncFull mode
+func (w *defaultWriter) synced(err error) error {
+	if err != nil || SyncMode(w.syncMode.Load()) != SyncFull {
+		return err
+	}
+	return w.fail(w.file.Sync())
+}
+
+// syncedOp syncs the current file after a successful write
+// if the writer is in SyncAll mode
+func (w *defaultWriter) syncedOp(err error) error {
+	if err != nil || SyncMode(w.syncMode.Load()) != SyncAll {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Flush waits until tasks queued before it are written
+// and syncs the current file in the working goroutine
+func (w *defaultWriter) Flush() error {
+	return w.Exec(func(func(*operation) error) error {
+		if w.file == nil {
+			return nil
+		}
+		return w.fail(w.file.Sync())
+	})
+}
+
+// Flush waits until all writes queued before it are written to the log
+// and syncs the current jlog file to disk, so they survive a crash.
+// Unlike Close the database stays open and the writer keeps running.
+// Failures of queued writes are returned to their callers,
+// Flush returns the error of the sync.
+func (db *T) Flush() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.wr.Flush()
+}
+
+// SetSyncMode changes when the current jlog file is synced to disk,
+// see SyncMode. It can be called at any time.
+func (db *T) SetSyncMode(mode SyncMode) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.wr.SetSyncMode(mode)
+}
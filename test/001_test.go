@@ -1,12 +1,19 @@
 package main_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/ochaton/kvdb"
 	"github.com/ochaton/kvdb/test/helpers"
 )
 
@@ -182,3 +189,706 @@ func BenchmarkWrite(b *testing.B) {
 		}
 	})
 }
+
+func TestKVDBSetOrUpdateConcurrent(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	counters, err := db.NewSpace("counters")
+	if err != nil {
+		t.Fatalf("failed to create space counters: %v", err)
+	}
+	add := func(existing, incoming any) any {
+		return existing.(int) + incoming.(int)
+	}
+
+	workers, iterations, keys := 10, 100, 5
+	actions := make([]helpers.Action, 0, workers)
+	for range workers {
+		actions = append(actions, func() error {
+			for i := range iterations {
+				key := []byte(fmt.Sprintf("counter-%d", i%keys))
+				if err := counters.SetOrUpdate(key, 1, add); err != nil {
+					return fmt.Errorf("failed to update counter: %v", err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := helpers.RunInParallel(actions); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for i := range keys {
+		var ret int
+		if err := counters.Get([]byte(fmt.Sprintf("counter-%d", i)), &ret); err != nil {
+			t.Fatalf("failed to get counter: %v", err)
+		}
+		if want := workers * iterations / keys; ret != want {
+			t.Fatalf("got counter %d, want %d", ret, want)
+		}
+	}
+
+	// panic in merge is returned as an error
+	err = counters.SetOrUpdate([]byte("counter-0"), 1, func(existing, incoming any) any {
+		panic("boom")
+	})
+	if !errors.Is(err, kvdb.ErrMergePanicked) {
+		t.Fatalf("got %v, want %v", err, kvdb.ErrMergePanicked)
+	}
+}
+
+func TestKVDBUpsertConcurrent(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	counters, err := db.NewSpace("counters")
+	if err != nil {
+		t.Fatalf("failed to create space counters: %v", err)
+	}
+
+	workers := 100
+	key := []byte("hits")
+	errUpdate := errors.New("update failed")
+	actions := make([]helpers.Action, 0, workers)
+	for range workers {
+		actions = append(actions, func() error {
+			return counters.Upsert(key, 1, func(existing any) (any, error) {
+				n, ok := existing.(int)
+				if !ok {
+					return nil, errUpdate
+				}
+				return n + 1, nil
+			})
+		})
+	}
+	if err := helpers.RunInParallel(actions); err != nil {
+		t.Fatalf("failed Upsert: %v", err)
+	}
+
+	var ret int
+	if err := counters.Get(key, &ret); err != nil {
+		t.Fatalf("failed to get counter: %v", err)
+	}
+	if ret != workers {
+		t.Fatalf("lost updates: got %d, want %d", ret, workers)
+	}
+
+	// a failed update writes nothing
+	err = counters.Upsert(key, 1, func(existing any) (any, error) {
+		return nil, errUpdate
+	})
+	if err != errUpdate {
+		t.Fatalf("got error %v from failed update, want %v", err, errUpdate)
+	}
+	if err := counters.Get(key, &ret); err != nil || ret != workers {
+		t.Fatalf("got counter %d (%v) after failed update, want %d", ret, err, workers)
+	}
+}
+
+func TestKVDBSpaceLens(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	expected := map[string]int{"users": 0, "books": 0, "__system__": 0}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for name := range expected {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		for i, item := range dataGen.Create(10) {
+			if err := space.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set item: %v", err)
+			}
+			expected[name]++
+			if i%3 == 0 {
+				if err := space.Del([]byte(item.Name)); err != nil {
+					t.Fatalf("failed to del item: %v", err)
+				}
+				expected[name]--
+			}
+		}
+	}
+
+	if lens := db.AllSpaceLens(); !reflect.DeepEqual(lens, expected) {
+		t.Fatalf("got %v, want %v", lens, expected)
+	}
+	delete(expected, "__system__")
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, expected) {
+		t.Fatalf("got %v, want %v", lens, expected)
+	}
+}
+
+func setupGetBenchmark(b *testing.B, warm bool) *kvdb.Space {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	users, err := db.NewSpace("users")
+	if err != nil {
+		b.Fatalf("failed to create space users: %v", err)
+	}
+	entries := []kvdb.KeyValuePair{}
+	for _, item := range (&helpers.UniqueDataGenerator{}).Create(100000) {
+		entries = append(entries, kvdb.KeyValuePair{Key: []byte(item.Name), Value: item})
+	}
+	if err := users.SetMany(entries); err != nil {
+		b.Fatalf("failed to set users: %v", err)
+	}
+	if warm {
+		if err := db.WarmCache("users", func() any { return &helpers.TestUser{} }); err != nil {
+			b.Fatalf("failed to warm cache: %v", err)
+		}
+	}
+	return users
+}
+
+func benchmarkGet(b *testing.B, warm bool) {
+	users := setupGetBenchmark(b, warm)
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("Alice-%d", i*100+1))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := range b.N {
+		var user helpers.TestUser
+		if err := users.Get(keys[i%len(keys)], &user); err != nil {
+			b.Fatalf("failed to get user: %v", err)
+		}
+	}
+}
+
+func BenchmarkGetCold(b *testing.B) { benchmarkGet(b, false) }
+func BenchmarkGetWarm(b *testing.B) { benchmarkGet(b, true) }
+
+func TestKVDBWarmCache(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for _, item := range (&helpers.UniqueDataGenerator{}).Create(100) {
+		if err := users.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	if err := db.WarmCache("users", nil); err != nil {
+		t.Fatalf("failed to warm cache: %v", err)
+	}
+	decoded := 0
+	if err := db.WarmCache("users", func() any { decoded++; return &helpers.TestUser{} }); err != nil {
+		t.Fatalf("failed to warm cache: %v", err)
+	}
+	if decoded != 100 {
+		t.Fatalf("got %d decoded values, want %d", decoded, 100)
+	}
+
+	// warmed and overwritten records are read as they are stored
+	var first helpers.TestUser
+	if err := users.Get([]byte("Alice-1"), &first); err != nil || first.Name != "Alice-1" {
+		t.Fatalf("failed to get warmed user: %v (%v)", first, err)
+	}
+	changed := helpers.TestUser{Name: first.Name, Age: first.Age + 1}
+	if err := users.Set([]byte(changed.Name), changed); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	var got helpers.TestUser
+	if err := users.Get([]byte(changed.Name), &got); err != nil || got != changed {
+		t.Fatalf("got user %v (%v) after warm cache, want %v", got, err, changed)
+	}
+	if err := db.WarmCache("orders", nil); !errors.Is(err, kvdb.ErrSpaceNotFound) {
+		t.Fatalf("got warm cache error '%v', want '%v'", err, kvdb.ErrSpaceNotFound)
+	}
+}
+
+func TestKVDBGetSetJSON(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	raw := json.RawMessage(`{"name":"Alice","age":30,"tags":["admin",{"level":2}]}`)
+	if err = users.SetJSON([]byte("Alice"), raw); err != nil {
+		t.Fatalf("failed to set json: %v", err)
+	}
+	if err = users.SetJSON([]byte("Bob"), json.RawMessage(`{"name":`)); err == nil {
+		t.Fatalf("set invalid json, want error")
+	}
+	var user helpers.TestUser
+	if err = users.Get([]byte("Alice"), &user); err != nil || user != (helpers.TestUser{Name: "Alice", Age: 30}) {
+		t.Fatalf("got user %+v (%v), want Alice", user, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	got, err := users.GetJSON([]byte("Alice"))
+	if err != nil {
+		t.Fatalf("failed to get json: %v", err)
+	}
+	var gotValue, expectedValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("got invalid json %s: %v", got, err)
+	}
+	_ = json.Unmarshal(raw, &expectedValue)
+	if !reflect.DeepEqual(gotValue, expectedValue) {
+		t.Fatalf("got json %s after restart, want %s", got, raw)
+	}
+	if _, err = users.GetJSON([]byte("Bob")); err != kvdb.ErrNotFound {
+		t.Fatalf("got error %v for missing key, want %v", err, kvdb.ErrNotFound)
+	}
+}
+
+func TestKVDBSpaceCreates(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	if _, err = db.ExistingSpace("users"); !errors.Is(err, kvdb.ErrSpaceNotFound) {
+		t.Fatalf("got %v for missing space, want %v", err, kvdb.ErrSpaceNotFound)
+	}
+	users, err := db.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	alice := helpers.TestUser{Name: "Alice", Age: 30}
+	if err = users.Set([]byte(alice.Name), alice); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	for _, get := range []func(string) (*kvdb.Space, error){db.Space, db.ExistingSpace} {
+		space, err := get("users")
+		if err != nil {
+			t.Fatalf("failed to get space users: %v", err)
+		}
+		var ret helpers.TestUser
+		if err = space.Get([]byte(alice.Name), &ret); err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		if ret != alice {
+			t.Fatalf("got %v, want %v", ret, alice)
+		}
+	}
+}
+
+func TestKVDBUpdateWrittenSpaces(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	for _, name := range []string{"users", "orders", "items"} {
+		if _, err = db.NewSpace(name); err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+	}
+
+	alice := helpers.TestUser{Name: "Alice", Age: 30}
+	written, err := db.Update(func(space kvdb.GetSpace) error {
+		if err := space("users").Set([]byte(alice.Name), alice); err != nil {
+			return err
+		}
+		if err := space("users").Set([]byte("Bob"), helpers.TestUser{Name: "Bob"}); err != nil {
+			return err
+		}
+		if err := space("orders").Del([]byte(alice.Name)); err != nil {
+			return err
+		}
+		var ret helpers.TestUser
+		if err := space("items").Get([]byte(alice.Name), &ret); err != kvdb.ErrNotFound {
+			return fmt.Errorf("got %v reading items, want %v", err, kvdb.ErrNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if want := []string{"orders", "users"}; !reflect.DeepEqual(written, want) {
+		t.Fatalf("got written spaces %v, want %v", written, want)
+	}
+
+	written, err = db.Update(func(space kvdb.GetSpace) error {
+		var ret helpers.TestUser
+		return space("users").Get([]byte(alice.Name), &ret)
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if len(written) != 0 {
+		t.Fatalf("got written spaces %v for read-only transaction, want none", written)
+	}
+}
+
+func TestKVDBGETyped(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	data := dataGen.Create(10)
+	for i, item := range data {
+		if err = users.Set([]byte(fmt.Sprintf("user-%02d", i)), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// values loaded from data files are decoded into V
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if users, err = db.ExistingSpace("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	got := []helpers.TestUser{}
+	err = kvdb.GETyped(users, []byte("user-04"), func(key []byte, user helpers.TestUser) bool {
+		if want := fmt.Sprintf("user-%02d", 4+len(got)); string(key) != want {
+			t.Fatalf("got key %s, want %s", key, want)
+		}
+		got = append(got, user)
+		return len(got) < 3
+	})
+	if err != nil {
+		t.Fatalf("failed to walk users: %v", err)
+	}
+	if !reflect.DeepEqual(got, data[4:7]) {
+		t.Fatalf("got users %v, want %v", got, data[4:7])
+	}
+
+	if err = users.Set([]byte("user-05"), "not a user"); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	walked := 0
+	err = kvdb.GETyped(users, nil, func(key []byte, user helpers.TestUser) bool {
+		walked++
+		return true
+	})
+	if err == nil || walked != 5 {
+		t.Fatalf("got error %v after %d users, want decoding error after 5", err, walked)
+	}
+}
+
+func TestKVDBOpenExclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db")
+	db, err := kvdb.OpenExclusive(path)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err = kvdb.OpenExclusive(path); err != kvdb.ErrDatabaseExists {
+		t.Fatalf("got error %v creating an open db, want %v", err, kvdb.ErrDatabaseExists)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if _, err = kvdb.OpenExclusive(path); err != kvdb.ErrDatabaseExists {
+		t.Fatalf("got error %v creating an existing db, want %v", err, kvdb.ErrDatabaseExists)
+	}
+	if db, err = kvdb.Open(path); err != nil {
+		t.Fatalf("failed to open existing db: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// an empty directory is not a database
+	if err = os.Mkdir(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if db, err = kvdb.OpenExclusive(filepath.Join(dir, "other")); err != nil {
+		t.Fatalf("failed to create db in an empty dir: %v", err)
+	}
+	db.Close()
+}
+
+func TestKVDBSpaceIndex(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	// values are structs after Set and maps after reopen
+	byName := func(value any) []byte {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		var user helpers.TestUser
+		if err := json.Unmarshal(data, &user); err != nil || user.Name == "" {
+			return nil
+		}
+		return []byte(user.Name)
+	}
+	users, err := db.NewSpaceWithIndex("users", byName)
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	data := (&helpers.UniqueDataGenerator{}).Create(10)
+	for i, user := range data {
+		if err = users.Set([]byte(strconv.Itoa(i)), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	for _, user := range data {
+		var got helpers.TestUser
+		if err = users.GetByIndex([]byte(user.Name), &got); err != nil {
+			t.Fatalf("failed to get user %s by index: %v", user.Name, err)
+		}
+		if !helpers.Compare(got, user) {
+			t.Fatalf("got user %v, want %v", got, user)
+		}
+	}
+
+	// renamed and deleted records leave the index
+	view := users.View()
+	renamed := data[0]
+	renamed.Name = "Renamed"
+	if err = users.Set([]byte("0"), renamed); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err = users.Del([]byte("1")); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	var got helpers.TestUser
+	for _, name := range []string{data[0].Name, data[1].Name} {
+		if err = users.GetByIndex([]byte(name), &got); err != kvdb.ErrNotFound {
+			t.Fatalf("got error %v for user %s, want %v", err, name, kvdb.ErrNotFound)
+		}
+	}
+	if err = view.GetByIndex([]byte(data[1].Name), &got); err != nil || got != data[1] {
+		t.Fatalf("got user %v and error %v from the view, want %v", got, err, data[1])
+	}
+
+	// the index is rebuilt on open
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if users, err = db.ExistingSpace("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if err = users.GetByIndex([]byte("Renamed"), &got); err != kvdb.ErrNoIndex {
+		t.Fatalf("got error %v without index, want %v", err, kvdb.ErrNoIndex)
+	}
+	if users, err = db.NewSpaceWithIndex("users", byName); err != nil {
+		t.Fatalf("failed to index space users: %v", err)
+	}
+	if err = users.GetByIndex([]byte("Renamed"), &got); err != nil || got != renamed {
+		t.Fatalf("got user %v and error %v after reopen, want %v", got, err, renamed)
+	}
+	if err = users.GetByIndex([]byte(data[1].Name), &got); err != kvdb.ErrNotFound {
+		t.Fatalf("got error %v for deleted user, want %v", err, kvdb.ErrNotFound)
+	}
+}
+
+func TestKVDBInfo(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	for _, name := range []string{"users", "customers"} {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		if err = space.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	info := db.Info()
+	if info.Version == "" || info.Version != kvdb.Version {
+		t.Fatalf("got version %q, want %q", info.Version, kvdb.Version)
+	}
+	if info.Path != helpers.DbPath || info.CurrentLSN != 2 || info.SpaceCount != 2 {
+		t.Fatalf("got info %+v", info)
+	}
+	if time.Since(info.CreatedAt) > time.Minute {
+		t.Fatalf("got creation time %v, want now", info.CreatedAt)
+	}
+
+	// the creation time is kept by following opens
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if info := db.Info(); info != (kvdb.DatabaseInfo{}) {
+		t.Fatalf("got info %+v of closed db, want zero", info)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if reopened := db.Info(); !reopened.CreatedAt.Equal(info.CreatedAt) {
+		t.Fatalf("got creation time %v after reopen, want %v", reopened.CreatedAt, info.CreatedAt)
+	}
+
+	// the creation time of existing databases is unknown
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if err = os.Remove(filepath.Join(helpers.DbPath, kvdb.CREATED_FILE)); err != nil {
+		t.Fatalf("failed to remove %s: %v", kvdb.CREATED_FILE, err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if created := db.Info().CreatedAt; !created.IsZero() {
+		t.Fatalf("got creation time %v of existing db, want zero", created)
+	}
+}
+
+func TestKVDBRunMigration(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	data := (&helpers.UniqueDataGenerator{}).Create(10)
+	for _, user := range data {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	runs := 0
+	// renames field name of all users to full_name
+	rename := func(db *kvdb.T) error {
+		runs++
+		users, err := db.ExistingSpace("users")
+		if err != nil {
+			return err
+		}
+		var all []map[string]any
+		if err := users.List(&all); err != nil {
+			return err
+		}
+		for _, user := range all {
+			user["full_name"] = user["name"]
+			delete(user, "name")
+			if err := users.Set([]byte(user["full_name"].(string)), user); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for range 2 {
+		if err = db.RunMigration(1, rename); err != nil {
+			t.Fatalf("failed to run migration: %v", err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("got %d runs of migration, want 1", runs)
+	}
+	for _, user := range data {
+		var got map[string]any
+		if err = users.Get([]byte(user.Name), &got); err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		if _, ok := got["name"]; ok || got["full_name"] != user.Name {
+			t.Fatalf("got user %v after migration, want full_name %s", got, user.Name)
+		}
+	}
+
+	// failed migrations do not change the version
+	errMigration := errors.New("migration failed")
+	if err = db.RunMigration(2, func(*kvdb.T) error { return errMigration }); err != errMigration {
+		t.Fatalf("got error %v, want %v", err, errMigration)
+	}
+	if version, err := db.GetMetadata(kvdb.SCHEMA_VERSION_KEY); err != nil || version != "1" {
+		t.Fatalf("got version %q and error %v, want 1", version, err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if version, err := db.GetMetadata(kvdb.SCHEMA_VERSION_KEY); err != nil || version != "1" {
+		t.Fatalf("got version %q and error %v after reopen, want 1", version, err)
+	}
+	if err = db.RunMigration(1, rename); err != nil || runs != 1 {
+		t.Fatalf("got error %v and %d runs after reopen, want migration skipped", err, runs)
+	}
+	if _, err = db.GetMetadata("unknown"); err != kvdb.ErrNotFound {
+		t.Fatalf("got error %v for unknown metadata, want %v", err, kvdb.ErrNotFound)
+	}
+}
+
+func TestKVDBRunMigrationAfterDropAllSpaces(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+
+	runs := 0
+	migrate := func(*kvdb.T) error { runs++; return nil }
+	if err = db.RunMigration(1, migrate); err != nil {
+		t.Fatalf("failed to run migration: %v", err)
+	}
+	if err = db.DropAllSpaces(); err != nil {
+		t.Fatalf("failed to drop spaces: %v", err)
+	}
+
+	// the schema version is kept by the drop, also after its replay
+	for _, stage := range []string{"after drop", "after reopen"} {
+		if err = db.RunMigration(1, migrate); err != nil || runs != 1 {
+			t.Fatalf("got error %v and %d runs %s, want migration skipped", err, runs, stage)
+		}
+		if err = db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+		if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err = db.RunMigration(2, migrate); err != nil || runs != 2 {
+		t.Fatalf("got error %v and %d runs, want the next migration run", err, runs)
+	}
+}
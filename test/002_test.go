@@ -1,9 +1,21 @@
 package main_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/ochaton/kvdb"
 	"github.com/ochaton/kvdb/test/helpers"
 )
 
@@ -89,3 +101,1948 @@ func TestKVDBSnapshoting(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestKVDBSnapshotTo(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(5)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	destDir := filepath.Join(t.TempDir(), "snapshots")
+	if err := db.SnapshotTo(destDir); err != nil {
+		t.Fatalf("failed to snapshot to %s: %v", destDir, err)
+	}
+
+	// snapshot appears in destDir with the LSN of the last write
+	snapPath := filepath.Join(destDir, fmt.Sprintf("%010d.snap", len(users)))
+	if _, err := os.Stat(snapPath); err != nil {
+		t.Fatalf("snapshot file is missing: %v", err)
+	}
+
+	// data directory contains no snapshots
+	snaps, err := filepath.Glob(filepath.Join(helpers.DbPath, "*.snap"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(snaps) != 0 {
+		t.Fatalf("got snapshots %v in data directory, want none", snaps)
+	}
+}
+
+func TestKVDBCompact(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(1000) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		if err = usersSpace.Del([]byte(item.Name)); err != nil {
+			t.Fatalf("failed to del user: %v", err)
+		}
+	}
+
+	var hookResult kvdb.CompactionResult
+	db.OnCompact(func(res kvdb.CompactionResult) { hookResult = res })
+
+	res, err := db.Compact()
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if res.FilesRemoved < 1 {
+		t.Fatalf("got %d files removed, want at least 1", res.FilesRemoved)
+	}
+	if res.BytesFreed <= 0 {
+		t.Fatalf("got %d bytes freed, want positive", res.BytesFreed)
+	}
+	if res.Before.Dead != 2000 || res.After.Dead != 0 {
+		t.Fatalf("got dead records %d -> %d, want 2000 -> 0", res.Before.Dead, res.After.Dead)
+	}
+	if hookResult != res {
+		t.Fatalf("got hook result %v, want %v", hookResult, res)
+	}
+}
+
+func TestKVDBLifecycleHooks(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	recorder := &helpers.LogRecorder{}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Logger: slog.New(recorder)})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for _, item := range (&helpers.UniqueDataGenerator{}).Create(10) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	type snapshot struct {
+		path string
+		lsn  uint64
+	}
+	var calls []string
+	var results []kvdb.CompactionResult
+	var snapshots []snapshot
+	db.OnCompact(func(kvdb.CompactionResult) { panic("compact hook failed") })
+	first := db.OnCompact(func(kvdb.CompactionResult) { calls = append(calls, "first") })
+	db.OnCompact(func(res kvdb.CompactionResult) {
+		calls = append(calls, "second")
+		results = append(results, res)
+	})
+	snapHook := db.OnSnapshot(func(snapshotPath string, lsn uint64) {
+		snapshots = append(snapshots, snapshot{snapshotPath, lsn})
+	})
+
+	lsn := db.CurrentLSN()
+	res, err := db.Compact()
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"first", "second"}) || len(results) != 1 || results[0] != res {
+		t.Fatalf("got hook calls %v with results %v, want first and second with %v", calls, results, res)
+	}
+	want := snapshot{filepath.Join(helpers.DbPath, fmt.Sprintf("%010d.snap", lsn)), lsn}
+	if len(snapshots) != 1 || snapshots[0] != want {
+		t.Fatalf("got snapshots %v, want %v", snapshots, want)
+	}
+	if _, err = os.Stat(snapshots[0].path); err != nil {
+		t.Fatalf("failed to stat snapshot: %v", err)
+	}
+	if attrs, ok := recorder.Find("HookPanicked"); !ok || attrs["hook"].String() != "compact" {
+		t.Fatalf("got HookPanicked %v, %v, want compact hook logged", attrs, ok)
+	}
+
+	// removed hooks are not called
+	db.RemoveCompactHook(first)
+	db.RemoveSnapshotHook(snapHook)
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if _, err = db.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"first", "second", "second"}) || len(snapshots) != 1 {
+		t.Fatalf("got hook calls %v and snapshots %v after removal", calls, snapshots)
+	}
+}
+
+func TestKVDBCompactEstimate(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	data := dataGen.Create(100)
+	dead := 0
+	change := func() {
+		for i, item := range data {
+			if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set user: %v", err)
+			}
+			switch {
+			case i%5 == 0:
+				// the set and the delete are dead
+				if err = usersSpace.Del([]byte(item.Name)); err != nil {
+					t.Fatalf("failed to del user: %v", err)
+				}
+				if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+					t.Fatalf("failed to set user: %v", err)
+				}
+				dead += 2
+			case i%2 == 0:
+				dataGen.Change(&data[i])
+				if err = usersSpace.Set([]byte(item.Name), data[i]); err != nil {
+					t.Fatalf("failed to change user: %v", err)
+				}
+				dead++
+			}
+		}
+	}
+	change()
+
+	est, err := db.CompactEstimate()
+	if err != nil {
+		t.Fatalf("failed to estimate compaction: %v", err)
+	}
+	stats, err := db.TotalStats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if est.DeadRecords != uint64(dead) || est.DeadRecords != stats.Dead {
+		t.Fatalf("got %d dead records, want %d", est.DeadRecords, dead)
+	}
+	if est.EstimatedBytesFreed <= 0 || est.EstimatedBytesFreed >= stats.Bytes {
+		t.Fatalf("got %d bytes freed of %d, want part of them", est.EstimatedBytesFreed, stats.Bytes)
+	}
+	if est.EstimatedDurationMs != 0 {
+		t.Fatalf("got duration %dms before the first compaction, want 0", est.EstimatedDurationMs)
+	}
+
+	// the estimate does not write anything
+	if after, _ := db.TotalStats(); after.Bytes != stats.Bytes || after.Dead != stats.Dead {
+		t.Fatalf("got stats %+v after estimate, want %+v", after, stats)
+	}
+	if _, err = db.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if est, err = db.CompactEstimate(); err != nil || est.DeadRecords != 0 || est.EstimatedBytesFreed != 0 {
+		t.Fatalf("got estimate %+v and error %v after compaction, want nothing to free", est, err)
+	}
+	// sets of compacted records are dead as well
+	dead = len(data)
+	change()
+	if est, err = db.CompactEstimate(); err != nil || est.DeadRecords != uint64(dead) || est.EstimatedDurationMs < 0 {
+		t.Fatalf("got estimate %+v and error %v, want %d dead records", est, err, dead)
+	}
+}
+
+func TestKVDBCompactionRatio(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{CompactionRatio: 0.5})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	clean := dataGen.Create(100)
+	dirty := dataGen.Create(100)
+	for name, items := range map[string][]helpers.TestUser{"clean": clean, "dirty": dirty} {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		for _, item := range items {
+			if err = space.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set %s: %v", name, err)
+			}
+		}
+	}
+
+	res, err := db.Compact()
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if !res.Skipped || res.FilesRemoved != 0 {
+		t.Fatalf("got %+v without dead records, want skipped compaction", res)
+	}
+
+	dirtySpace, err := db.Space("dirty")
+	if err != nil {
+		t.Fatalf("failed to get space dirty: %v", err)
+	}
+	for _, item := range dirty {
+		if err = dirtySpace.Del([]byte(item.Name)); err != nil {
+			t.Fatalf("failed to del dirty: %v", err)
+		}
+	}
+	if res, err = db.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if res.Skipped || res.Before.Dead != 200 || res.After.Dead != 0 {
+		t.Fatalf("got %+v, want compaction of 200 dead records", res)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lens := db.SpaceLens(); lens["clean"] != len(clean) || lens["dirty"] != 0 {
+		t.Fatalf("got spaces %v after restart, want %d clean records and no dirty ones", lens, len(clean))
+	}
+}
+
+func TestKVDBCompactionEvents(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	recorder := &helpers.LogRecorder{}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Logger: slog.New(recorder)})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(100) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		dataGen.Change(&item)
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if _, err = db.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+
+	started, ok := recorder.Find("CompactionStarted")
+	if !ok {
+		t.Fatalf("event CompactionStarted is not logged")
+	}
+	for _, key := range []string{"spaces", "totalRecords", "totalDeadRecords", "estimatedBytesToWrite"} {
+		if v, ok := started[key]; !ok || v.String() == "0" {
+			t.Fatalf("got CompactionStarted %s = %v, want non-zero", key, v)
+		}
+	}
+	finished, ok := recorder.Find("CompactionFinished")
+	if !ok {
+		t.Fatalf("event CompactionFinished is not logged")
+	}
+	for _, key := range []string{"duration", "filesRemoved", "bytesFreed", "bytesWritten"} {
+		if v, ok := finished[key]; !ok || v.String() == "0" || v.String() == "0s" {
+			t.Fatalf("got CompactionFinished %s = %v, want non-zero", key, v)
+		}
+	}
+}
+
+func TestKVDBDetailedStats(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.CompactionCount != 0 || !stats.LastCompactionTime.IsZero() {
+		t.Fatalf("got %d compactions at %v, want none", stats.CompactionCount, stats.LastCompactionTime)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for range 2 {
+		for _, item := range dataGen.Create(100) {
+			if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set user: %v", err)
+			}
+		}
+		if _, err = db.Compact(); err != nil {
+			t.Fatalf("failed to compact: %v", err)
+		}
+	}
+
+	stats, err = db.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.CompactionCount != 2 {
+		t.Fatalf("got %d compactions, want 2", stats.CompactionCount)
+	}
+	if since := time.Since(stats.LastCompactionTime); since < 0 || since > time.Minute {
+		t.Fatalf("got last compaction at %v, want recent", stats.LastCompactionTime)
+	}
+	if stats.SnapFileCount != 1 {
+		t.Fatalf("got %d snap files, want 1", stats.SnapFileCount)
+	}
+	if stats.JlogFileCount+stats.SnapFileCount != stats.Files {
+		t.Fatalf("got %d jlog and %d snap files, want %d in total", stats.JlogFileCount, stats.SnapFileCount, stats.Files)
+	}
+	if stats.TotalFileBytes <= 0 || stats.TotalFileBytes != stats.Bytes {
+		t.Fatalf("got %d total file bytes, want %d", stats.TotalFileBytes, stats.Bytes)
+	}
+	if stats.Alive != 200 {
+		t.Fatalf("got %d alive records, want 200", stats.Alive)
+	}
+}
+
+func TestKVDBCopyTo(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(10)
+	for i, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		if i%2 == 0 {
+			dataGen.Change(&item)
+			users[i] = item
+			if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set existing user: %v", err)
+			}
+		}
+	}
+
+	destPath := filepath.Join(t.TempDir(), "copy")
+	if err := db.CopyTo(destPath); err != nil {
+		t.Fatalf("failed to copy db: %v", err)
+	}
+	if err := db.CopyTo(destPath); err == nil {
+		t.Fatalf("copy into existing directory must fail")
+	}
+
+	jlogs, err := filepath.Glob(filepath.Join(destPath, "*.jlog"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(jlogs) != 0 {
+		t.Fatalf("got jlogs %v in copy, want none", jlogs)
+	}
+
+	cp, err := kvdb.Open(destPath)
+	if err != nil {
+		t.Fatalf("failed to open copy: %v", err)
+	}
+	defer cp.Close()
+	cpUsers, err := cp.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users from copy: %v", err)
+	}
+	copied, expected := []helpers.TestUser{}, []helpers.TestUser{}
+	if err := cpUsers.List(&copied); err != nil {
+		t.Fatalf("failed to list users from copy: %v", err)
+	}
+	if err := usersSpace.List(&expected); err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(expected) != len(users) || !reflect.DeepEqual(copied, expected) {
+		t.Fatalf("got %v, want %v", copied, expected)
+	}
+}
+
+func TestKVDBRestore(t *testing.T) {
+	backupPath := filepath.Join(t.TempDir(), "backup")
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(100) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	var backup []helpers.TestUser
+	if err = usersSpace.List(&backup); err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if err = db.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	backupInfo, backupCommitPoint := db.Info(), db.CommitPoint()
+	if err = db.CopyTo(backupPath); err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	for _, name := range []string{kvdb.CREATED_FILE, kvdb.CHECKPOINT_FILE} {
+		if _, err = os.Stat(filepath.Join(backupPath, name)); err != nil {
+			t.Fatalf("failed to find %s in backup: %v", name, err)
+		}
+	}
+
+	for _, item := range dataGen.Create(10) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if _, err = db.NewSpace("orders"); err != nil {
+		t.Fatalf("failed to create space orders: %v", err)
+	}
+	if err = db.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+
+	if err = db.Restore(backupPath); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if cp := db.CommitPoint(); cp != backupCommitPoint {
+		t.Fatalf("got commit point %d after restore, want %d of backup", cp, backupCommitPoint)
+	}
+	if created := db.Info().CreatedAt; !created.Equal(backupInfo.CreatedAt) {
+		t.Fatalf("got creation time %v after restore, want %v", created, backupInfo.CreatedAt)
+	}
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"users": 100}) {
+		t.Fatalf("got space lens %v after restore, want %v", lens, map[string]int{"users": 100})
+	}
+	usersSpace, err = db.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	var restored []helpers.TestUser
+	if err = usersSpace.List(&restored); err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if !reflect.DeepEqual(restored, backup) {
+		t.Fatalf("restored users do not match backup")
+	}
+
+	// restored database accepts writes and keeps them on reopen
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user after restore: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if lens := db.SpaceLens(); lens["users"] != 101 {
+		t.Fatalf("got %d users after reopen, want 101", lens["users"])
+	}
+}
+
+func TestKVDBTotalSize(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(1000) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	size, err := db.TotalSize()
+	if err != nil {
+		t.Fatalf("failed to get total size: %v", err)
+	}
+	if size <= 0 {
+		t.Fatalf("got total size %d, want positive", size)
+	}
+	if usersSpace.EstimatedSize() <= 0 {
+		t.Fatalf("got estimated size %d, want positive", usersSpace.EstimatedSize())
+	}
+
+	for _, item := range dataGen.Create(10) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	grown, err := db.TotalSize()
+	if err != nil {
+		t.Fatalf("failed to get total size: %v", err)
+	}
+	if grown <= size {
+		t.Fatalf("got total size %d after writes, want more than %d", grown, size)
+	}
+}
+
+func TestKVDBDefrag(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	ordersSpace, err := db.NewSpace("orders")
+	if err != nil {
+		t.Fatalf("failed to create space orders: %v", err)
+	}
+
+	// leave LSN gaps between data files
+	dataGen := &helpers.UniqueDataGenerator{}
+	for range 3 {
+		for i, item := range dataGen.Create(100) {
+			if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set user: %v", err)
+			}
+			if i%2 == 0 {
+				if err = usersSpace.Del([]byte(item.Name)); err != nil {
+					t.Fatalf("failed to del user: %v", err)
+				}
+			}
+		}
+		if err = db.Snapshot(); err != nil {
+			t.Fatalf("failed to snapshot: %v", err)
+		}
+	}
+	if err = ordersSpace.Set([]byte("order-1"), "Alice-1"); err != nil {
+		t.Fatalf("failed to set order: %v", err)
+	}
+	lens := db.SpaceLens()
+
+	if err = db.Defrag(); err != nil {
+		t.Fatalf("failed to defrag: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(helpers.DbPath, "*.*"))
+	if err != nil {
+		t.Fatalf("failed to list data files: %v", err)
+	}
+	sort.Strings(files)
+	expected := []string{
+		filepath.Join(helpers.DbPath, "0000000151.snap"),
+		filepath.Join(helpers.DbPath, "0000000152.jlog"),
+		filepath.Join(helpers.DbPath, kvdb.CREATED_FILE),
+		filepath.Join(helpers.DbPath, "kvdb.lock"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("got files %v after defrag, want %v", files, expected)
+	}
+
+	// database accepts writes and all records survive restart
+	usersSpace, err = db.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user after defrag: %v", err)
+	}
+	lens["users"]++
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if got := db.SpaceLens(); !reflect.DeepEqual(got, lens) {
+		t.Fatalf("got space lens %v after restart, want %v", got, lens)
+	}
+}
+
+func TestKVDBSnapshotProgress(t *testing.T) {
+	type progress struct {
+		spacesDone, spacesTotal   int
+		recordsDone, recordsTotal int64
+	}
+	calls := []progress{}
+
+	helpers.CleanDB(helpers.DbPath)
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{
+		SnapshotProgressCallback: func(spacesDone, spacesTotal int, recordsDone, recordsTotal int64) {
+			calls = append(calls, progress{spacesDone, spacesTotal, recordsDone, recordsTotal})
+		},
+		SnapshotProgressInterval: 1000,
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, name := range []string{"users", "customers"} {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		entries := []kvdb.KeyValuePair{}
+		for _, item := range dataGen.Create(2500) {
+			entries = append(entries, kvdb.KeyValuePair{Key: []byte(item.Name), Value: item})
+		}
+		if err := space.SetMany(entries); err != nil {
+			t.Fatalf("failed to set users: %v", err)
+		}
+	}
+
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if len(calls) < 7 {
+		t.Fatalf("got %d progress calls, want at least 7", len(calls))
+	}
+	for i, call := range calls {
+		if call.spacesTotal != 2 || call.recordsTotal != 5000 {
+			t.Fatalf("got progress %+v, want 2 spaces and 5000 records in total", call)
+		}
+		if i > 0 && (call.recordsDone < calls[i-1].recordsDone || call.spacesDone < calls[i-1].spacesDone) {
+			t.Fatalf("got progress %+v after %+v, want monotonic", call, calls[i-1])
+		}
+	}
+	if last := calls[len(calls)-1]; last.spacesDone != 2 || last.recordsDone != 5000 {
+		t.Fatalf("got last progress %+v, want all spaces and records done", last)
+	}
+}
+
+func TestKVDBGC(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(100) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	before, err := filepath.Glob(filepath.Join(helpers.DbPath, "*.*"))
+	if err != nil {
+		t.Fatalf("failed to list data files: %v", err)
+	}
+
+	// files left by an interrupted cleanup and a snapshot in progress
+	orphans := map[string]string{
+		"0000000001.jlog": `{"lsn":1,"op":"set","time":0,"record":{"tag":"users","key":"Bob","value":{}}}` + "\n",
+		"0000000050.snap": `{"lsn":50,"op":"set","time":0,"record":{"tag":"users","key":"Eve","value":{}}}` + "\n",
+	}
+	var orphansSize int64
+	for _, content := range orphans {
+		orphansSize += int64(len(content))
+	}
+	orphans["0000000200.snap.inprogress"] = ""
+	if err = helpers.SetupDataFiles(helpers.DbPath, orphans); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	res, err := db.GC()
+	if err != nil {
+		t.Fatalf("failed to gc: %v", err)
+	}
+	if res.Removed != 2 || res.FreedBytes != orphansSize {
+		t.Fatalf("got gc result %+v, want 2 files of %d bytes removed", res, orphansSize)
+	}
+	after, err := filepath.Glob(filepath.Join(helpers.DbPath, "*.*"))
+	if err != nil {
+		t.Fatalf("failed to list data files: %v", err)
+	}
+	expected := append(before, filepath.Join(helpers.DbPath, "0000000200.snap.inprogress"))
+	sort.Strings(expected)
+	if !reflect.DeepEqual(after, expected) {
+		t.Fatalf("got files %v after gc, want %v", after, expected)
+	}
+
+	if err = usersSpace.Set([]byte("Bob"), helpers.TestUser{Name: "Bob"}); err != nil {
+		t.Fatalf("failed to set user after gc: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"users": 102}) {
+		t.Fatalf("got space lens %v after restart, want %v", lens, map[string]int{"users": 102})
+	}
+}
+
+func TestKVDBRewindTo(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(100)
+	for i, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		switch i {
+		case 29:
+			if err = db.Snapshot(); err != nil {
+				t.Fatalf("failed to snapshot: %v", err)
+			}
+		case 74:
+			// operations after the checkpoint span several jlog files
+			if err = db.Close(); err != nil {
+				t.Fatalf("failed to close db: %v", err)
+			}
+			if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if usersSpace, err = db.Space("users"); err != nil {
+				t.Fatalf("failed to get space users: %v", err)
+			}
+		}
+	}
+	header, err := usersSpace.GetHeader([]byte(users[49].Name))
+	if err != nil {
+		t.Fatalf("failed to get header: %v", err)
+	}
+
+	if err = db.RewindTo(header.LSN); err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	check := func(stage string) {
+		usersSpace, err = db.Space("users")
+		if err != nil {
+			t.Fatalf("%s: failed to get space users: %v", stage, err)
+		}
+		if n := usersSpace.Len(); n != 50 {
+			t.Fatalf("%s: got %d users, want 50", stage, n)
+		}
+		for i, item := range users {
+			var ret helpers.TestUser
+			err := usersSpace.Get([]byte(item.Name), &ret)
+			if i < 50 && err != nil {
+				t.Fatalf("%s: failed to get user %d: %v", stage, i, err)
+			}
+			if i >= 50 && err != kvdb.ErrNotFound {
+				t.Fatalf("%s: got error %v for rewound user %d, want %v", stage, err, i, kvdb.ErrNotFound)
+			}
+		}
+	}
+	check("after rewind")
+
+	// new writes continue after the checkpoint
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user after rewind: %v", err)
+	}
+	if alice, err := usersSpace.GetHeader([]byte("Alice")); err != nil || alice.LSN != header.LSN+1 {
+		t.Fatalf("got header %+v (%v) after rewind, want LSN %d", alice, err, header.LSN+1)
+	}
+	if err = usersSpace.Del([]byte("Alice")); err != nil {
+		t.Fatalf("failed to del user after rewind: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	check("after restart")
+
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = db.RewindTo(header.LSN - 10); err != kvdb.ErrLSNInSnapshot {
+		t.Fatalf("got error %v for rewind into snapshot, want %v", err, kvdb.ErrLSNInSnapshot)
+	}
+	check("after rewind into snapshot")
+}
+
+func TestKVDBLoadProgress(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(1000) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	backupPath := helpers.DbPath + "/backup"
+	if err = db.CopyTo(backupPath); err != nil {
+		t.Fatalf("failed to copy db: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// replaying is slowed down to observe the progress of Restore
+	var samples [][2]int64
+	onWrite := func(ev kvdb.WriteEvent) {
+		if !ev.Replay || db == nil {
+			return
+		}
+		time.Sleep(10 * time.Microsecond)
+		loaded, total := db.LoadProgress()
+		samples = append(samples, [2]int64{loaded, total})
+	}
+	db = nil
+	db, err = kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{OnWrite: onWrite, LoadProgressInterval: 4096})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if loaded, total := db.LoadProgress(); loaded != total || total == 0 {
+		t.Fatalf("got load progress %d/%d after open, want all bytes loaded", loaded, total)
+	}
+
+	if err = db.Restore(backupPath); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	loaded, total := db.LoadProgress()
+	if loaded != total || total == 0 {
+		t.Fatalf("got load progress %d/%d after restore, want all bytes loaded", loaded, total)
+	}
+	inProgress := 0
+	for _, sample := range samples {
+		if sample[1] != total || sample[0] > total {
+			t.Fatalf("got load progress %d/%d while restoring, want at most %d", sample[0], sample[1], total)
+		}
+		if sample[0] > 0 && sample[0] < total {
+			inProgress++
+		}
+	}
+	if len(samples) != 1000 || inProgress == 0 {
+		t.Fatalf("got %d samples, %d of them in progress, want 1000 samples with some in progress", len(samples), inProgress)
+	}
+}
+
+func TestKVDBSwitchDir(t *testing.T) {
+	otherPath := t.TempDir()
+	other, err := kvdb.Open(otherPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	customers, err := other.NewSpace("customers")
+	if err != nil {
+		t.Fatalf("failed to create space customers: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(5) {
+		if err = customers.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set customer: %v", err)
+		}
+	}
+	if err = other.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = other.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for _, item := range dataGen.Create(10) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	if err = db.SwitchDir(helpers.DbPath); err != kvdb.ErrSameDir {
+		t.Fatalf("got error %v switching to the same dir, want %v", err, kvdb.ErrSameDir)
+	}
+	if err = db.SwitchDir(otherPath); err != nil {
+		t.Fatalf("failed to switch dir: %v", err)
+	}
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"customers": 5}) {
+		t.Fatalf("got space lens %v after switch, want %v", lens, map[string]int{"customers": 5})
+	}
+	if _, err = db.ExistingSpace("users"); err != kvdb.ErrSpaceNotFound {
+		t.Fatalf("got error %v for space users after switch, want %v", err, kvdb.ErrSpaceNotFound)
+	}
+	if customers, err = db.Space("customers"); err != nil {
+		t.Fatalf("failed to get space customers: %v", err)
+	}
+	watcher, err := customers.Watch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to watch customers: %v", err)
+	}
+	if err = customers.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set customer after switch: %v", err)
+	}
+	if ev := <-watcher.Events(); string(ev.Key) != "Alice" {
+		t.Fatalf("got change of %s after switch, want Alice", ev.Key)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// writes went to the new directory, the old one is released
+	if db, err = kvdb.Open(otherPath); err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"customers": 6}) {
+		t.Fatalf("got space lens %v after reopen, want %v", lens, map[string]int{"customers": 6})
+	}
+	old, err := kvdb.OpenWithLockTimeout(helpers.DbPath, 0)
+	if err != nil {
+		t.Fatalf("failed to open old dir: %v", err)
+	}
+	defer old.Close()
+	if lens := old.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"users": 10}) {
+		t.Fatalf("got space lens %v of old dir, want %v", lens, map[string]int{"users": 10})
+	}
+}
+
+func TestKVDBSwitchDirLocked(t *testing.T) {
+	otherPath := t.TempDir()
+	other, err := kvdb.Open(otherPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer other.Close()
+
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	// the switch fails without waiting for the lock, the database keeps working
+	if err = db.SwitchDir(otherPath); err != kvdb.ErrLockTimeout {
+		t.Fatalf("got error %v switching to a locked dir, want %v", err, kvdb.ErrLockTimeout)
+	}
+	if err = usersSpace.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user after failed switch: %v", err)
+	}
+}
+
+func TestKVDBMsgpackCodec(t *testing.T) {
+	// a database written as JSON Lines is opened with MsgpackCodec
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for i := range 10 {
+		user := helpers.TestUser{Name: fmt.Sprintf("Alice\n%d", i), Age: i}
+		if err := users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	opts := kvdb.Options{Codec: kvdb.MsgpackCodec{}}
+	if db, err = kvdb.OpenWithOptions(helpers.DbPath, opts); err != nil {
+		t.Fatalf("failed to open db with msgpack codec: %v", err)
+	}
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	for i := 10; i < 20; i++ {
+		user := helpers.TestUser{Name: fmt.Sprintf("Alice\n%d", i), Age: i}
+		if err := users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// both JSON and msgpack files are loaded
+	if db, err = kvdb.OpenWithOptions(helpers.DbPath, opts); err != nil {
+		t.Fatalf("failed to reopen db with msgpack codec: %v", err)
+	}
+	defer db.Close()
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	check := func() {
+		t.Helper()
+		if users.Len() != 20 {
+			t.Fatalf("got %d users, want 20", users.Len())
+		}
+		for i := range 20 {
+			var user helpers.TestUser
+			name := fmt.Sprintf("Alice\n%d", i)
+			if err := users.Get([]byte(name), &user); err != nil {
+				t.Fatalf("failed to get user %q: %v", name, err)
+			}
+			if user.Age != i {
+				t.Fatalf("got %v, want age %d", user, i)
+			}
+		}
+	}
+	check()
+
+	// the snapshot is written with the codec extension
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	entries, err := os.ReadDir(helpers.DbPath)
+	if err != nil {
+		t.Fatalf("failed to read db dir: %v", err)
+	}
+	snaps := 0
+	for _, ent := range entries {
+		name := ent.Name()
+		if strings.HasSuffix(name, ".snap.mp") {
+			snaps++
+		} else if strings.Contains(name, ".snap") || strings.Contains(name, ".jlog") && !strings.HasSuffix(name, ".jlog.mp") {
+			t.Fatalf("got data file %s, want msgpack files only", name)
+		}
+	}
+	if snaps != 1 {
+		t.Fatalf("got %d msgpack snapshots, want 1", snaps)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = kvdb.OpenWithOptions(helpers.DbPath, opts); err != nil {
+		t.Fatalf("failed to reopen db from msgpack snapshot: %v", err)
+	}
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	check()
+}
+
+// failingCodec fails to marshal operations while fails is positive
+type failingCodec struct {
+	kvdb.JSONCodec
+	fails *int
+}
+
+func (c failingCodec) Marshal(v any) ([]byte, error) {
+	if *c.fails > 0 {
+		*c.fails--
+		return nil, &fs.PathError{Op: "write", Path: "0000000001.jlog", Err: syscall.ENOSPC}
+	}
+	return c.JSONCodec.Marshal(v)
+}
+
+func TestKVDBErrorStats(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	fails := 0
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Codec: failingCodec{fails: &fails}})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if stats := db.ErrorStats(); stats != (kvdb.ErrorStats{}) {
+		t.Fatalf("got error stats %+v before failures, want none", stats)
+	}
+
+	fails = 10
+	for i, item := range (&helpers.UniqueDataGenerator{}).Create(20) {
+		err := usersSpace.Set([]byte(item.Name), item)
+		if i < 10 && err == nil {
+			t.Fatalf("set user %d with failing codec, want error", i)
+		}
+		if i >= 10 && err != nil {
+			t.Fatalf("failed to set user %d: %v", i, err)
+		}
+	}
+	if n := usersSpace.Len(); n != 10 {
+		t.Fatalf("got %d users, want 10", n)
+	}
+
+	stats := db.ErrorStats()
+	if stats.ErrorCount != 10 || !strings.Contains(stats.LastError, syscall.ENOSPC.Error()) {
+		t.Fatalf("got error stats %+v, want 10 errors with %q", stats, syscall.ENOSPC.Error())
+	}
+	total, err := db.TotalStats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if total.ErrorStats != stats {
+		t.Fatalf("got error stats %+v in TotalStats, want %+v", total.ErrorStats, stats)
+	}
+}
+
+func TestKVDBCloseDrainErrors(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	fails := 0
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Codec: failingCodec{fails: &fails}})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	// writes are queued while paused and drained by Close
+	resume, err := db.PauseWrites()
+	if err != nil {
+		t.Fatalf("failed to pause writes: %v", err)
+	}
+	fails = 5
+	users := (&helpers.UniqueDataGenerator{}).Create(10)
+	for _, item := range users {
+		go usersSpace.Set([]byte(item.Name), item)
+	}
+	for db.WriterPending() != len(users) {
+		time.Sleep(time.Millisecond)
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- db.Close() }()
+	time.Sleep(100 * time.Millisecond)
+	resume()
+
+	err = <-closed
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("got close error %v, want %v", err, syscall.ENOSPC)
+	}
+	if n := strings.Count(err.Error(), syscall.ENOSPC.Error()); n != 5 {
+		t.Fatalf("got %d failed writes in close error %q, want 5", n, err)
+	}
+}
+
+func TestKVDBAutoSnapshot(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	recorder := &helpers.LogRecorder{}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Logger: slog.New(recorder)})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	if _, err = db.AutoSnapshot(context.Background(), 0); err != kvdb.ErrIntervalNotPositive {
+		t.Fatalf("got error %v for zero interval, want %v", err, kvdb.ErrIntervalNotPositive)
+	}
+	as, err := db.AutoSnapshot(context.Background(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to start auto snapshot: %v", err)
+	}
+	defer as.Stop()
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	var last time.Time
+	for batch := range 2 {
+		items := dataGen.Create(10)
+		for _, item := range items {
+			if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set user: %v", err)
+			}
+		}
+		header, err := usersSpace.GetHeader([]byte(items[len(items)-1].Name))
+		if err != nil {
+			t.Fatalf("failed to get header: %v", err)
+		}
+		for deadline := time.Now().Add(time.Second); !as.LastSnapshotTime().After(last); {
+			if time.Now().After(deadline) {
+				t.Fatalf("batch %d: snapshot is not taken", batch)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		last = as.LastSnapshotTime()
+
+		snapPath := filepath.Join(helpers.DbPath, fmt.Sprintf("%010d.snap", header.LSN))
+		if _, err := os.Stat(snapPath); err != nil {
+			t.Fatalf("batch %d: snapshot of LSN %d is not found: %v", batch, header.LSN, err)
+		}
+	}
+
+	// nothing is written, so no snapshots are taken
+	time.Sleep(200 * time.Millisecond)
+	if got := as.LastSnapshotTime(); !got.Equal(last) {
+		t.Fatalf("got snapshot at %v without writes, want none after %v", got, last)
+	}
+	if _, ok := recorder.Find("AutoSnapshot"); !ok {
+		t.Fatalf("event AutoSnapshot is not logged")
+	}
+}
+
+func TestKVDBApplySnapshot(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	logsSpace, err := db.NewSpace("logs")
+	if err != nil {
+		t.Fatalf("failed to create space logs: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(10)
+	otherUsers := append([]helpers.TestUser(nil), users...)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	for _, item := range dataGen.Create(20) {
+		if err = logsSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set log: %v", err)
+		}
+	}
+	// the last users are advanced past the snapshot
+	for i := 5; i < len(users); i++ {
+		dataGen.Change(&users[i])
+		if err = usersSpace.Set([]byte(users[i].Name), users[i]); err != nil {
+			t.Fatalf("failed to change user: %v", err)
+		}
+	}
+
+	otherDir := t.TempDir()
+	other, err := helpers.SetupDB(filepath.Join(otherDir, "db"), true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	padSpace, err := other.NewSpace("pad")
+	if err != nil {
+		t.Fatalf("failed to create space pad: %v", err)
+	}
+	for _, item := range dataGen.Create(10) {
+		if err = padSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set pad: %v", err)
+		}
+	}
+	otherUsersSpace, err := other.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for i := range otherUsers {
+		dataGen.Change(&otherUsers[i])
+		if err = otherUsersSpace.Set([]byte(otherUsers[i].Name), otherUsers[i]); err != nil {
+			t.Fatalf("failed to set other user: %v", err)
+		}
+	}
+	snapDir := filepath.Join(otherDir, "snap")
+	if err = other.SnapshotTo(snapDir); err != nil {
+		t.Fatalf("failed to snapshot other db: %v", err)
+	}
+	if err = other.Close(); err != nil {
+		t.Fatalf("failed to close other db: %v", err)
+	}
+	snaps, err := filepath.Glob(filepath.Join(snapDir, "*.snap"))
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("got snapshots %v (%v), want one", snaps, err)
+	}
+
+	res, err := db.ApplySnapshot(snaps[0])
+	if err != nil {
+		t.Fatalf("failed to apply snapshot: %v", err)
+	}
+	if want := (kvdb.ApplySnapshotResult{Applied: 15, Skipped: 5}); res != want {
+		t.Fatalf("got %+v, want %+v", res, want)
+	}
+
+	check := func(stage string) {
+		usersSpace, err := db.Space("users")
+		if err != nil {
+			t.Fatalf("%s: failed to get space users: %v", stage, err)
+		}
+		for i := range users {
+			want := users[i]
+			if i < 5 {
+				want = otherUsers[i]
+			}
+			var ret helpers.TestUser
+			if err := usersSpace.Get([]byte(want.Name), &ret); err != nil {
+				t.Fatalf("%s: failed to get user %d: %v", stage, i, err)
+			}
+			if !helpers.Compare(ret, want) {
+				t.Fatalf("%s: got user %+v, want %+v", stage, ret, want)
+			}
+		}
+		padSpace, err := db.Space("pad")
+		if err != nil {
+			t.Fatalf("%s: failed to get space pad: %v", stage, err)
+		}
+		if n := padSpace.Len(); n != 10 {
+			t.Fatalf("%s: got %d pad records, want 10", stage, n)
+		}
+	}
+	check("applied")
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	check("reopened")
+}
+
+func TestKVDBLazyLoad(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(10)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	changed := append([]helpers.TestUser(nil), users[:5]...)
+	for i := range changed {
+		dataGen.Change(&changed[i])
+		if err = usersSpace.Set([]byte(changed[i].Name), changed[i]); err != nil {
+			t.Fatalf("failed to change user: %v", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	recorder := &helpers.LogRecorder{}
+	db, err = kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{LazyLoad: true, Logger: slog.New(recorder)})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, ok := recorder.Find("LazyLoadWarning"); !ok {
+		t.Fatalf("event LazyLoadWarning is not logged")
+	}
+	if usersSpace, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	for _, item := range users {
+		var ret helpers.TestUser
+		if err = usersSpace.Get([]byte(item.Name), &ret); err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		if !helpers.Compare(ret, item) {
+			t.Fatalf("got user %+v, want snapshot value %+v", ret, item)
+		}
+	}
+
+	var events []kvdb.WriteEvent
+	err = db.ReplayRange(0, 0, func(ev kvdb.WriteEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(events) != len(changed) {
+		t.Fatalf("got %d replayed events, want %d", len(events), len(changed))
+	}
+	for i, ev := range events {
+		if ev.Op != "set" || ev.Space != "users" || string(ev.Key) != changed[i].Name || !ev.Replay {
+			t.Fatalf("got replayed event %+v, want set of %s", ev, changed[i].Name)
+		}
+	}
+
+	// writes continue after the skipped operations
+	item := dataGen.Create(1)[0]
+	if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	header, err := usersSpace.GetHeader([]byte(item.Name))
+	if err != nil {
+		t.Fatalf("failed to get header: %v", err)
+	}
+	if last := events[len(events)-1].LSN; header.LSN <= last {
+		t.Fatalf("got LSN %d of a new write, want greater than %d", header.LSN, last)
+	}
+
+	// snapshots would drop the skipped operations
+	if err = db.Snapshot(); !errors.Is(err, kvdb.ErrLazyLoaded) {
+		t.Fatalf("got snapshot error %v, want %v", err, kvdb.ErrLazyLoaded)
+	}
+	if _, err = db.Compact(); !errors.Is(err, kvdb.ErrLazyLoaded) {
+		t.Fatalf("got compact error %v, want %v", err, kvdb.ErrLazyLoaded)
+	}
+	if err = db.Defrag(); !errors.Is(err, kvdb.ErrLazyLoaded) {
+		t.Fatalf("got defrag error %v, want %v", err, kvdb.ErrLazyLoaded)
+	}
+	if err = usersSpace.Compact(usersSpace.View()); !errors.Is(err, kvdb.ErrLazyLoaded) {
+		t.Fatalf("got space compact error %v, want %v", err, kvdb.ErrLazyLoaded)
+	}
+
+	// skipped operations are loaded without lazy load
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if usersSpace, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	for _, item := range append(changed, item) {
+		var ret helpers.TestUser
+		if err = usersSpace.Get([]byte(item.Name), &ret); err != nil || !helpers.Compare(ret, item) {
+			t.Fatalf("got user %+v (%v) after reopen, want %+v", ret, err, item)
+		}
+	}
+}
+
+func TestKVDBDropAllSpaces(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, name := range []string{"users", "orders", "items"} {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		for _, item := range dataGen.Create(100) {
+			if err = space.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set %s: %v", name, err)
+			}
+		}
+	}
+	if err = db.SetMetadata("owner", "Alice"); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+	if err = db.DropAllSpaces(); err != nil {
+		t.Fatalf("failed to drop spaces: %v", err)
+	}
+	if lens := db.SpaceLens(); len(lens) != 0 {
+		t.Fatalf("got spaces %v after drop, want none", lens)
+	}
+	// system spaces are not dropped
+	checkMetadata := func(stage string) {
+		if owner, err := db.GetMetadata("owner"); err != nil || owner != "Alice" {
+			t.Fatalf("got metadata %q (%v) %s, want %q", owner, err, stage, "Alice")
+		}
+	}
+	checkMetadata("after drop")
+
+	// records written after the drop are kept
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	item := dataGen.Create(1)[0]
+	if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lens := db.SpaceLens(); !reflect.DeepEqual(lens, map[string]int{"users": 1}) {
+		t.Fatalf("got spaces %v after restart, want only the user written after drop", lens)
+	}
+	checkMetadata("after restart")
+}
+
+func TestKVDBCurrentLSN(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if lsn := db.LastSnapshotLSN(); lsn != 0 {
+		t.Fatalf("got last snapshot LSN %d without snapshots, want 0", lsn)
+	}
+
+	lsn := db.CurrentLSN()
+	for _, item := range (&helpers.UniqueDataGenerator{}).Create(10) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		if got := db.CurrentLSN(); got != lsn+1 {
+			t.Fatalf("got LSN %d after set, want %d", got, lsn+1)
+		}
+		lsn++
+	}
+
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if got := db.LastSnapshotLSN(); got != lsn {
+		t.Fatalf("got last snapshot LSN %d, want %d", got, lsn)
+	}
+}
+
+func TestKVDBSpaceCompact(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	cleanSpace, err := db.NewSpace("clean")
+	if err != nil {
+		t.Fatalf("failed to create space clean: %v", err)
+	}
+	dirtySpace, err := db.NewSpace("dirty")
+	if err != nil {
+		t.Fatalf("failed to create space dirty: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	clean := dataGen.Create(10)
+	for _, item := range clean {
+		if err = cleanSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set clean: %v", err)
+		}
+	}
+	dirty := dataGen.Create(10)
+	for range 5 {
+		for i := range dirty {
+			dataGen.Change(&dirty[i])
+			if err = dirtySpace.Set([]byte(dirty[i].Name), dirty[i]); err != nil {
+				t.Fatalf("failed to set dirty: %v", err)
+			}
+		}
+	}
+	if stats, err := db.TotalStats(); err != nil || stats.Dead != 40 {
+		t.Fatalf("got stats %+v (%v) before compaction, want 40 dead records", stats, err)
+	}
+
+	if err = cleanSpace.Compact(dirtySpace.View()); err != kvdb.ErrViewNotMatchSpace {
+		t.Fatalf("got error %v compacting with a view of other space, want %v", err, kvdb.ErrViewNotMatchSpace)
+	}
+	if err = dirtySpace.Compact(dirtySpace.View()); err != nil {
+		t.Fatalf("failed to compact space dirty: %v", err)
+	}
+	if stats, err := db.TotalStats(); err != nil || stats.Dead != 0 {
+		t.Fatalf("got stats %+v (%v) after compaction, want no dead records", stats, err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if stats, err := db.TotalStats(); err != nil || stats.Alive != 20 || stats.Dead != 0 {
+		t.Fatalf("got stats %+v (%v) after restart, want 20 alive and no dead records", stats, err)
+	}
+	for name, items := range map[string][]helpers.TestUser{"clean": clean, "dirty": dirty} {
+		space, err := db.ExistingSpace(name)
+		if err != nil {
+			t.Fatalf("failed to get space %s: %v", name, err)
+		}
+		for _, item := range items {
+			var ret helpers.TestUser
+			if err = space.Get([]byte(item.Name), &ret); err != nil {
+				t.Fatalf("failed to get %s: %v", name, err)
+			}
+			if !helpers.Compare(ret, item) {
+				t.Fatalf("got %s %+v, want %+v", name, ret, item)
+			}
+		}
+	}
+}
+
+func TestKVDBSpaceDiff(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	logsSpace, err := db.NewSpace("logs")
+	if err != nil {
+		t.Fatalf("failed to create space logs: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(10)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	snapDir := t.TempDir()
+	if err = db.SnapshotTo(snapDir); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	snaps, err := filepath.Glob(filepath.Join(snapDir, "*.snap"))
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("got snapshots %v (%v), want one", snaps, err)
+	}
+
+	// 10 changes: 3 added, 4 modified, 3 deleted
+	want := kvdb.SpaceDiff{}
+	for _, item := range dataGen.Create(3) {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+		want.Added = append(want.Added, []byte(item.Name))
+	}
+	for i := 0; i < 4; i++ {
+		dataGen.Change(&users[i])
+		if err = usersSpace.Set([]byte(users[i].Name), users[i]); err != nil {
+			t.Fatalf("failed to change user: %v", err)
+		}
+		want.Modified = append(want.Modified, []byte(users[i].Name))
+	}
+	for i := 4; i < 7; i++ {
+		if err = usersSpace.Del([]byte(users[i].Name)); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		want.Deleted = append(want.Deleted, []byte(users[i].Name))
+	}
+	// writes of other spaces do not change the diff
+	if err = logsSpace.Set([]byte("log"), users[0]); err != nil {
+		t.Fatalf("failed to set log: %v", err)
+	}
+	for _, keys := range [][][]byte{want.Added, want.Modified, want.Deleted} {
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	}
+
+	diff, err := db.SpaceDiff("users", snaps[0])
+	if err != nil {
+		t.Fatalf("failed to diff space: %v", err)
+	}
+	if !reflect.DeepEqual(*diff, want) {
+		t.Fatalf("got diff %q, want %q", *diff, want)
+	}
+	if usersSpace.Len() != 10 {
+		t.Fatalf("got %d users after diff, want 10", usersSpace.Len())
+	}
+
+	if _, err = db.SpaceDiff("books", snaps[0]); err != kvdb.ErrSpaceNotFound {
+		t.Fatalf("got error %v for a missing space, want %v", err, kvdb.ErrSpaceNotFound)
+	}
+}
+
+func TestKVDBViewAt(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(50)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	err = db.ViewAt(25, func(f kvdb.GetSpace) error {
+		space := f("users")
+		if space == nil {
+			return kvdb.ErrSpaceNotFound
+		}
+		if space.Len() != 25 {
+			t.Fatalf("got %d users at lsn 25, want 25", space.Len())
+		}
+		for i, item := range users {
+			var ret helpers.TestUser
+			err := space.Get([]byte(item.Name), &ret)
+			if i < 25 && (err != nil || !helpers.Compare(ret, item)) {
+				t.Fatalf("got user %+v (%v), want %+v", ret, err, item)
+			}
+			if i >= 25 && err != kvdb.ErrNotFound {
+				t.Fatalf("got error %v for user written after lsn 25, want %v", err, kvdb.ErrNotFound)
+			}
+		}
+		if err := space.Set([]byte(users[30].Name), users[30]); err != kvdb.ErrReadOnly {
+			t.Fatalf("got error %v setting a past user, want %v", err, kvdb.ErrReadOnly)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to view at lsn 25: %v", err)
+	}
+	if usersSpace.Len() != 50 {
+		t.Fatalf("got %d users after ViewAt, want 50", usersSpace.Len())
+	}
+
+	if err = db.ViewAt(51, func(kvdb.GetSpace) error { return nil }); err != kvdb.ErrLSNNotFound {
+		t.Fatalf("got error %v for unwritten lsn, want %v", err, kvdb.ErrLSNNotFound)
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = db.ViewAt(25, func(kvdb.GetSpace) error { return nil }); err != kvdb.ErrLSNInSnapshot {
+		t.Fatalf("got error %v for lsn before snapshot, want %v", err, kvdb.ErrLSNInSnapshot)
+	}
+}
+
+func TestKVDBSnapshotCompression(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	snapshotSize := func() int64 {
+		matches, err := filepath.Glob(filepath.Join(helpers.DbPath, "*.snap"))
+		if err != nil || len(matches) != 1 {
+			t.Fatalf("got snapshots %v and error %v, want one snapshot", matches, err)
+		}
+		fi, err := os.Stat(matches[0])
+		if err != nil {
+			t.Fatalf("failed to stat snapshot: %v", err)
+		}
+		return fi.Size()
+	}
+
+	db, err := kvdb.Open(helpers.DbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	data := (&helpers.UniqueDataGenerator{}).Create(10000)
+	for _, user := range data[:len(data)-1] {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	plain := snapshotSize()
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	if _, err = kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Compression: -1}); err != kvdb.ErrCompressionUnknown {
+		t.Fatalf("got error %v, want %v", err, kvdb.ErrCompressionUnknown)
+	}
+	db, err = kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Compression: kvdb.CompressionGzip})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if users, err = db.ExistingSpace("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	last := data[len(data)-1]
+	if err = users.Set([]byte(last.Name), last); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if compressed := snapshotSize(); compressed >= plain {
+		t.Fatalf("got compressed snapshot of %d bytes, want less than %d", compressed, plain)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// compressed snapshots are read without the option
+	if db, err = kvdb.Open(helpers.DbPath); err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+	if users, err = db.ExistingSpace("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if users.Len() != len(data) {
+		t.Fatalf("got %d users after reopen, want %d", users.Len(), len(data))
+	}
+	for _, user := range data {
+		var got helpers.TestUser
+		if err = users.Get([]byte(user.Name), &got); err != nil || !helpers.Compare(got, user) {
+			t.Fatalf("got user %v and error %v, want %v", got, err, user)
+		}
+	}
+}
+
+func TestKVDBReopenAfterSnapshotLSN(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	// keys are set in reverse order, so the snapshot is not sorted by LSN
+	for _, name := range []string{"Carol", "Bob", "Alice"} {
+		if err = usersSpace.Set([]byte(name), helpers.TestUser{Name: name}); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lsn := db.CurrentLSN(); lsn != 3 {
+		t.Fatalf("got LSN %d after reopen, want 3 of the snapshot", lsn)
+	}
+	if usersSpace, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if err = usersSpace.Set([]byte("Dave"), helpers.TestUser{Name: "Dave"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	header, err := usersSpace.GetHeader([]byte("Dave"))
+	if err != nil || header.LSN != 4 {
+		t.Fatalf("got header %+v (%v) of a new write, want LSN 4", header, err)
+	}
+}
@@ -1,10 +1,17 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
+	"github.com/ochaton/kvdb"
 	"github.com/ochaton/kvdb/test/helpers"
 )
 
@@ -110,3 +117,405 @@ func TestKVDBLoadingWithLastEmptyFile(t *testing.T) {
 	}
 
 }
+
+func TestKVDBLoadingAfterHardClose(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	users := dataGen.Create(10)
+	for _, item := range users {
+		if err = usersSpace.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	if err := db.HardClose(); err != kvdb.ErrDataLossRisk {
+		t.Fatalf("got %v, want %v", err, kvdb.ErrDataLossRisk)
+	}
+	if err := db.Close(); err != kvdb.ErrClosed {
+		t.Fatalf("got %v, want %v", err, kvdb.ErrClosed)
+	}
+
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if usersSpace, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if _, err = db.ExistingSpace("orders"); !errors.Is(err, kvdb.ErrSpaceNotFound) {
+		t.Fatalf("got %v for missing space, want %v", err, kvdb.ErrSpaceNotFound)
+	}
+	for _, item := range users {
+		ret := helpers.TestUser{}
+		if err := usersSpace.Get([]byte(item.Name), &ret); err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		if !helpers.Compare(item, ret) {
+			t.Fatalf("got %v, want %v", ret, item)
+		}
+	}
+}
+
+func TestKVDBOpenWithLockTimeout(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// concurrent opens of the locked directory must fail by timeout
+	actions := []helpers.Action{}
+	for _, timeout := range []time.Duration{0, 50 * time.Millisecond} {
+		actions = append(actions, func() error {
+			start := time.Now()
+			if _, err := kvdb.OpenWithLockTimeout(helpers.DbPath, timeout); err != kvdb.ErrLockTimeout {
+				return fmt.Errorf("got %v, want %v", err, kvdb.ErrLockTimeout)
+			}
+			if elapsed := time.Since(start); elapsed < timeout {
+				return fmt.Errorf("failed after %v, before timeout %v", elapsed, timeout)
+			}
+			return nil
+		})
+	}
+	if err := helpers.RunInParallel(actions); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	db, err = kvdb.OpenWithLockTimeout(helpers.DbPath, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to open unlocked db: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestKVDBGracefulClose(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err := users.Set([]byte("Alice"), 1); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	// merge runs in the writer goroutine and keeps it busy
+	started := make(chan struct{})
+	updated := make(chan error, 1)
+	go func() {
+		updated <- users.SetOrUpdate([]byte("Alice"), 2, func(existing, incoming any) any {
+			close(started)
+			time.Sleep(time.Second)
+			return incoming
+		})
+	}()
+	<-started
+	// the write waits in the queue behind the merge
+	queued := make(chan error, 1)
+	go func() {
+		queued <- users.Set([]byte("Bob"), 1)
+	}()
+	for db.WriterPending() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = db.GracefulClose(ctx)
+	if !errors.Is(err, kvdb.ErrGracefulCloseTimeout) {
+		t.Fatalf("failed GracefulClose: have error '%v', expected '%v'", err, kvdb.ErrGracefulCloseTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("GracefulClose took %v, expected to stop at the deadline", elapsed)
+	}
+	if err := db.GracefulClose(context.Background()); !errors.Is(err, kvdb.ErrClosed) {
+		t.Fatalf("failed GracefulClose: have error '%v', expected '%v'", err, kvdb.ErrClosed)
+	}
+	// the directory stays locked while the writer is busy
+	if _, err := kvdb.OpenWithLockTimeout(helpers.DbPath, 0); err != kvdb.ErrLockTimeout {
+		t.Fatalf("failed OpenWithLockTimeout: have error '%v', expected '%v'", err, kvdb.ErrLockTimeout)
+	}
+	// the busy task finishes with the open file, the queued one is rejected
+	if err := <-updated; err != nil {
+		t.Fatalf("failed SetOrUpdate with error: %v", err)
+	}
+	if err := <-queued; !errors.Is(err, kvdb.ErrWriterInvalidStatus) {
+		t.Fatalf("failed queued Set: have error '%v', expected '%v'", err, kvdb.ErrWriterInvalidStatus)
+	}
+	// and is unlocked once the writer has exited
+	var reopened *kvdb.T
+	for {
+		if reopened, err = kvdb.OpenWithLockTimeout(helpers.DbPath, 0); err != kvdb.ErrLockTimeout {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed OpenWithLockTimeout with error: %v", err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatalf("failed Close with error: %v", err)
+	}
+
+	// idle database is closed without timeout
+	db, err = helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := db.GracefulClose(context.Background()); err != nil {
+		t.Fatalf("failed GracefulClose with error: %v", err)
+	}
+}
+
+func TestKVDBSetSyncMode(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{SyncMode: kvdb.SyncFull})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	dataGen := &helpers.UniqueDataGenerator{}
+	elapsed := map[kvdb.SyncMode]time.Duration{}
+	for _, mode := range []kvdb.SyncMode{kvdb.SyncFull, kvdb.SyncNone, kvdb.SyncAll, kvdb.SyncNone} {
+		if err := db.SetSyncMode(mode); err != nil {
+			t.Fatalf("failed to set sync mode %d: %v", mode, err)
+		}
+		start := time.Now()
+		for _, item := range dataGen.Create(100) {
+			if err := users.Set([]byte(item.Name), item); err != nil {
+				t.Fatalf("failed to set user: %v", err)
+			}
+		}
+		elapsed[mode] += time.Since(start)
+	}
+	t.Logf("100 writes: SyncNone %v, SyncFull %v, SyncAll %v",
+		elapsed[kvdb.SyncNone]/2, elapsed[kvdb.SyncFull], elapsed[kvdb.SyncAll])
+
+	if err := db.SetSyncMode(kvdb.SyncMode(42)); !errors.Is(err, kvdb.ErrSyncModeUnknown) {
+		t.Fatalf("failed SetSyncMode: have error '%v', expected '%v'", err, kvdb.ErrSyncModeUnknown)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if lens := db.SpaceLens(); lens["users"] != 400 {
+		t.Fatalf("got %d users after reopen, want %d", lens["users"], 400)
+	}
+}
+
+func TestKVDBOpenFiles(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err := users.Set([]byte("Alice"), 1); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	files, err := db.OpenFiles()
+	if err != nil {
+		t.Fatalf("failed to get open files: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got open files %v, want the current jlog", files)
+	}
+	if filepath.Ext(files[0].Path) != ".jlog" || files[0].Mode != "rw" || files[0].SizeBytes <= 0 {
+		t.Fatalf("got open file %+v, want non-empty jlog opened rw", files[0])
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if files, err = db.OpenFiles(); err != nil || len(files) != 0 {
+		t.Fatalf("got open files %v (%v) after close, want none", files, err)
+	}
+}
+
+func TestKVDBPauseWrites(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{MaxPauseDuration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err := users.Set([]byte("Alice"), helpers.TestUser{Name: "Alice", Age: 30}); err != nil {
+		t.Fatalf("failed to set user before pause: %v", err)
+	}
+
+	setAsync := func(name string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- users.Set([]byte(name), helpers.TestUser{Name: name})
+		}()
+		return done
+	}
+
+	resume, err := db.PauseWrites()
+	if err != nil {
+		t.Fatalf("failed to pause writes: %v", err)
+	}
+	done := setAsync("Bob")
+	select {
+	case err := <-done:
+		t.Fatalf("write returned while paused: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	resume()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to set user after resume: %v", err)
+	}
+	resume()
+
+	// the pause ends by itself after MaxPauseDuration
+	if _, err = db.PauseWrites(); err != nil {
+		t.Fatalf("failed to pause writes: %v", err)
+	}
+	start := time.Now()
+	if err := <-setAsync("Eve"); err != nil {
+		t.Fatalf("failed to set user after auto resume: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("write returned after %v, want to wait for auto resume", elapsed)
+	}
+	if n := users.Len(); n != 3 {
+		t.Fatalf("got %d users, want 3", n)
+	}
+}
+
+func TestKVDBWriterPending(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if n := db.WriterPending(); n != 0 {
+		t.Fatalf("got %d pending tasks, want 0", n)
+	}
+
+	// writes are queued while the writer is paused
+	resume, err := db.PauseWrites()
+	if err != nil {
+		t.Fatalf("failed to pause writes: %v", err)
+	}
+	done := make(chan error, 3)
+	for i := range 3 {
+		go func() {
+			name := fmt.Sprintf("user-%d", i)
+			done <- users.Set([]byte(name), helpers.TestUser{Name: name})
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for db.WriterPending() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d pending tasks, want 3", db.WriterPending())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats, err := db.Stats(); err != nil || stats.WriterPending != 3 {
+		t.Fatalf("got %d pending tasks in stats (%v), want 3", stats.WriterPending, err)
+	}
+
+	resume()
+	for range 3 {
+		if err := <-done; err != nil {
+			t.Fatalf("failed to set user after resume: %v", err)
+		}
+	}
+	if n := db.WriterPending(); n != 0 {
+		t.Fatalf("got %d pending tasks after resume, want 0", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if n := db.WriterPending(); n != 0 {
+		t.Fatalf("got %d pending tasks after close, want 0", n)
+	}
+}
+
+func TestKVDBRegisterSpace(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	usersSpace, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	keys := []string{"a", "c", "e", "b", "d"}
+	for _, key := range keys {
+		if err = usersSpace.Set([]byte(key), helpers.TestUser{Name: key}); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	if db, err = kvdb.New(helpers.DbPath, kvdb.Options{}); err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	if err = db.RegisterSpace("users", kvdb.SpaceOptions{Comparator: reverse}); err != nil {
+		t.Fatalf("failed to register space users: %v", err)
+	}
+	if err = db.Load(); err != nil {
+		t.Fatalf("failed to load db: %v", err)
+	}
+	if err = db.RegisterSpace("orders", kvdb.SpaceOptions{}); err != kvdb.ErrAlreadyLoaded {
+		t.Fatalf("got error %v registering space after load, want %v", err, kvdb.ErrAlreadyLoaded)
+	}
+
+	if usersSpace, err = db.ExistingSpace("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	iter := usersSpace.Iter()
+	defer iter.Release()
+	got := []string{}
+	for iter.HasNext() {
+		var user helpers.TestUser
+		if err = iter.Next(&user); err != nil {
+			t.Fatalf("failed to iterate users: %v", err)
+		}
+		got = append(got, user.Name)
+	}
+	if want := []string{"e", "d", "c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got users %v, want %v", got, want)
+	}
+}
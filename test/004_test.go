@@ -0,0 +1,256 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBNewSpaceFrom(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// create JSON Lines file with LSNs not starting from 1
+	count := 10000
+	fh, err := os.CreateTemp(t.TempDir(), "import-*.jsonl")
+	if err != nil {
+		t.Fatalf("failed to create import file: %v", err)
+	}
+	enc := json.NewEncoder(fh)
+	for i := range count {
+		user := helpers.TestUser{Name: fmt.Sprintf("Alice-%d", i), Age: i}
+		line := map[string]any{
+			"lsn":    1000 + i,
+			"op":     "set",
+			"time":   1750280676,
+			"record": map[string]any{"tag": "users", "key": user.Name, "value": user},
+		}
+		if err := enc.Encode(line); err != nil {
+			t.Fatalf("failed to write import file: %v", err)
+		}
+	}
+	if _, err := fh.Seek(0, 0); err != nil {
+		t.Fatalf("failed to seek import file: %v", err)
+	}
+	defer fh.Close()
+
+	progress := 0
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{
+		ProgressCallback: func(lsn uint64) { progress++ },
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	startLSN := db.CurrentLSN()
+	if err := db.NewSpaceFrom("users", fh); err != nil {
+		t.Fatalf("failed to import space users: %v", err)
+	}
+	if progress != count {
+		t.Fatalf("got %d progress calls, want %d", progress, count)
+	}
+
+	// LSNs of the stream are replaced by LSNs of the log
+	if lsn := db.CurrentLSN(); lsn != startLSN+uint64(count) {
+		t.Fatalf("got current LSN %d after import, want %d", lsn, startLSN+uint64(count))
+	}
+	imported, err := db.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	for i := range count {
+		name := fmt.Sprintf("Alice-%d", i)
+		header, err := imported.GetHeader([]byte(name))
+		if err != nil {
+			t.Fatalf("failed to get header of %s: %v", name, err)
+		}
+		if header.LSN != startLSN+uint64(i)+1 {
+			t.Fatalf("got LSN %d of %s, want %d", header.LSN, name, startLSN+uint64(i)+1)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	// check that all records survive restart
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	users, err := db.Space("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if users.Len() != count {
+		t.Fatalf("got %d users, want %d", users.Len(), count)
+	}
+	for i := range count {
+		var ret helpers.TestUser
+		name := fmt.Sprintf("Alice-%d", i)
+		if err := users.Get([]byte(name), &ret); err != nil {
+			t.Fatalf("failed to get user %s: %v", name, err)
+		}
+		if ret.Age != i {
+			t.Fatalf("got %v, want age %d", ret, i)
+		}
+	}
+}
+
+func TestKVDBExportSpace(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	expected := []helpers.TestUser{
+		{Name: "Alice", Age: 30},
+		{Name: `Bob "the builder", Jr.`, Age: 28},
+		{Name: "Carol\nSmith", Age: 41},
+	}
+	for _, user := range expected {
+		if err := users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	// JSON Lines export can be imported back
+	var jsonl bytes.Buffer
+	if err := db.ExportSpace("users", &jsonl, kvdb.ExportFormatJSONLines); err != nil {
+		t.Fatalf("failed to export users: %v", err)
+	}
+	if err := db.NewSpaceFrom("users_copy", &jsonl); err != nil {
+		t.Fatalf("failed to import users: %v", err)
+	}
+	usersCopy, err := db.Space("users_copy")
+	if err != nil {
+		t.Fatalf("failed to get space users_copy: %v", err)
+	}
+	var original, imported []helpers.TestUser
+	if err := users.List(&original); err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if err := usersCopy.List(&imported); err != nil {
+		t.Fatalf("failed to list imported users: %v", err)
+	}
+	if !reflect.DeepEqual(imported, original) {
+		t.Fatalf("got imported users %v, want %v", imported, original)
+	}
+
+	// CSV export has header row and quotes special characters
+	var out bytes.Buffer
+	if err := db.ExportSpace("users", &out, kvdb.ExportFormatCSV); err != nil {
+		t.Fatalf("failed to export users: %v", err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read csv: %v", err)
+	}
+	if !reflect.DeepEqual(rows[0], []string{"key", "name", "age"}) {
+		t.Fatalf("got csv header %v, want %v", rows[0], []string{"key", "name", "age"})
+	}
+	if len(rows) != len(expected)+1 {
+		t.Fatalf("got %d csv rows, want %d", len(rows), len(expected)+1)
+	}
+	for _, row := range rows[1:] {
+		var user helpers.TestUser
+		if err := users.Get([]byte(row[0]), &user); err != nil {
+			t.Fatalf("failed to get user %q: %v", row[0], err)
+		}
+		if row[1] != user.Name || row[2] != fmt.Sprint(user.Age) {
+			t.Fatalf("got csv row %q, want %+v", row, user)
+		}
+	}
+
+	// values with different fields can not be exported as CSV
+	if err := users.Set([]byte("Dave"), map[string]any{"nickname": "dave"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := db.ExportSpace("users", io.Discard, kvdb.ExportFormatCSV); !errors.Is(err, kvdb.ErrExportFormatMismatch) {
+		t.Fatalf("got export error '%v', want '%v'", err, kvdb.ErrExportFormatMismatch)
+	}
+	if err := db.ExportSpace("orders", io.Discard, kvdb.ExportFormatCSV); !errors.Is(err, kvdb.ErrSpaceNotFound) {
+		t.Fatalf("got export error '%v', want '%v'", err, kvdb.ErrSpaceNotFound)
+	}
+}
+
+func TestKVDBFreezeSpace(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	for _, item := range dataGen.Create(100) {
+		if err := users.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err := users.Set([]byte{0x00, 0xff}, helpers.TestUser{Name: "binary"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	frozen, err := users.Freeze()
+	if err != nil {
+		t.Fatalf("failed to freeze users: %v", err)
+	}
+	data, err := json.Marshal(frozen)
+	if err != nil {
+		t.Fatalf("failed to marshal frozen space: %v", err)
+	}
+	var thawed kvdb.FrozenSpace
+	if err := json.Unmarshal(data, &thawed); err != nil {
+		t.Fatalf("failed to unmarshal frozen space: %v", err)
+	}
+
+	// frozen space is applied to another database
+	otherPath := t.TempDir()
+	other, err := kvdb.Open(otherPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := other.UnfreezeSpace("customers", thawed); err != nil {
+		t.Fatalf("failed to unfreeze space: %v", err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	other, err = kvdb.Open(otherPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer other.Close()
+
+	customers, err := other.Space("customers")
+	if err != nil {
+		t.Fatalf("failed to get space customers: %v", err)
+	}
+	var expected, got []helpers.TestUser
+	if err := users.List(&expected); err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if err := customers.List(&got); err != nil {
+		t.Fatalf("failed to list customers: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got unfrozen users %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+type TimedUser struct {
+	Header kvdb.Header `json:"-"`
+	Name   string      `json:"name"`
+	Age    int         `json:"age"`
+}
+
+func TestKVDBSetWithTimestamp(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	// timestamps span 10 years
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	step := 10 * 365 * 24 * time.Hour / 100
+	timestamps := map[string]time.Time{}
+	for i := range 100 {
+		user := TimedUser{Name: fmt.Sprintf("Alice-%d", i), Age: i}
+		ts := start.Add(time.Duration(i) * step)
+		timestamps[user.Name] = ts
+		if err := users.SetWithTimestamp([]byte(user.Name), user, ts); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	check := func(stage string) {
+		for name, ts := range timestamps {
+			var ret TimedUser
+			if err := users.Get([]byte(name), &ret); err != nil {
+				t.Fatalf("%s: failed to get user: %v", stage, err)
+			}
+			if ret.Header.Time != ts.Unix() {
+				t.Fatalf("%s: got time %d, want %d", stage, ret.Header.Time, ts.Unix())
+			}
+		}
+	}
+	check("before restart")
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	check("after restart")
+}
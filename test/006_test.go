@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBAtomic(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	alice := helpers.TestUser{Name: "Alice", Age: 30}
+	bob := helpers.TestUser{Name: "Bob", Age: 28}
+
+	// committed batch writes to both spaces
+	err = db.Atomic(func(b *kvdb.AtomicBatch) error {
+		if err := b.Set("users", []byte(alice.Name), alice); err != nil {
+			return err
+		}
+		return b.Set("orders", []byte("order-1"), alice)
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	// aborted batch writes nothing
+	errAbort := errors.New("abort")
+	err = db.Atomic(func(b *kvdb.AtomicBatch) error {
+		if err := b.Set("users", []byte(bob.Name), bob); err != nil {
+			return err
+		}
+		if err := b.Del("orders", []byte("order-1")); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("got %v, want %v", err, errAbort)
+	}
+
+	check := func(db *kvdb.T, stage string) {
+		var ret helpers.TestUser
+		users, err := db.Space("users")
+		if err != nil {
+			t.Fatalf("%s: failed to get space users: %v", stage, err)
+		}
+		orders, err := db.Space("orders")
+		if err != nil {
+			t.Fatalf("%s: failed to get space orders: %v", stage, err)
+		}
+		if err := users.Get([]byte(alice.Name), &ret); err != nil || !helpers.Compare(ret, alice) {
+			t.Fatalf("%s: got %v (%v), want %v", stage, ret, err, alice)
+		}
+		if err := orders.Get([]byte("order-1"), &ret); err != nil || !helpers.Compare(ret, alice) {
+			t.Fatalf("%s: got %v (%v), want %v", stage, ret, err, alice)
+		}
+		if err := users.Get([]byte(bob.Name), &ret); err != kvdb.ErrNotFound {
+			t.Fatalf("%s: got %v, want %v", stage, err, kvdb.ErrNotFound)
+		}
+	}
+	check(db, "before restart")
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	check(db, "after restart")
+}
+
+func TestKVDBAtomicWithEncoder(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	db, err := kvdb.New(helpers.DbPath, kvdb.Options{})
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	opts := kvdb.SpaceOptions{
+		Encoder: func(v any) ([]byte, error) {
+			return v.([]byte), nil
+		},
+		Decoder: func(data []byte, v any) error {
+			*v.(*[]byte) = bytes.Clone(data)
+			return nil
+		},
+		DefaultTTL: 200 * time.Millisecond,
+	}
+	if err = db.RegisterSpace("blobs", opts); err != nil {
+		t.Fatalf("failed to register space blobs: %v", err)
+	}
+	if err = db.Load(); err != nil {
+		t.Fatalf("failed to load db: %v", err)
+	}
+
+	blob := []byte{0x00, 0xff, '"', '\\', '\n'}
+	err = db.Atomic(func(b *kvdb.AtomicBatch) error {
+		return b.Set("blobs", []byte("blob"), blob)
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	blobs, err := db.ExistingSpace("blobs")
+	if err != nil {
+		t.Fatalf("failed to get space blobs: %v", err)
+	}
+	var got []byte
+	if err := blobs.Get([]byte("blob"), &got); err != nil {
+		t.Fatalf("failed to get blob: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("failed result check: blob: %v, expected %v", got, blob)
+	}
+
+	// DefaultTTL of the space applies to records set by a batch
+	time.Sleep(300 * time.Millisecond)
+	if err := blobs.Get([]byte("blob"), &got); err != kvdb.ErrNotFound {
+		t.Fatalf("got %v for expired blob, want %v", err, kvdb.ErrNotFound)
+	}
+}
@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBMapValues(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	gen := helpers.UniqueDataGenerator{}
+	space, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space: %v", err)
+	}
+	for _, user := range gen.Create(100) {
+		if err := space.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	stripAge := func(raw []byte) ([]byte, error) {
+		var v map[string]any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		delete(v, "age")
+		return json.Marshal(v)
+	}
+
+	iter := space.Iter()
+	mIter := iter.MapValues(stripAge)
+	count := 0
+	for mIter.HasNext() {
+		var user helpers.TestUser
+		if err := mIter.Next(&user); err != nil {
+			t.Fatalf("failed iter.Next with error: %v", err)
+		}
+		if user.Name == "" || user.Age != 0 {
+			t.Fatalf("failed result check: user: %+v, expected age to be stripped", user)
+		}
+		count++
+	}
+	mIter.Release()
+
+	if count != 100 {
+		t.Fatalf("failed result check: iterated %d users, expected %d", count, 100)
+	}
+
+	// stored values are not modified
+	var user helpers.TestUser
+	if err := space.Get([]byte("Alice-1"), &user); err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if user.Age != 1 {
+		t.Fatalf("failed result check: age: %d, expected %d", user.Age, 1)
+	}
+}
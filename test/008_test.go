@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBOptionsSpaces(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	data := map[string]string{
+		"0000000001.jlog": `
+{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-1","value":{"name":"Alice-1","age":1}}}
+`,
+	}
+	if err := helpers.SetupDataFiles(helpers.DbPath, data); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// data files contain a space which is not defined
+	opts := kvdb.Options{Spaces: []kvdb.SpaceDefinition{{Name: "customers"}}}
+	if _, err := kvdb.OpenWithOptions(helpers.DbPath, opts); !errors.Is(err, kvdb.ErrUnexpectedSpace) {
+		t.Fatalf("failed open: have error '%v', expected '%v'", err, kvdb.ErrUnexpectedSpace)
+	}
+
+	// defined spaces are created on open and ordered by comparator
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	opts = kvdb.Options{Spaces: []kvdb.SpaceDefinition{{Name: "users", Comparator: reverse}, {Name: "customers"}}}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	customers, err := db.Space("customers")
+	if err != nil {
+		t.Fatalf("failed to get space customers: space: %v, error: %v", customers, err)
+	}
+
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if err := users.Set([]byte("Alice-2"), helpers.TestUser{Name: "Alice-2", Age: 2}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	iter := users.Iter()
+	var user helpers.TestUser
+	err = iter.Next(&user)
+	iter.Release()
+	if err != nil {
+		t.Fatalf("failed iter.Next with error: %v", err)
+	}
+	if user.Name != "Alice-2" {
+		t.Fatalf("failed result check: first: %s, expected %s", user.Name, "Alice-2")
+	}
+
+	if _, err := db.NewSpace("orders"); !errors.Is(err, kvdb.ErrUnexpectedSpace) {
+		t.Fatalf("failed NewSpace: have error '%v', expected '%v'", err, kvdb.ErrUnexpectedSpace)
+	}
+}
@@ -0,0 +1,56 @@
+package main_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBMSet(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	alice := helpers.TestUser{Name: "Alice", Age: 30}
+	bob := helpers.TestUser{Name: "Bob", Age: 28}
+	if err := users.MSet([]byte(alice.Name), alice, bob.Name, bob); err != nil {
+		t.Fatalf("failed to mset users: %v", err)
+	}
+	if err := users.MSet([]byte("Carol"), helpers.TestUser{}, []byte("Dave")); !errors.Is(err, kvdb.ErrMSetOddArgs) {
+		t.Fatalf("failed mset: have error '%v', expected '%v'", err, kvdb.ErrMSetOddArgs)
+	}
+	if users.Len() != 2 {
+		t.Fatalf("failed result check: len: %d, expected %d", users.Len(), 2)
+	}
+
+	// values are persisted
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err = db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	for _, expected := range []helpers.TestUser{alice, bob} {
+		var user helpers.TestUser
+		if err := users.Get([]byte(expected.Name), &user); err != nil {
+			t.Fatalf("failed to get user %s: %v", expected.Name, err)
+		}
+		if user != expected {
+			t.Fatalf("failed result check: user: %+v, expected %+v", user, expected)
+		}
+	}
+}
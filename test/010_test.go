@@ -0,0 +1,69 @@
+package main_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBNewSpaceWithEncoder(t *testing.T) {
+	enc := func(v any) ([]byte, error) {
+		return v.([]byte), nil
+	}
+	dec := func(data []byte, v any) error {
+		*v.(*[]byte) = bytes.Clone(data)
+		return nil
+	}
+
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	blobs, err := db.NewSpaceWithEncoder("blobs", enc, dec)
+	if err != nil {
+		t.Fatalf("failed to create space blobs: %v", err)
+	}
+
+	blob := []byte{0x00, 0xff, '"', '\\', '\n', '<', '>', '&', 0x7f, 0x80}
+	if err := blobs.Set([]byte("blob"), blob); err != nil {
+		t.Fatalf("failed to set blob: %v", err)
+	}
+	var got []byte
+	if err := blobs.Get([]byte("blob"), &got); err != nil {
+		t.Fatalf("failed to get blob: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("failed result check: blob: %v, expected %v", got, blob)
+	}
+
+	// blob is decoded the same way after reload
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	blobs, err = db.NewSpaceWithEncoder("blobs", enc, dec)
+	if err != nil {
+		t.Fatalf("failed to create space blobs: %v", err)
+	}
+
+	got = nil
+	if err := blobs.Get([]byte("blob"), &got); err != nil {
+		t.Fatalf("failed to get blob: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("failed result check: blob after reload: %v, expected %v", got, blob)
+	}
+
+	iter := blobs.Iter()
+	got = nil
+	err = iter.Next(&got)
+	iter.Release()
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("failed iter.Next: blob: %v, error: %v, expected %v", got, err, blob)
+	}
+}
@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+type writeEvents struct {
+	mu     sync.Mutex
+	events []kvdb.WriteEvent
+}
+
+func (we *writeEvents) add(ev kvdb.WriteEvent) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+	we.events = append(we.events, ev)
+}
+
+func (we *writeEvents) take() []kvdb.WriteEvent {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+	events := we.events
+	we.events = nil
+	return events
+}
+
+func TestKVDBSetWithMeta(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	events := &writeEvents{}
+	opts := kvdb.Options{OnWrite: events.add}
+
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	meta := map[string]string{"user": "admin", "trace": "42"}
+	if err := users.SetWithMeta([]byte("Alice"), helpers.TestUser{Name: "Alice", Age: 30}, meta); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := users.Del([]byte("Alice")); err != nil {
+		t.Fatalf("failed to del user: %v", err)
+	}
+	if err := users.SetWithMeta([]byte("Bob"), helpers.TestUser{Name: "Bob", Age: 28}, meta); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	written := events.take()
+	if len(written) != 3 {
+		t.Fatalf("got %d write events, want 3", len(written))
+	}
+	if ev := written[0]; ev.Op != "set" || ev.Space != "users" || string(ev.Key) != "Alice" || !reflect.DeepEqual(ev.Meta, meta) || ev.Replay {
+		t.Fatalf("got write event %+v, want set of Alice with meta %v", ev, meta)
+	}
+	if ev := written[1]; ev.Op != "del" || ev.Meta != nil {
+		t.Fatalf("got write event %+v, want del without meta", ev)
+	}
+
+	// metadata is replayed from jlog and from snapshot
+	for _, compact := range []bool{false, true} {
+		if compact {
+			if _, err := db.Compact(); err != nil {
+				t.Fatalf("failed to compact: %v", err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+		events.take()
+
+		db, err = kvdb.OpenWithOptions(helpers.DbPath, opts)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		var bob []kvdb.WriteEvent
+		for _, ev := range events.take() {
+			if string(ev.Key) == "Bob" {
+				bob = append(bob, ev)
+			}
+		}
+		if len(bob) != 1 || !bob[0].Replay || !reflect.DeepEqual(bob[0].Meta, meta) {
+			t.Fatalf("got replayed events %+v, want one Bob set with meta %v", bob, meta)
+		}
+	}
+	db.Close()
+}
@@ -0,0 +1,141 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBSpaceWatch(t *testing.T) {
+	helpers.CleanDB(helpers.DbPath)
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{WatchBufSize: 3})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	orders, err := db.NewSpace("orders")
+	if err != nil {
+		t.Fatalf("failed to create space orders: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := users.Watch(ctx)
+	if err != nil {
+		t.Fatalf("failed to watch users: %v", err)
+	}
+	other, err := users.Watch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to watch users: %v", err)
+	}
+
+	alice := helpers.TestUser{Name: "Alice", Age: 30}
+	if err := users.Set([]byte("Alice"), alice); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := orders.Set([]byte("order-1"), "Alice"); err != nil {
+		t.Fatalf("failed to set order: %v", err)
+	}
+	if err := users.Del([]byte("Alice")); err != nil {
+		t.Fatalf("failed to del user: %v", err)
+	}
+
+	set := <-watcher.Events()
+	var user helpers.TestUser
+	if err := json.Unmarshal(set.RawValue, &user); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if set.Op != kvdb.OPERATION_SET || string(set.Key) != "Alice" || user != alice {
+		t.Fatalf("got change %+v, want set of Alice", set)
+	}
+	del := <-watcher.Events()
+	if del.Op != kvdb.OPERATION_DEL || string(del.Key) != "Alice" || del.RawValue != nil || del.LSN <= set.LSN {
+		t.Fatalf("got change %+v, want del of Alice after LSN %d", del, set.LSN)
+	}
+	select {
+	case change := <-watcher.Events():
+		t.Fatalf("got unexpected change %+v", change)
+	default:
+	}
+
+	// every watcher gets its own copy of changes
+	for _, want := range []kvdb.SpaceEvent{set, del} {
+		ev := <-other.Events()
+		if ev.Op != want.Op || ev.LSN != want.LSN || string(ev.Key) != string(want.Key) {
+			t.Fatalf("got change %+v from the other watcher, want %+v", ev, want)
+		}
+	}
+	other.Stop()
+	other.Stop()
+	if _, ok := <-other.Events(); ok {
+		t.Fatalf("got change from the stopped watcher")
+	}
+
+	// slow consumer drops the oldest events
+	for i := range 5 {
+		if err := users.Set([]byte("Bob"), i); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if watcher.Dropped() != 2 {
+		t.Fatalf("got %d dropped events, want %d", watcher.Dropped(), 2)
+	}
+	for want := 2; want < 5; want++ {
+		ev := <-watcher.Events()
+		if string(ev.RawValue) != strconv.Itoa(want) {
+			t.Fatalf("got value %s, want %d", ev.RawValue, want)
+		}
+	}
+
+	// cancelled watcher closes the channel
+	cancel()
+	deadline := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-watcher.Events():
+			closed = !ok
+		case <-deadline:
+			t.Fatalf("watcher channel is not closed after cancel")
+		}
+	}
+	if err := users.Set([]byte("Carol"), 1); err != nil {
+		t.Fatalf("failed to set user after watcher stop: %v", err)
+	}
+}
+
+func TestKVDBSpaceWatchClosed(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	view := users.View()
+	if _, err = view.Watch(context.Background()); err != kvdb.ErrReadOnly {
+		t.Fatalf("got error %v watching a view, want %v", err, kvdb.ErrReadOnly)
+	}
+	watcher, err := users.Watch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to watch users: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if _, ok := <-watcher.Events(); ok {
+		t.Fatalf("got change after close")
+	}
+	if _, err = users.Watch(context.Background()); err != kvdb.ErrClosed {
+		t.Fatalf("got error %v watching a closed db, want %v", err, kvdb.ErrClosed)
+	}
+}
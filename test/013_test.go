@@ -0,0 +1,166 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBNewSpaceWithTTL(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{TTLReapInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	sessions, err := db.NewSpaceWithTTL("sessions", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to create space sessions: %v", err)
+	}
+	if err := sessions.Set([]byte("Alice"), helpers.TestUser{Name: "Alice", Age: 30}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := sessions.SetWithTTL([]byte("Bob"), helpers.TestUser{Name: "Bob", Age: 28}, time.Hour); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	var user helpers.TestUser
+	if err := sessions.Get([]byte("Alice"), &user); err != nil {
+		t.Fatalf("failed to get user before expiration: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if err := sessions.Get([]byte("Alice"), &user); err != kvdb.ErrNotFound {
+		t.Fatalf("got error %v for expired user, want %v", err, kvdb.ErrNotFound)
+	}
+	if err := sessions.Get([]byte("Bob"), &user); err != nil || user.Name != "Bob" {
+		t.Fatalf("failed to get user with explicit TTL: %+v, %v", user, err)
+	}
+	if n := sessions.Len(); n != 1 {
+		t.Fatalf("got %d records after reaping, want 1", n)
+	}
+}
+
+func TestKVDBSetWithTTLReopen(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err := users.SetWithTTL([]byte("Alice"), helpers.TestUser{Name: "Alice", Age: 30}, 50*time.Millisecond); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := users.Set([]byte("Bob"), helpers.TestUser{Name: "Bob", Age: 28}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+	users, err = db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+
+	var user helpers.TestUser
+	if err := users.Get([]byte("Alice"), &user); err != kvdb.ErrNotFound {
+		t.Fatalf("got error %v for expired user after reopen, want %v", err, kvdb.ErrNotFound)
+	}
+	if err := users.Get([]byte("Bob"), &user); err != nil {
+		t.Fatalf("failed to get user without TTL after reopen: %v", err)
+	}
+}
+
+func TestKVDBCloseStopsReaper(t *testing.T) {
+	closers := map[string]func(db *kvdb.T) error{
+		"Close": func(db *kvdb.T) error { return db.Close() },
+		"GracefulClose": func(db *kvdb.T) error {
+			return db.GracefulClose(context.Background())
+		},
+		"HardClose": func(db *kvdb.T) error {
+			if err := db.HardClose(); !errors.Is(err, kvdb.ErrDataLossRisk) {
+				return err
+			}
+			return nil
+		},
+	}
+	for name, closeDB := range closers {
+		if err := helpers.CleanDB(helpers.DbPath); err != nil {
+			t.Fatalf("%v", err)
+		}
+		goroutines := runtime.NumGoroutine()
+		db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{TTLReapInterval: time.Millisecond})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if err := closeDB(db); err != nil {
+			t.Fatalf("failed to %s db: %v", name, err)
+		}
+		// the writer and reapLoop goroutines have exited
+		if n := runtime.NumGoroutine(); n > goroutines {
+			t.Fatalf("got %d goroutines after %s, want at most %d", n, name, goroutines)
+		}
+	}
+}
+
+func TestKVDBReapWhilePaused(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{TTLReapInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err := users.SetWithTTL([]byte("Alice"), 1, time.Millisecond); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	resume, err := db.PauseWrites()
+	if err != nil {
+		t.Fatalf("failed to pause writes: %v", err)
+	}
+	defer resume()
+	// the reaper waits for the writer without holding the database lock
+	time.Sleep(10 * time.Millisecond)
+	created := make(chan error, 1)
+	go func() {
+		_, err := db.NewSpace("orders")
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		if err != nil {
+			t.Fatalf("failed to create space orders: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed to create space orders: blocked by the reaper")
+	}
+	if n := users.Len(); n != 0 {
+		t.Fatalf("failed result check: len: %d, expected: 0", n)
+	}
+}
@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+type testBook struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestKVDBNewSpaceOrExisting(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpaceOrExisting("users", helpers.TestUser{})
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	if err = users.Set([]byte("Alice"), helpers.TestUser{Name: "Alice", Age: 30}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	// spaces created without schema accept any schema
+	books, err := db.NewSpace("books")
+	if err != nil {
+		t.Fatalf("failed to create space books: %v", err)
+	}
+	if err = books.Set([]byte("Go"), testBook{Name: "Go", Count: 1}); err != nil {
+		t.Fatalf("failed to set book: %v", err)
+	}
+	if _, err = db.NewSpaceOrExisting("books", &testBook{}); err != nil {
+		t.Fatalf("failed to get space books with schema: %v", err)
+	}
+	if _, err = db.NewSpaceOrExisting("users", testBook{}); err != kvdb.ErrSchemaMismatch {
+		t.Fatalf("got error %v for another schema, want %v", err, kvdb.ErrSchemaMismatch)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	db, err = helpers.SetupDB(helpers.DbPath, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if _, err = db.NewSpaceOrExisting("users", &helpers.TestUser{}); err != nil {
+		t.Fatalf("failed to get space users after restart: %v", err)
+	}
+	if _, err = db.NewSpaceOrExisting("users", testBook{}); err != kvdb.ErrSchemaMismatch {
+		t.Fatalf("got error %v for another schema after restart, want %v", err, kvdb.ErrSchemaMismatch)
+	}
+	if _, err = db.NewSpaceOrExisting("books", helpers.TestUser{}); err != kvdb.ErrSchemaMismatch {
+		t.Fatalf("got error %v for another books schema after restart, want %v", err, kvdb.ErrSchemaMismatch)
+	}
+	if _, err = db.NewSpaceOrExisting("users", nil); err != nil {
+		t.Fatalf("failed to get space users without schema: %v", err)
+	}
+	if lens := db.SpaceLens(); len(lens) != 2 || lens["users"] != 1 || lens["books"] != 1 {
+		t.Fatalf("got space lens %v, want users and books only", lens)
+	}
+}
@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+// getJSON requests url and decodes JSON response into into
+func getJSON(t *testing.T, method, url string, into any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to request %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if into != nil {
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Fatalf("got content type %q from %s, want application/json", ct, url)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+			t.Fatalf("failed to decode response of %s: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestKVDBHandler(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	for i := range 5 {
+		name := fmt.Sprintf("Alice-%d", i)
+		if err = users.Set([]byte(name), helpers.TestUser{Name: name, Age: i}); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = users.Set([]byte("Bob/1"), helpers.TestUser{Name: "Bob", Age: 28}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if _, err = db.NewSpace("books"); err != nil {
+		t.Fatalf("failed to create space books: %v", err)
+	}
+
+	srv := httptest.NewServer(db.Handler())
+	defer srv.Close()
+
+	var spaces []kvdb.HTTPSpaceInfo
+	if code := getJSON(t, "GET", srv.URL+"/spaces", &spaces); code != http.StatusOK {
+		t.Fatalf("got status %d for /spaces, want %d", code, http.StatusOK)
+	}
+	if len(spaces) != 2 || spaces[0].Name != "books" || spaces[1].Name != "users" || spaces[1].Len != 6 || spaces[1].LenBytes == 0 {
+		t.Fatalf("got spaces %+v, want books and users", spaces)
+	}
+
+	var page kvdb.HTTPKeysPage
+	if code := getJSON(t, "GET", srv.URL+"/spaces/users/keys?prefix=Alice&limit=3", &page); code != http.StatusOK {
+		t.Fatalf("got status %d for keys, want %d", code, http.StatusOK)
+	}
+	if !reflect.DeepEqual(page, kvdb.HTTPKeysPage{Keys: []string{"Alice-0", "Alice-1", "Alice-2"}, NextCursor: "Alice-2"}) {
+		t.Fatalf("got first page %+v", page)
+	}
+	page = kvdb.HTTPKeysPage{}
+	getJSON(t, "GET", srv.URL+"/spaces/users/keys?prefix=Alice&limit=3&cursor="+"Alice-2", &page)
+	if !reflect.DeepEqual(page, kvdb.HTTPKeysPage{Keys: []string{"Alice-3", "Alice-4"}}) {
+		t.Fatalf("got last page %+v", page)
+	}
+	page = kvdb.HTTPKeysPage{}
+	getJSON(t, "GET", srv.URL+"/spaces/users/keys?prefix=Bob&cursor="+"Alice-2", &page)
+	if !reflect.DeepEqual(page, kvdb.HTTPKeysPage{Keys: []string{"Bob/1"}}) {
+		t.Fatalf("got page of prefix after cursor %+v", page)
+	}
+
+	var user helpers.TestUser
+	if code := getJSON(t, "GET", srv.URL+"/spaces/users/keys/Bob/1", &user); code != http.StatusOK || user.Name != "Bob" {
+		t.Fatalf("got status %d and user %+v for key, want Bob", code, user)
+	}
+
+	var stats map[string]any
+	if code := getJSON(t, "GET", srv.URL+"/stats", &stats); code != http.StatusOK {
+		t.Fatalf("got status %d for /stats, want %d", code, http.StatusOK)
+	}
+	if alive, ok := stats["Alive"].(float64); !ok || alive != 6 {
+		t.Fatalf("got stats %v, want 6 alive records", stats)
+	}
+
+	errorCases := []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/spaces/orders/keys", http.StatusNotFound},
+		{"GET", "/spaces/users/keys/Eve", http.StatusNotFound},
+		{"GET", "/spaces/users/keys?limit=zero", http.StatusBadRequest},
+		{"PUT", "/spaces/users/keys/Eve", http.StatusMethodNotAllowed},
+		{"DELETE", "/spaces/users/keys/Bob/1", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range errorCases {
+		var into any
+		if tc.code != http.StatusMethodNotAllowed {
+			into = &map[string]string{}
+		}
+		if code := getJSON(t, tc.method, srv.URL+tc.path, into); code != tc.code {
+			t.Fatalf("got status %d for %s %s, want %d", code, tc.method, tc.path, tc.code)
+		}
+	}
+	if n := users.Len(); n != 6 {
+		t.Fatalf("got %d users after requests, want 6", n)
+	}
+}
+
+func TestKVDBListenAndServe(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- db.ListenAndServe(ctx, "127.0.0.1:0")
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("got error %v after cancel, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("server is not stopped after cancel")
+	}
+
+	if err := db.ListenAndServe(context.Background(), "127.0.0.1:-1"); err == nil {
+		t.Fatalf("served on invalid address, want error")
+	}
+}
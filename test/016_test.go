@@ -0,0 +1,426 @@
+package main_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+func TestKVDBStorage(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	storage := helpers.NewMemStorage()
+	opts := kvdb.Options{Storage: storage}
+
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	data := gen.Create(20)
+	for i, user := range data {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+		if i == 9 {
+			if err = db.Snapshot(); err != nil {
+				t.Fatalf("failed to snapshot: %v", err)
+			}
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+
+	var snaps int
+	for _, filePath := range storage.Files() {
+		if strings.HasSuffix(filePath, ".snap") {
+			snaps++
+		}
+	}
+	if snaps != 1 {
+		t.Fatalf("got %d snapshots in storage %v, want 1", snaps, storage.Files())
+	}
+	// only the lock is kept in the local filesystem
+	entries, err := os.ReadDir(helpers.DbPath)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	for _, ent := range entries {
+		if ext := filepath.Ext(ent.Name()); ext == ".snap" || ext == ".jlog" {
+			t.Fatalf("got data file %s in the local filesystem", ent.Name())
+		}
+	}
+
+	db, err = kvdb.OpenWithOptions(helpers.DbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+	users, err = db.ExistingSpace("users")
+	if err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if users.Len() != len(data) {
+		t.Fatalf("got %d users after reopen, want %d", users.Len(), len(data))
+	}
+	for _, user := range data {
+		var got helpers.TestUser
+		if err = users.Get([]byte(user.Name), &got); err != nil {
+			t.Fatalf("failed to get user %s: %v", user.Name, err)
+		}
+		if !helpers.Compare(got, user) {
+			t.Fatalf("got user %v, want %v", got, user)
+		}
+	}
+}
+
+func TestKVDBStorageSyncDir(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	storage := helpers.NewMemStorage()
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	data := gen.Create(10)
+	for _, user := range data {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	// every key is written twice to the jlog after the snapshot
+	for range 2 {
+		for i := range data {
+			gen.Change(&data[i])
+			if err = users.Set([]byte(data[i].Name), data[i]); err != nil {
+				t.Fatalf("failed to change user: %v", err)
+			}
+		}
+	}
+	if err = users.Compact(users.View()); err != nil {
+		t.Fatalf("failed to compact space: %v", err)
+	}
+	before := len(storage.Journal())
+	if err = db.Defrag(); err != nil {
+		t.Fatalf("failed to defrag: %v", err)
+	}
+
+	journal := storage.Journal()
+	// old files are removed only after the defrag snapshot is durable
+	defrag := journal[before:]
+	first := slices.IndexFunc(defrag, func(entry string) bool {
+		return strings.HasPrefix(entry, "remove ") && !strings.HasSuffix(entry, "."+kvdb.INPROGRESS_EXTENSION)
+	})
+	if first < 2 || !strings.HasPrefix(defrag[first-2], "rename ") || !strings.HasPrefix(defrag[first-1], "syncdir ") {
+		t.Fatalf("got %v on defrag, want old files removed after rename and syncdir", defrag)
+	}
+
+	renames := 0
+	for i, entry := range journal {
+		newPath, ok := strings.CutPrefix(entry, "rename ")
+		if !ok {
+			continue
+		}
+		renames++
+		if want := "syncdir " + filepath.Dir(newPath); i+1 == len(journal) || journal[i+1] != want {
+			t.Fatalf("got %v after %s, want %s", journal[i+1:], entry, want)
+		}
+	}
+	// snapshot, compacted jlog and defrag snapshot
+	if renames < 3 {
+		t.Fatalf("got %d renames in %v, want at least 3", renames, journal)
+	}
+}
+
+func TestKVDBFlush(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	storage := helpers.NewMemStorage()
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	for _, user := range gen.Create(10) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	// writes are done, the crash happens on sync
+	errCrash := errors.New("crash")
+	storage.FailSync(errCrash)
+	if err = users.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+	if err = db.Flush(); !errors.Is(err, errCrash) {
+		t.Fatalf("got error %v on flush, want %v", err, errCrash)
+	}
+	if stats := db.ErrorStats(); stats.ErrorCount != 1 {
+		t.Fatalf("got %d errors in stats, want 1", stats.ErrorCount)
+	}
+
+	storage.FailSync(nil)
+	if err = db.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if err = db.Flush(); err != kvdb.ErrClosed {
+		t.Fatalf("got error %v on flush of closed db, want %v", err, kvdb.ErrClosed)
+	}
+}
+
+func TestKVDBCheckpoint(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lsn := db.CommitPoint(); lsn != 0 {
+		t.Fatalf("got commit point %d without checkpoint, want 0", lsn)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	for _, user := range gen.Create(10) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if lsn := db.CommitPoint(); lsn != 10 {
+		t.Fatalf("got commit point %d, want 10", lsn)
+	}
+	for _, user := range gen.Create(5) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	// crash without syncing writes after the checkpoint
+	if err = db.HardClose(); !errors.Is(err, kvdb.ErrDataLossRisk) {
+		t.Fatalf("got error %v on hard close, want %v", err, kvdb.ErrDataLossRisk)
+	}
+	if err = db.Checkpoint(); err != kvdb.ErrClosed {
+		t.Fatalf("got error %v on checkpoint of closed db, want %v", err, kvdb.ErrClosed)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if lsn := db.CommitPoint(); lsn != 10 {
+		t.Fatalf("got commit point %d after crash, want 10", lsn)
+	}
+	if lsn := db.CurrentLSN(); lsn != 15 {
+		t.Fatalf("got LSN %d after crash, want 15", lsn)
+	}
+}
+
+func TestKVDBCheckpointConcurrent(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+
+	// checkpoints run along with writes and with each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, user := range (&helpers.UniqueDataGenerator{}).Create(25) {
+				if err := users.Set([]byte(fmt.Sprintf("%s-%d", user.Name, i)), user); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if err := db.Checkpoint(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("failed concurrent write or checkpoint: %v", err)
+	}
+	if err = db.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if lsn := db.CommitPoint(); lsn != 100 {
+		t.Fatalf("got commit point %d, want 100", lsn)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	if lsn := db.CommitPoint(); lsn != 100 {
+		t.Fatalf("got commit point %d after reopen, want 100", lsn)
+	}
+}
+
+func TestKVDBRestoreFailedCopy(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	storage := helpers.NewMemStorage()
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	for _, user := range gen.Create(10) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.CopyTo("backup"); err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	for _, user := range gen.Create(5) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+
+	// copies of the backup can not be synced: data files must be kept
+	errCrash := errors.New("crash")
+	storage.FailSync(errCrash)
+	if err = db.Restore("backup"); !errors.Is(err, errCrash) {
+		t.Fatalf("got error %v on restore, want %v", err, errCrash)
+	}
+	storage.FailSync(nil)
+	for _, path := range storage.Files() {
+		if strings.HasSuffix(path, "."+kvdb.INPROGRESS_EXTENSION) {
+			t.Fatalf("got temporary file %s after failed restore", path)
+		}
+	}
+	if n := users.Len(); n != 15 {
+		t.Fatalf("got %d users after failed restore, want 15", n)
+	}
+	if err = users.Set([]byte("Alice"), helpers.TestUser{Name: "Alice"}); err != nil {
+		t.Fatalf("failed to set user after failed restore: %v", err)
+	}
+
+	if err = db.Restore("backup"); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if users, err = db.Space("users"); err != nil {
+		t.Fatalf("failed to get space users: %v", err)
+	}
+	if n := users.Len(); n != 10 {
+		t.Fatalf("got %d users after restore, want 10", n)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	db, err = kvdb.OpenWithOptions(helpers.DbPath, kvdb.Options{Storage: storage})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if lens := db.SpaceLens(); lens["users"] != 10 {
+		t.Fatalf("got %d users after reopen, want 10", lens["users"])
+	}
+}
+
+// storageWithoutSyncDir hides SyncDir of the wrapped storage
+type storageWithoutSyncDir struct {
+	kvdb.Storage
+}
+
+func TestKVDBStorageWithoutSyncDir(t *testing.T) {
+	if err := helpers.CleanDB(helpers.DbPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+	storage := helpers.NewMemStorage()
+	opts := kvdb.Options{Storage: storageWithoutSyncDir{storage}}
+	db, err := kvdb.OpenWithOptions(helpers.DbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	gen := helpers.UniqueDataGenerator{}
+	for _, user := range gen.Create(10) {
+		if err = users.Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	for _, entry := range storage.Journal() {
+		if strings.HasPrefix(entry, "syncdir ") {
+			t.Fatalf("got %s with storage without SyncDir", entry)
+		}
+	}
+
+	if db, err = kvdb.OpenWithOptions(helpers.DbPath, opts); err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if lens := db.SpaceLens(); lens["users"] != 10 {
+		t.Fatalf("got %d users after reopen, want 10", lens["users"])
+	}
+}
@@ -0,0 +1,164 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ochaton/kvdb"
+	"github.com/ochaton/kvdb/test/helpers"
+)
+
+var (
+	promComment = regexp.MustCompile(`^# (HELP [a-z_]+ .+|TYPE [a-z_]+ (counter|gauge))$`)
+	promSample  = regexp.MustCompile(`^([a-z_]+) ([0-9]+)$`)
+)
+
+func TestKVDBMetrics(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	users, err := db.NewSpace("users")
+	if err != nil {
+		t.Fatalf("failed to create space users: %v", err)
+	}
+	dataGen := &helpers.UniqueDataGenerator{}
+	data := dataGen.Create(10)
+	for _, item := range data {
+		if err = users.Set([]byte(item.Name), item); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	if err = users.Del([]byte(data[0].Name)); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if err = db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if _, err = db.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if err = users.Set([]byte(data[1].Name), data[1]); err != nil {
+		t.Fatalf("failed to set user: %v", err)
+	}
+
+	m := db.Metrics()
+	if m.RecordsAlive != 9 || m.RecordsDead != 1 {
+		t.Fatalf("got %d alive and %d dead records, want 9 and 1", m.RecordsAlive, m.RecordsDead)
+	}
+	if m.WritesTotal != 12 || m.CurrentLSN != 12 {
+		t.Fatalf("got %d writes at LSN %d, want 12 at 12", m.WritesTotal, m.CurrentLSN)
+	}
+	if m.WriteBytesTotal == 0 || m.CompactionsTotal != 1 || m.SnapshotsTotal != 2 || m.JlogFileCount != 1 {
+		t.Fatalf("got metrics %+v", m)
+	}
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("got %d bytes written, want %d", n, buf.Len())
+	}
+	samples := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if promComment.MatchString(line) {
+			continue
+		}
+		match := promSample.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("got invalid line %q in:\n%s", line, buf.String())
+		}
+		samples[match[1]] = match[2]
+	}
+	want := map[string]string{
+		"kvdb_records_alive":      "9",
+		"kvdb_records_dead":       "1",
+		"kvdb_writes_total":       "12",
+		"kvdb_compactions_total":  "1",
+		"kvdb_snapshots_total":    "2",
+		"kvdb_current_lsn":        "12",
+		"kvdb_writer_queue_depth": "0",
+		"kvdb_jlog_files":         "1",
+	}
+	for name, value := range want {
+		if samples[name] != value {
+			t.Fatalf("got %s %q, want %q", name, samples[name], value)
+		}
+	}
+	if samples["kvdb_write_bytes_total"] == "0" {
+		t.Fatalf("got zero kvdb_write_bytes_total")
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if m := db.Metrics(); m != (kvdb.Metrics{}) {
+		t.Fatalf("got metrics %+v of closed db, want zero", m)
+	}
+}
+
+func TestKVDBRecordCount(t *testing.T) {
+	db, err := helpers.SetupDB(helpers.DbPath, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { db.Close() }()
+	spaces := []*kvdb.Space{}
+	for _, name := range []string{"users", "customers", "guests"} {
+		space, err := db.NewSpace(name)
+		if err != nil {
+			t.Fatalf("failed to create space %s: %v", name, err)
+		}
+		spaces = append(spaces, space)
+	}
+	data := (&helpers.UniqueDataGenerator{}).Create(30)
+	for i, user := range data {
+		if err = spaces[i%len(spaces)].Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	// overwritten and missing keys do not change the count
+	for i, user := range data[:5] {
+		if err = spaces[i%len(spaces)].Set([]byte(user.Name), user); err != nil {
+			t.Fatalf("failed to set user: %v", err)
+		}
+	}
+	for i, user := range data[:10] {
+		if err = spaces[(i+1)%len(spaces)].Del([]byte(user.Name)); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if err = spaces[i%len(spaces)].Del([]byte(user.Name)); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+	}
+	if n := db.RecordCount(); n != 20 {
+		t.Fatalf("got %d records, want %d", n, 20)
+	}
+	if n := db.DeadCount(); n != 35 {
+		t.Fatalf("got %d dead records, want %d", n, 35)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("got %d records of closed db, want 0", n)
+	}
+	if db, err = helpers.SetupDB(helpers.DbPath, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if n := db.RecordCount(); n != 20 {
+		t.Fatalf("got %d records after reopen, want %d", n, 20)
+	}
+	if err = db.DropAllSpaces(); err != nil {
+		t.Fatalf("failed to drop spaces: %v", err)
+	}
+	if n := db.RecordCount(); n != 0 {
+		t.Fatalf("got %d records after drop, want 0", n)
+	}
+}
@@ -1,8 +1,10 @@
 package helpers
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"sync"
 
@@ -123,3 +125,38 @@ func Merge(arr1, arr2 []TestUser) []TestUser {
 	common = append(common, arr2...)
 	return common
 }
+
+// LogRecorder is a slog.Handler keeping all handled records
+type LogRecorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (lr *LogRecorder) Enabled(context.Context, slog.Level) bool { return true }
+func (lr *LogRecorder) WithAttrs([]slog.Attr) slog.Handler       { return lr }
+func (lr *LogRecorder) WithGroup(string) slog.Handler            { return lr }
+
+func (lr *LogRecorder) Handle(_ context.Context, r slog.Record) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.records = append(lr.records, r.Clone())
+	return nil
+}
+
+// Find returns attributes of the first record with the given message
+func (lr *LogRecorder) Find(msg string) (map[string]slog.Value, bool) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for _, r := range lr.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := map[string]slog.Value{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return attrs, true
+	}
+	return nil, false
+}
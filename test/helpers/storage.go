@@ -0,0 +1,236 @@
+package helpers
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ochaton/kvdb"
+)
+
+// MemStorage is kvdb.Storage keeping data files in memory
+type MemStorage struct {
+	mu      sync.Mutex
+	files   map[string]*memData
+	dirs    map[string]bool
+	journal []string
+	syncErr error
+}
+
+type memData struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: map[string]*memData{}, dirs: map[string]bool{".": true, "/": true}}
+}
+
+// Files returns sorted paths of all files
+func (s *MemStorage) Files() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.files))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Journal returns renames, removals and directory syncs in the order they are done,
+// as "rename <newpath>", "remove <path>" and "syncdir <path>"
+func (s *MemStorage) Journal() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.journal)
+}
+
+func (s *MemStorage) OpenFile(path string, flag int, perm os.FileMode) (kvdb.StorageFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Clean(path)
+	md, ok := s.files[path]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	case !ok && !s.dirs[filepath.Dir(path)]:
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	case !ok:
+		md = &memData{modTime: time.Now()}
+		s.files[path] = md
+	case flag&os.O_TRUNC != 0:
+		md.data = nil
+	}
+	return &memFile{s: s, md: md, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (s *MemStorage) MkdirAll(path string, perm os.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path = filepath.Clean(path); !s.dirs[path]; path = filepath.Dir(path) {
+		s.dirs[path] = true
+	}
+	return nil
+}
+
+func (s *MemStorage) ReadDir(path string) ([]os.DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Clean(path)
+	if !s.dirs[path] {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	entries := []os.DirEntry{}
+	for filePath, md := range s.files {
+		if filepath.Dir(filePath) == path {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{filepath.Base(filePath), &memData{data: md.data, modTime: md.modTime}}))
+		}
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (s *MemStorage) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Clean(path)
+	if _, ok := s.files[path]; !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(s.files, path)
+	s.journal = append(s.journal, "remove "+path)
+	return nil
+}
+
+func (s *MemStorage) Rename(oldpath, newpath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	md, ok := s.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(s.files, oldpath)
+	s.files[newpath] = md
+	s.journal = append(s.journal, "rename "+newpath)
+	return nil
+}
+
+func (s *MemStorage) SyncDir(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Clean(path)
+	if !s.dirs[path] {
+		return &fs.PathError{Op: "sync", Path: path, Err: fs.ErrNotExist}
+	}
+	s.journal = append(s.journal, "syncdir "+path)
+	return nil
+}
+
+func (s *MemStorage) Stat(path string) (os.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Clean(path)
+	if md, ok := s.files[path]; ok {
+		return memFileInfo{filepath.Base(path), &memData{data: md.data, modTime: md.modTime}}, nil
+	}
+	if s.dirs[path] {
+		return memFileInfo{name: filepath.Base(path)}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+type memFile struct {
+	s      *MemStorage
+	md     *memData
+	off    int
+	append bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if f.off >= len(f.md.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.md.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if f.append {
+		f.off = len(f.md.data)
+	}
+	if end := f.off + len(p); end > len(f.md.data) {
+		f.md.data = append(f.md.data, make([]byte, end-len(f.md.data))...)
+	}
+	n := copy(f.md.data[f.off:], p)
+	f.off += n
+	f.md.modTime = time.Now()
+	return n, nil
+}
+
+// FailSync makes Sync of all files fail with err, nil restores it
+func (s *MemStorage) FailSync(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncErr = err
+}
+
+func (f *memFile) Sync() error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	return f.s.syncErr
+}
+
+func (f *memFile) Close() error { return nil }
+
+// memFileInfo describes a file, md is nil for directories
+type memFileInfo struct {
+	name string
+	md   *memData
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) IsDir() bool  { return fi.md == nil }
+func (fi memFileInfo) Sys() any     { return nil }
+
+func (fi memFileInfo) Size() int64 {
+	if fi.md == nil {
+		return 0
+	}
+	return int64(len(fi.md.data))
+}
+
+func (fi memFileInfo) Mode() fs.FileMode {
+	if fi.md == nil {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi memFileInfo) ModTime() time.Time {
+	if fi.md == nil {
+		return time.Time{}
+	}
+	return fi.md.modTime
+}
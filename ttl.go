@@ -0,0 +1,144 @@
+package kvdb
+
+import "time"
+
+// DEFAULT_TTL_REAP_INTERVAL is used if Options.TTLReapInterval is not set
+const DEFAULT_TTL_REAP_INTERVAL = time.Second
+
+// NewSpaceWithTTL creates a new space like NewSpace,
+// every record set without an explicit TTL expires after defaultTTL.
+// The TTL must be set every time the database is opened,
+// records already stored keep their expiration time.
+func (db *T) NewSpaceWithTTL(name string, defaultTTL time.Duration) (*Space, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := db.checkSpace(name); err != nil {
+		return nil, err
+	}
+	space := db.space(name, true)
+	space.opts.DefaultTTL = defaultTTL
+	return space, nil
+}
+
+// SetWithTTL sets the value like Set, the record expires after ttl
+// instead of the default TTL of the space. Zero ttl never expires.
+// Expired records are not returned by Get and are deleted from
+// the space in background every Options.TTLReapInterval.
+func (s *Space) SetWithTTL(key []byte, value any, ttl time.Duration) error {
+	if key == nil {
+		return ErrKeyIsNil
+	}
+
+	value, err := s.opts.encode(value)
+	if err != nil {
+		return err
+	}
+	rec := &record{
+		Key:     key,
+		Value:   value,
+		Tag:     *s.name,
+		Expires: expiresAt(time.Now(), ttl),
+	}
+	if err := s.writeSet(rec); err != nil {
+		return err
+	}
+	_, _ = s.treeSet(rec)
+
+	return nil
+}
+
+// expiresAt returns expiration time of a record set at now with ttl,
+// 0 if it never expires
+func expiresAt(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixNano()
+}
+
+// expired reports whether the record is expired at now
+func (r *record) expired(now time.Time) bool {
+	return r.Expires != 0 && r.Expires <= now.UnixNano()
+}
+
+// reapExpired deletes records expired at now.
+// The records are checked again in the writer goroutine,
+// so records set after the scan are not deleted.
+// Returns the number of deleted records.
+func (s *Space) reapExpired(now time.Time) (int, error) {
+	expired := []*record{}
+	s.tree.Scan(func(r *record) bool {
+		if r.expired(now) {
+			expired = append(expired, r)
+		}
+		return true
+	})
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	deleted := 0
+	err := s.wr.Exec(func(write func(*operation) error) error {
+		for _, r := range expired {
+			if cur, found := s.treeGet(r); !found || cur != r {
+				continue
+			}
+			rec := &record{Key: r.Key, Tag: *s.name}
+			op := newOperation(rec, OPERATION_DEL)
+			if err := write(&op); err != nil {
+				return err
+			}
+			op.upgradeRecord()
+			_, _ = s.treeDel(rec)
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// reapLoop deletes expired records of all spaces
+// every interval until stop is closed
+func (db *T) reapLoop(stop chan struct{}, interval time.Duration) {
+	defer db.reaping.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if !db.reap(now) {
+				return
+			}
+		}
+	}
+}
+
+// reap deletes records of all spaces expired at now,
+// returns false if the database is closed
+func (db *T) reap(now time.Time) bool {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return false
+	}
+	// deletes wait for the writer, which may be paused,
+	// so they are done without holding the database lock
+	spaces := make([]Space, 0, len(db.spaces))
+	for _, space := range db.spaces {
+		spaces = append(spaces, space)
+	}
+	db.mu.RUnlock()
+
+	for _, space := range spaces {
+		// failed deletes are retried on the next tick
+		_, _ = space.reapExpired(now)
+	}
+	return true
+}
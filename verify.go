@@ -0,0 +1,173 @@
+package kvdb
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+)
+
+// VerifyResult describes spaces which differ from data files
+type VerifyResult struct {
+	// Spaces holds differences of spaces from their state replayed
+	// from data files: Added are keys missing in data files,
+	// Deleted are keys missing in the space. Equal spaces are not listed.
+	Spaces map[string]*SpaceDiff
+}
+
+// OK reports whether all spaces match data files
+func (res VerifyResult) OK() bool {
+	return len(res.Spaces) == 0
+}
+
+// VerifyProgress is sent by VerifyBackground after every replayed data file.
+// The last message has Done set with the result or the error.
+type VerifyProgress struct {
+	FilesDone  int
+	FilesTotal int
+
+	Done   bool
+	Result VerifyResult
+	Err    error
+}
+
+// Verify replays data files into temporary spaces and compares them
+// with spaces of the database. Spaces are copied and data files are bounded
+// in the writer goroutine when Verify starts, writes are not blocked
+// and writes made after that are not verified.
+// It reads every data file, so it is meant for maintenance.
+func (db *T) Verify() (VerifyResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return VerifyResult{}, ErrClosed
+	}
+	return db.verify(context.Background(), nil)
+}
+
+// VerifyBackground runs Verify in a new goroutine reporting its progress
+// to the returned channel, which is closed after the message with Done set.
+// Verification stops with ctx.Err() if ctx is done. The channel keeps only
+// the latest message, a message which is not received is replaced
+// by the next one, so the goroutine never blocks on the channel
+// and the database can be closed while the channel is not read.
+func (db *T) VerifyBackground(ctx context.Context) (<-chan VerifyProgress, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+
+	progress := make(chan VerifyProgress, 1)
+	// send replaces the message not received yet,
+	// it never blocks as the goroutine is the only sender
+	send := func(p VerifyProgress) {
+		select {
+		case <-progress:
+		default:
+		}
+		progress <- p
+	}
+	go func() {
+		defer close(progress)
+
+		var last VerifyProgress
+		res, err := db.verify(ctx, func(done, total int) {
+			last = VerifyProgress{FilesDone: done, FilesTotal: total}
+			send(last)
+		})
+		db.mu.RUnlock()
+		send(VerifyProgress{FilesDone: last.FilesDone, FilesTotal: last.FilesTotal, Done: true, Result: res, Err: err})
+	}()
+	return progress, nil
+}
+
+// verify compares spaces with data files calling progress after every file.
+// Views of spaces, the list of data files with their sizes and the LSN
+// are taken in the writer goroutine, so operations written after that
+// are neither replayed nor compared.
+// Must be called under the database lock.
+func (db *T) verify(ctx context.Context, progress func(done, total int)) (VerifyResult, error) {
+	var (
+		views      map[string]Space
+		filePathes []string
+		sizes      []int64
+		upTo       uint64
+	)
+	st := db.opts.storage()
+	err := db.wr.Exec(func(func(*operation) error) error {
+		var err error
+		if filePathes, err = db.wr.DataFiles(); err != nil {
+			return err
+		}
+		sizes = make([]int64, len(filePathes))
+		for i, filePath := range filePathes {
+			fi, err := st.Stat(filePath)
+			if err != nil {
+				return err
+			}
+			sizes[i] = fi.Size()
+		}
+		views, upTo = db.views(), db.wr.LSN()
+		return nil
+	})
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	past := db.replayDB()
+	for i, filePath := range filePathes {
+		if err := ctx.Err(); err != nil {
+			return VerifyResult{}, err
+		}
+		if err := db.replayFileSize(past, filePath, sizes[i], upTo); err != nil {
+			return VerifyResult{}, err
+		}
+		if progress != nil {
+			progress(i+1, len(filePathes))
+		}
+	}
+
+	res := VerifyResult{Spaces: map[string]*SpaceDiff{}}
+	names := maps.Clone(views)
+	maps.Copy(names, past.spaces)
+	for name := range names {
+		cur, wal := views[name], past.spaces[name]
+		if cur.tree == nil {
+			cur = newSpaceWithComparator(name, nil, wal.opts.Comparator)
+		}
+		if wal.tree == nil {
+			wal = newSpaceWithComparator(name, nil, cur.opts.Comparator)
+		}
+		diff := diffSpaces(&wal, &cur)
+		if len(diff.Added)+len(diff.Modified)+len(diff.Deleted) > 0 {
+			res.Spaces[name] = diff
+		}
+	}
+	return res, nil
+}
+
+// replayFileSize applies operations with LSN up to upTo stored
+// in the first size bytes of the data file to spaces of past,
+// so the jlog file being appended is read only up to a whole operation
+func (db *T) replayFileSize(past *T, filePath string, size int64, upTo uint64) error {
+	fh, err := db.opts.storage().OpenFile(filePath, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	r, err := decompressed(io.LimitReader(fh, size))
+	if err != nil {
+		return err
+	}
+	replay := func(op *operation) (uint64, error) {
+		if op.LSN > upTo {
+			return op.LSN, nil
+		}
+		return past.applyTxn(op)
+	}
+	_, err = innerLoadDataFile(WithReaderStats(r), replay, fileCodec(filePath, db.opts.codec()))
+	return err
+}
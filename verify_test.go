@@ -0,0 +1,121 @@
+package kvdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	/* test Verify detects records which differ from data files */
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	users, _ := db.NewSpace("users")
+	for _, key := range []string{"Alice", "Bob", "Dave"} {
+		if err := users.Set([]byte(key), key); err != nil {
+			t.Fatalf("failed to set %s: %v", key, err)
+		}
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err := users.Set([]byte("Eve"), "Eve"); err != nil {
+		t.Fatalf("failed to set Eve: %v", err)
+	}
+
+	res, err := db.Verify()
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if !res.OK() {
+		t.Fatalf("failed result check: spaces: %v, expected: none", res.Spaces)
+	}
+
+	// inject records which are not in data files
+	_, _ = users.treeSet(&record{Key: []byte("Alice"), Tag: "users", Value: "corrupted", LSN: 1000})
+	_, _ = users.treeSet(&record{Key: []byte("Carol"), Tag: "users", Value: "Carol", LSN: 1001})
+	_, _ = users.treeDel(&record{Key: []byte("Bob")})
+
+	expected := map[string]*SpaceDiff{"users": {
+		Added:    [][]byte{[]byte("Carol")},
+		Modified: [][]byte{[]byte("Alice")},
+		Deleted:  [][]byte{[]byte("Bob")},
+	}}
+	if res, err = db.Verify(); err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if !reflect.DeepEqual(res.Spaces, expected) {
+		t.Fatalf("failed result check: spaces: %v, expected: %v", res.Spaces["users"], expected["users"])
+	}
+
+	progress, err := db.VerifyBackground(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start verify: %v", err)
+	}
+	var last VerifyProgress
+	for last = range progress {
+	}
+	if last.FilesDone != 2 || last.FilesTotal != 2 || !last.Done || last.Err != nil {
+		t.Fatalf("failed progress check: last: %+v, expected: 2 files done", last)
+	}
+	if !reflect.DeepEqual(last.Result.Spaces, expected) {
+		t.Fatalf("failed result check: spaces: %v, expected: %v", last.Result.Spaces["users"], expected["users"])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if progress, err = db.VerifyBackground(ctx); err != nil {
+		t.Fatalf("failed to start verify: %v", err)
+	}
+	for last = range progress {
+	}
+	if last.Err != context.Canceled {
+		t.Fatalf("failed result check: error: %v, expected: %v", last.Err, context.Canceled)
+	}
+}
+
+func TestVerifyBackgroundNotReceived(t *testing.T) {
+	/* test verification finishes and db closes if progress is not received */
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	users, _ := db.NewSpace("users")
+	for _, key := range []string{"Alice", "Bob"} {
+		if err := users.Set([]byte(key), key); err != nil {
+			t.Fatalf("failed to set %s: %v", key, err)
+		}
+		if err := db.wr.Rotate(); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+	}
+
+	progress, err := db.VerifyBackground(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start verify: %v", err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed to close db: blocked by verification")
+	}
+
+	// messages not received are replaced by the result
+	var last VerifyProgress
+	for last = range progress {
+	}
+	if !last.Done || last.Err != nil || !last.Result.OK() {
+		t.Fatalf("failed progress check: last: %+v, expected: ok result", last)
+	}
+}
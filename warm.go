@@ -0,0 +1,33 @@
+package kvdb
+
+// WarmCache decodes every record of the space once to warm up
+// decoding buffers before the first reads. Decoded values are dropped.
+// newValue returns a pointer to decode a value into, values are
+// decoded into map[string]any if it is nil.
+func (db *T) WarmCache(space string, newValue func() any) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	sp := db.space(space, false)
+	if sp == nil {
+		db.mu.RUnlock()
+		return ErrSpaceNotFound
+	}
+	view := sp.View()
+	db.mu.RUnlock()
+
+	if newValue == nil {
+		newValue = func() any { return &map[string]any{} }
+	}
+
+	iter := view.Iter()
+	defer iter.Release()
+	for iter.HasNext() {
+		if err := iter.Next(newValue()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
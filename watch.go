@@ -0,0 +1,175 @@
+package kvdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// DEFAULT_WATCH_BUF_SIZE is used by Space.Watch if Options.WatchBufSize is not set
+const DEFAULT_WATCH_BUF_SIZE = 100
+
+// SpaceEvent describes a change of a watched space
+type SpaceEvent struct {
+	Key      []byte
+	Op       oType // OPERATION_SET or OPERATION_DEL
+	LSN      uint64
+	RawValue json.RawMessage // JSON encoded new value, nil for del
+}
+
+// Watcher delivers changes of a single space, see Space.Watch
+type Watcher struct {
+	space    string
+	ch       chan SpaceEvent
+	dropped  atomic.Uint64
+	registry *watchers
+	once     sync.Once
+	stopped  chan struct{}
+}
+
+// Events returns the channel of changes.
+// It is closed by Stop, when the context of Space.Watch is done
+// or when the database is closed.
+func (w *Watcher) Events() <-chan SpaceEvent {
+	return w.ch
+}
+
+// Dropped returns number of the oldest changes dropped
+// because the channel was full
+func (w *Watcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Stop removes the watcher and closes its channel
+func (w *Watcher) Stop() {
+	w.once.Do(func() {
+		w.registry.remove(w)
+		close(w.ch)
+		close(w.stopped)
+	})
+}
+
+// Watch returns a watcher receiving changes of the space written
+// after the call. Every watcher receives its own copy of every change.
+// Changes are sent without blocking the writer: when the channel
+// is full the oldest change is dropped and counted in Dropped.
+// The watcher is stopped when ctx is done. Views can not be watched.
+func (s *Space) Watch(ctx context.Context) (*Watcher, error) {
+	if _, ok := s.wr.(readOnlyWriter); ok || s.watchers == nil {
+		return nil, ErrReadOnly
+	}
+
+	w := &Watcher{
+		space:    *s.name,
+		registry: s.watchers,
+		stopped:  make(chan struct{}),
+	}
+	if !s.watchers.add(w) {
+		return nil, ErrClosed
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Stop()
+		case <-w.stopped:
+		}
+	}()
+	return w, nil
+}
+
+// watchers is a registry of watchers by space name
+type watchers struct {
+	mu      sync.RWMutex
+	bySpace map[string]map[*Watcher]struct{}
+	bufSize int  // capacity of channels of watchers
+	closed  bool // set by stopAll, no watchers are added then
+}
+
+// add creates the channel of the watcher and registers it,
+// returns false if the database is closed
+func (ws *watchers) add(w *Watcher) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return false
+	}
+	w.ch = make(chan SpaceEvent, ws.bufSize)
+	if ws.bySpace == nil {
+		ws.bySpace = map[string]map[*Watcher]struct{}{}
+	}
+	if ws.bySpace[w.space] == nil {
+		ws.bySpace[w.space] = map[*Watcher]struct{}{}
+	}
+	ws.bySpace[w.space][w] = struct{}{}
+	return true
+}
+
+func (ws *watchers) remove(w *Watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	delete(ws.bySpace[w.space], w)
+	if len(ws.bySpace[w.space]) == 0 {
+		delete(ws.bySpace, w.space)
+	}
+}
+
+// stopAll stops all watchers
+func (ws *watchers) stopAll() {
+	ws.mu.Lock()
+	ws.closed = true
+	ws.mu.Unlock()
+
+	ws.mu.RLock()
+	all := []*Watcher{}
+	for _, space := range ws.bySpace {
+		for w := range space {
+			all = append(all, w)
+		}
+	}
+	ws.mu.RUnlock()
+
+	for _, w := range all {
+		w.Stop()
+	}
+}
+
+// dispatch sends the change to watchers of the operation space
+func (ws *watchers) dispatch(op *operation) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	space := ws.bySpace[op.Record.Tag]
+	if len(space) == 0 {
+		return
+	}
+
+	event := SpaceEvent{Op: op.Op, LSN: op.LSN}
+	if op.Op == OPERATION_SET {
+		raw, err := op.Record.rawValue()
+		if err == nil {
+			event.RawValue = raw
+		}
+	}
+	for w := range space {
+		ev := event
+		ev.Key = bytes.Clone(op.Record.Key)
+		for sent := false; !sent; {
+			select {
+			case w.ch <- ev:
+				sent = true
+			default:
+				// the consumer may take the oldest event meanwhile
+				select {
+				case <-w.ch:
+					w.dropped.Add(1)
+				default:
+				}
+			}
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package kvdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -14,6 +18,8 @@ const (
 	JLOG_EXTENSION       = "jlog"
 	SNAP_EXTENSION       = "snap"
 	INPROGRESS_EXTENSION = "inprogress"
+
+	DEFAULT_INCOMING_BUF_SIZE = 100
 )
 
 type status int
@@ -29,34 +35,92 @@ type writer interface {
 	Load(applyTxn func(*operation) (uint64, error)) error
 	Start() error
 	Close() error
+	CloseContext(ctx context.Context, release func() error) error
+	HardClose() error
 	Write(op *operation) error
+	WriteMany(ops []*operation) error
+	Exec(fn execFunc) error
+	Upsert(fn upsertFunc, apply func(op *operation)) error
 	Rotate() error
+	Flush() error
+	TruncateAll() error
+	CompactSpace(name string, upTo uint64) error
 	Snapshot(snap *map[string]Space) error
+	SnapshotTo(snap *map[string]Space, dir string) error
+	SetSyncMode(mode SyncMode) error
+	Ops() uint64
+	LSN() uint64
+	Pending() int
+	DataFiles() ([]string, error)
+	OpenFiles() ([]OpenFileInfo, error)
+	Lazy() bool
 }
 
 type defaultWriter struct {
-	lsn      *atomic.Uint64
-	dir      string
-	file     *os.File
-	mu       sync.RWMutex // guards channel
-	status   status
-	incoming chan task
-	done     chan error
+	lsn           *atomic.Uint64
+	codec         Codec
+	storage       Storage
+	compression   Compression // of written snapshots
+	progress      func(filePath string, bytesRead, totalBytes int64)
+	progressEvery int64                             // bytes read between calls of progress
+	onWrite       func(op *operation)               // called with every written operation
+	onSnapshot    func(filePath string, lsn uint64) // called with every written snapshot
+	load          *loadProgress
+	errs          *errorStats
+	metrics       *writerMetrics
+	snapProgress  func(spacesDone, spacesTotal int, recordsDone, recordsTotal int64)
+	snapEvery     int // records written between calls of snapProgress
+	logger        *slog.Logger
+	lazyLoad      bool          // Load replays only the latest snapshot
+	skipped       int           // jlog files skipped by lazy Load
+	bufSize       int           // capacity of incoming
+	highMark      int           // send fails with ErrWriterBusy when incoming holds so many tasks
+	ops           atomic.Uint64 // number of operations in actual data files
+	draining      atomic.Bool   // set by Close, queued tasks are processed
+	drainErrs     []error       // failures while draining, returned by Close
+	syncMode      atomic.Int32
+	dir           string
+	file          StorageFile
+	filePath      string       // path of file
+	mu            sync.RWMutex // guards channel
+	status        status
+	incoming      chan task
+	quit          chan struct{}
+	done          chan error
 }
 
 // NewWriter creates a new writer
-func newWriter(path string) *defaultWriter {
-	return &defaultWriter{
-		status: created,
-		dir:    path,
-		mu:     sync.RWMutex{},
-	}
+func newWriter(path string, opts Options) *defaultWriter {
+	w := &defaultWriter{
+		status:        created,
+		lsn:           &atomic.Uint64{},
+		dir:           path,
+		codec:         opts.codec(),
+		storage:       opts.storage(),
+		compression:   opts.Compression,
+		progress:      opts.LoadProgressCallback,
+		progressEvery: opts.loadProgressInterval(),
+		snapProgress:  opts.SnapshotProgressCallback,
+		snapEvery:     opts.snapshotProgressInterval(),
+		logger:        opts.logger(),
+		lazyLoad:      opts.LazyLoad,
+		bufSize:       opts.incomingBufSize(),
+		highMark:      opts.IncomingHighWaterMark,
+		load:          &loadProgress{},
+		errs:          &errorStats{},
+		metrics:       &writerMetrics{},
+		mu:            sync.RWMutex{},
+	}
+	w.syncMode.Store(int32(opts.SyncMode))
+	return w
 }
 
 // Load all data files from the directory and apply them to the given function
-// Sets LSN of the last applied operation to writer
+// Sets LSN of the last applied operation to writer.
+// In lazy mode jlog files after the snapshot are skipped,
+// only their last LSN is read.
 func (w *defaultWriter) Load(applyTxn func(*operation) (uint64, error)) error {
-	if err := os.MkdirAll(w.dir, 0755); err != nil {
+	if err := w.storage.MkdirAll(w.dir, 0755); err != nil {
 		return err
 	}
 
@@ -64,21 +128,67 @@ func (w *defaultWriter) Load(applyTxn func(*operation) (uint64, error)) error {
 	if err != nil {
 		return err
 	}
+	var skipped []string
+	if w.lazyLoad && len(filePathes) > 0 && dataFileType(filePathes[0]) == SNAP_EXTENSION {
+		filePathes, skipped = filePathes[:1], filePathes[1:]
+	}
 
+	if err = w.load.start(w.storage, filePathes); err != nil {
+		return err
+	}
+	var base int64 // bytes of files loaded before the current one
 	for _, filePath := range filePathes {
-		lsn, err := loadDataFile(filePath, applyTxn)
+		progress := func(bytesRead, totalBytes int64) {
+			w.load.bytesLoaded.Store(base + bytesRead)
+			if w.progress != nil {
+				w.progress(filePath, bytesRead, totalBytes)
+			}
+		}
+		lsn, ops, err := loadDataFile(w.storage, filePath, applyTxn, w.codec, progress, w.progressEvery)
 		if err != nil {
 			return err
 		}
+		base = w.load.bytesLoaded.Load()
+		w.ops.Add(uint64(ops))
 		if lsn > w.getLSN() {
 			w.setLSN(lsn)
 		}
 	}
+	if len(skipped) > 0 {
+		if err = w.skipDataFiles(skipped); err != nil {
+			return err
+		}
+	}
+	w.skipped = len(skipped)
 	w.status = loaded
 
 	return nil
 }
 
+// skipDataFiles sets LSN of the last operation of skipped files to writer,
+// so the next jlog file follows them
+func (w *defaultWriter) skipDataFiles(filePathes []string) error {
+	for i := len(filePathes) - 1; i >= 0; i-- {
+		lsn, err := lastDataFileLSN(w.storage, filePathes[i], w.codec)
+		if err != nil {
+			return err
+		}
+		if lsn == 0 {
+			// empty jlog file
+			continue
+		}
+		if lsn > w.getLSN() {
+			w.setLSN(lsn)
+		}
+		break
+	}
+	w.logger.Warn("LazyLoadWarning",
+		slog.Int("skippedFiles", len(filePathes)),
+		slog.Uint64("lsn", w.getLSN()),
+	)
+	return nil
+}
+
 // Start writer
 // no locks - already under DB lock
 func (w *defaultWriter) Start() error {
@@ -89,14 +199,17 @@ func (w *defaultWriter) Start() error {
 	if w.status != loaded {
 		return ErrWriterInvalidStatus
 	}
-	w.incoming = make(chan task, 100)
+	w.incoming = make(chan task, w.bufSize)
+	w.quit = make(chan struct{})
+	w.done = make(chan error, 1)
 	w.status = running
 
 	go w.work()
 	return nil
 }
 
-// Close writer
+// Close writer, returns failures of queued tasks joined
+// with the error of closing the current file
 func (w *defaultWriter) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -105,6 +218,7 @@ func (w *defaultWriter) Close() error {
 	}
 
 	w.status = closed
+	w.draining.Store(true)
 
 	if w.incoming != nil {
 		close(w.incoming)
@@ -116,19 +230,184 @@ func (w *defaultWriter) Close() error {
 	return nil
 }
 
+// CloseContext closes the writer like Close, but stops waiting
+// for queued tasks when ctx is done. In this case the working goroutine
+// is told to reject the tasks still queued and to close the current
+// jlog file without sync after the task it is busy with,
+// ErrGracefulCloseTimeout is returned without waiting for it.
+// release is called once the working goroutine has exited,
+// in the background if ctx is done before that.
+func (w *defaultWriter) CloseContext(ctx context.Context, release func() error) error {
+	w.mu.Lock()
+	if w.status == closed {
+		w.mu.Unlock()
+		return ErrWriterInvalidStatus
+	}
+
+	w.status = closed
+	w.draining.Store(true)
+
+	if w.incoming != nil {
+		close(w.incoming)
+	}
+	done, quit := w.done, w.quit
+	w.mu.Unlock()
+
+	if done == nil {
+		return release()
+	}
+	select {
+	case err := <-done:
+		return errors.Join(err, release())
+	case <-ctx.Done():
+		close(quit)
+		go func() {
+			<-done
+			_ = release()
+		}()
+		return errors.Join(ErrGracefulCloseTimeout, ctx.Err())
+	}
+}
+
+// HardClose stops writer without processing queued tasks
+// and closes current jlog file without sync
+func (w *defaultWriter) HardClose() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == closed {
+		return ErrWriterInvalidStatus
+	}
+
+	w.status = closed
+
+	if w.quit != nil {
+		close(w.quit)
+	}
+	if w.done != nil {
+		return <-w.done
+	}
+	return nil
+}
+
 // Request writer to write operation into jlog
 func (w *defaultWriter) Write(op *operation) error {
 	return w.send(newWriteTask(op))
 }
 
+// Request writer to write batch of operations into jlog at once
+func (w *defaultWriter) WriteMany(ops []*operation) error {
+	return w.send(newWriteManyTask(ops))
+}
+
+// Request writer to call fn in the writer goroutine.
+// fn may write operations using the given function.
+func (w *defaultWriter) Exec(fn execFunc) error {
+	return w.send(newExecTask(fn))
+}
+
+// Upsert writes the operation returned by fn and passes it to apply,
+// both are called in the working goroutine, so no write happens between them
+func (w *defaultWriter) Upsert(fn upsertFunc, apply func(op *operation)) error {
+	return w.send(newUpsertTask(fn, apply))
+}
+
 // Request writer to rotate current jlog file
 func (w *defaultWriter) Rotate() error {
 	return w.send(newRotateTask())
 }
 
+// Request writer to log removal of all spaces
+func (w *defaultWriter) TruncateAll() error {
+	return w.send(newTruncateAllTask())
+}
+
+// CompactSpace removes operations of the space with LSN up to upTo
+// from jlog files written before the call, if they are overwritten by
+// later operations with the same key in these files.
+// Files are rewritten in the working goroutine, writes wait for it.
+func (w *defaultWriter) CompactSpace(name string, upTo uint64) error {
+	if w.Lazy() {
+		return ErrLazyLoaded
+	}
+	// operations written so far are moved out of the current jlog file
+	if err := w.Rotate(); err != nil {
+		return err
+	}
+	return w.Exec(func(func(*operation) error) error {
+		filePathes, err := w.listActualDataFiles()
+		if err != nil {
+			return err
+		}
+		jlogs := make([]string, 0, len(filePathes))
+		for _, filePath := range filePathes {
+			if dataFileType(filePath) == JLOG_EXTENSION && filePath != w.filePath {
+				jlogs = append(jlogs, filePath)
+			}
+		}
+		removed, err := compactSpaceFiles(w.storage, jlogs, name, upTo, w.codec)
+		w.ops.Add(-uint64(removed))
+		return w.fail(err)
+	})
+}
+
 // Request writer to snap jlogs
 func (w *defaultWriter) Snapshot(snap *map[string]Space) error {
-	return w.send(newSnapshotTask(snap))
+	return w.SnapshotTo(snap, w.dir)
+}
+
+// Request writer to snap jlogs into the given directory
+func (w *defaultWriter) SnapshotTo(snap *map[string]Space, dir string) error {
+	if w.Lazy() {
+		return ErrLazyLoaded
+	}
+	return w.send(newSnapshotTask(snap, dir))
+}
+
+// Lazy reports whether Load skipped jlog files, so the spaces
+// do not hold all records and must not be written as a snapshot
+func (w *defaultWriter) Lazy() bool {
+	return w.skipped > 0
+}
+
+// Ops returns number of operations stored in actual data files
+func (w *defaultWriter) Ops() uint64 {
+	return w.ops.Load()
+}
+
+// LSN returns LSN of the last written operation
+func (w *defaultWriter) LSN() uint64 {
+	return w.getLSN()
+}
+
+// Pending returns number of tasks queued to the writer
+func (w *defaultWriter) Pending() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.incoming)
+}
+
+// DataFiles returns pathes of the actual data files:
+// the latest snapshot and jlogs written after it
+func (w *defaultWriter) DataFiles() ([]string, error) {
+	return w.listActualDataFiles()
+}
+
+// OpenFiles returns files held open by the writer.
+// Files are inspected in the working goroutine, so rotation can not race.
+func (w *defaultWriter) OpenFiles() ([]OpenFileInfo, error) {
+	files := []OpenFileInfo{}
+	err := w.Exec(func(func(*operation) error) error {
+		if w.file == nil {
+			return nil
+		}
+		fi, err := w.storage.Stat(w.filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, OpenFileInfo{Path: w.filePath, Mode: "rw", SizeBytes: fi.Size()})
+		return nil
+	})
+	return files, err
 }
 
 /******************************************************************************
@@ -136,30 +415,96 @@ func (w *defaultWriter) Snapshot(snap *map[string]Space) error {
  */
 
 func (w *defaultWriter) work() {
-	w.mu.Lock()
-	if w.status != running {
-		return
+	for {
+		// quit is checked first, so queued tasks are not handled after it
+		select {
+		case <-w.quit:
+			w.abort()
+			return
+		default:
+		}
+		select {
+		case <-w.quit:
+			w.abort()
+			return
+		case task, ok := <-w.incoming:
+			if !ok {
+				w.done <- errors.Join(append([]error{closeFile(w.file)}, w.drainErrs...)...)
+				close(w.done)
+				return
+			}
+			w.handle(task)
+		}
 	}
-	w.done = make(chan error, 1)
-	w.mu.Unlock()
+}
 
-	for task := range w.incoming {
-		switch task.Action() {
-		case taskActionWrite:
-			task.SendToCallback(w.write(task.Op()))
-		case taskActionRotate:
-			task.SendToCallback(w.rotate())
-		case taskActionSnapshot:
-			cpt, ok := task.(*taskSnapshot)
-			if !ok {
-				task.SendToCallback(ErrMessageInvalidType)
+func (w *defaultWriter) handle(task task) {
+	switch task.Action() {
+	case taskActionWrite:
+		task.SendToCallback(w.synced(w.write(task.Op())))
+	case taskActionWriteMany:
+		wmt, ok := task.(*taskWriteMany)
+		if !ok {
+			task.SendToCallback(ErrMessageInvalidType)
+			return
+		}
+		task.SendToCallback(w.synced(w.writeMany(wmt.Ops())))
+	case taskActionExec:
+		et, ok := task.(*taskExec)
+		if !ok {
+			task.SendToCallback(ErrMessageInvalidType)
+			return
+		}
+		task.SendToCallback(w.synced(et.Fn()(w.write)))
+	case taskActionUpsert:
+		ut, ok := task.(*taskUpsert)
+		if !ok {
+			task.SendToCallback(ErrMessageInvalidType)
+			return
+		}
+		op, err := ut.fn()
+		if err != nil {
+			task.SendToCallback(err)
+			return
+		}
+		if err = w.write(op); err == nil {
+			ut.apply(op)
+		}
+		task.SendToCallback(w.synced(err))
+	case taskActionRotate:
+		task.SendToCallback(w.fail(w.rotate()))
+	case taskActionTruncateAll:
+		op := newOperation(nil, truncateAll)
+		task.SendToCallback(w.synced(w.write(&op)))
+	case taskActionSnapshot:
+		cpt, ok := task.(*taskSnapshot)
+		if !ok {
+			task.SendToCallback(ErrMessageInvalidType)
+			return
+		}
+		w.snapshot(cpt)
+	}
+}
+
+// abort rejects all queued tasks and closes current file without sync.
+// The queue may be closed already by CloseContext.
+func (w *defaultWriter) abort() {
+	for {
+		select {
+		case task, ok := <-w.incoming:
+			if ok {
+				task.SendToCallback(ErrWriterInvalidStatus)
 				continue
 			}
-			w.snapshot(cpt)
+			w.done <- w.file.Close()
+			close(w.done)
+			return
+		default:
+			w.done <- w.file.Close()
+			close(w.done)
+			return
 		}
 	}
-	w.done <- closeFile(w.file)
-	close(w.done)
 }
 
 /******************************************************************************
@@ -171,7 +516,7 @@ func (w *defaultWriter) snapshot(task *taskSnapshot) error {
 		task.SendToCallback(err)
 		return err
 	}
-	lsn, err := getFileLsn(w.file.Name())
+	lsn, err := getFileLsn(w.filePath)
 	if err != nil {
 		return err
 	}
@@ -181,28 +526,56 @@ func (w *defaultWriter) snapshot(task *taskSnapshot) error {
 }
 
 func (w *defaultWriter) snapBackground(task *taskSnapshot, lsn uint64) {
+	dir := task.Dir()
+	// snapshots written outside of the data directory do not replace data files
+	external := filepath.Clean(dir) != filepath.Clean(w.dir)
+	if external {
+		if err := w.storage.MkdirAll(dir, 0755); err != nil {
+			task.SendToCallback(err)
+			return
+		}
+	}
+
 	// clean old inprogress files
-	if err := w.removeOrphanFiles(); err != nil {
+	if err := removeOrphanFiles(w.storage, dir); err != nil {
 		task.SendToCallback(err)
 		return
 	}
 
-	newFileName := fmt.Sprintf("%s/%s.%s", w.dir, lsn2str(lsn), SNAP_EXTENSION)
+	newFileName := dataFileName(dir, lsn, SNAP_EXTENSION, w.codec)
 	newFileInProgressName := fmt.Sprintf("%s.%s", newFileName, INPROGRESS_EXTENSION)
 
 	// write data to new snapshot
-	fh, err := os.OpenFile(newFileInProgressName, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0644)
+	fh, err := w.storage.OpenFile(newFileInProgressName, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0644)
 	if err != nil {
 		task.SendToCallback(err)
 		return
 	}
+	out, flush := w.compression.compressor(fh)
+
+	var total int64
+	for _, space := range *task.Snap() {
+		total += int64(space.Len())
+	}
+	progress := func(spacesDone int, recordsDone int64) {
+		if w.snapProgress != nil {
+			w.snapProgress(spacesDone, len(*task.Snap()), recordsDone, total)
+		}
+	}
 
+	written := 0
+	spacesDone := 0
 	for _, space := range *task.Snap() {
+		progress(spacesDone, int64(written))
 		iter := space.Iter()
 		for iter.HasNext() {
 			ops := operationsFromRecords(iter.collectNext(100), OPERATION_SET)
+			if (written+len(ops))/w.snapEvery > written/w.snapEvery {
+				progress(spacesDone, int64(written+len(ops)))
+			}
+			written += len(ops)
 
-			err = writeManyTo(ops, fh)
+			err = writeManyTo(ops, out, w.codec)
 			if err != nil {
 				break
 			}
@@ -210,10 +583,18 @@ func (w *defaultWriter) snapBackground(task *taskSnapshot, lsn uint64) {
 		iter.Release()
 		if err != nil {
 			fh.Close()
-			os.Remove(fh.Name())
+			w.storage.Remove(newFileInProgressName)
 			task.SendToCallback(err)
 			return
 		}
+		spacesDone++
+	}
+	progress(spacesDone, int64(written))
+	if err := flush(); err != nil {
+		fh.Close()
+		w.storage.Remove(newFileInProgressName)
+		task.SendToCallback(err)
+		return
 	}
 	if err := fh.Close(); err != nil {
 		task.SendToCallback(err)
@@ -221,16 +602,28 @@ func (w *defaultWriter) snapBackground(task *taskSnapshot, lsn uint64) {
 	}
 
 	// rename snapshot file name
-	if err := os.Rename(fh.Name(), newFileName); err != nil {
-		os.Remove(fh.Name())
+	if err := w.storage.Rename(newFileInProgressName, newFileName); err != nil {
+		w.storage.Remove(newFileInProgressName)
 		task.SendToCallback(err)
 		return
 	}
-
-	if err := w.removeOldDataFiles(lsn); err != nil {
+	if err := syncStorageDir(w.storage, dir); err != nil {
 		task.SendToCallback(err)
 		return
 	}
+	if w.onSnapshot != nil {
+		w.onSnapshot(newFileName, lsn)
+	}
+
+	if !external {
+		if err := w.removeOldDataFiles(lsn); err != nil {
+			task.SendToCallback(err)
+			return
+		}
+		// data files now hold the snapshot and operations written after it
+		w.ops.Store(uint64(written) + w.getLSN() - lsn)
+	}
+	w.metrics.snapshots.Add(1)
 
 	task.SendToCallback(nil)
 }
@@ -240,13 +633,13 @@ func (w *defaultWriter) snapBackground(task *taskSnapshot, lsn uint64) {
  */
 
 func (w *defaultWriter) rotate() error {
-	nextFileName := fmt.Sprintf("%s/%s.%s", w.dir, lsn2str(w.getLSN()+1), JLOG_EXTENSION)
+	nextFileName := dataFileName(w.dir, w.getLSN()+1, JLOG_EXTENSION, w.codec)
 	if w.file != nil {
-		if nextFileName == w.file.Name() {
+		if nextFileName == w.filePath {
 			return nil
 		}
 	}
-	if fi, err := os.Stat(nextFileName); err == nil {
+	if fi, err := w.storage.Stat(nextFileName); err == nil {
 		// File already exists, no need to rotate
 		// if file is empty, it's okay
 		if fi.Size() != 0 {
@@ -255,10 +648,15 @@ func (w *defaultWriter) rotate() error {
 	}
 
 	// open new file
-	newFile, err := os.OpenFile(nextFileName, os.O_CREATE|os.O_RDWR, 0644)
+	newFile, err := w.storage.OpenFile(nextFileName, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
 	}
+	// the new file must stay in the directory once operations are written to it
+	if err = syncStorageDir(w.storage, w.dir); err != nil {
+		newFile.Close()
+		return err
+	}
 
 	if w.file != nil {
 		// close old file
@@ -268,9 +666,10 @@ func (w *defaultWriter) rotate() error {
 		}
 	}
 
-	log.Printf("rotating %s", newFile.Name())
+	log.Printf("rotating %s", nextFileName)
 	// set new file
 	w.file = newFile
+	w.filePath = nextFileName
 	return nil
 }
 
@@ -285,14 +684,64 @@ func (w *defaultWriter) write(op *operation) error {
 	lsn := w.getLSN()
 	op.LSN = lsn + 1
 
-	if err := writeTo(op, w.file); err != nil {
-		return err
+	if err := w.syncedOp(writeTo(op, w.counted(), w.codec)); err != nil {
+		return w.fail(err)
 	}
 
 	w.setLSN(op.LSN)
+	w.ops.Add(1)
+	w.metrics.writes.Add(1)
+	w.notify(op)
 	return nil
 }
 
+func (w *defaultWriter) writeMany(ops []*operation) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	lsn := w.getLSN()
+	for i, op := range ops {
+		op.LSN = lsn + uint64(i) + 1
+	}
+
+	if err := w.syncedOp(writeManyTo(ops, w.counted(), w.codec)); err != nil {
+		return w.fail(err)
+	}
+
+	w.setLSN(ops[len(ops)-1].LSN)
+	w.ops.Add(uint64(len(ops)))
+	w.metrics.writes.Add(uint64(len(ops)))
+	for _, op := range ops {
+		w.notify(op)
+	}
+	return nil
+}
+
+// counted returns the current file counting bytes written to it
+func (w *defaultWriter) counted() io.Writer {
+	return countingWriter{w.file, &w.metrics.writeBytes}
+}
+
+// fail records err in error stats of the writer and returns it.
+// Failures of tasks queued before Close are also returned by Close,
+// so they are not lost if callers do not wait for them.
+func (w *defaultWriter) fail(err error) error {
+	if err != nil {
+		w.errs.record(err)
+		if w.draining.Load() {
+			w.drainErrs = append(w.drainErrs, err)
+		}
+	}
+	return err
+}
+
+// notify passes written operation to the onWrite hook
+func (w *defaultWriter) notify(op *operation) {
+	if w.onWrite != nil {
+		w.onWrite(op)
+	}
+}
+
 // Send message to the writer
 func (w *defaultWriter) send(task task) error {
 	w.mu.RLock()
@@ -300,6 +749,10 @@ func (w *defaultWriter) send(task task) error {
 		w.mu.RUnlock()
 		return ErrWriterInvalidStatus
 	}
+	if w.highMark > 0 && len(w.incoming) >= w.highMark {
+		w.mu.RUnlock()
+		return ErrWriterBusy
+	}
 	w.incoming <- task
 	w.mu.RUnlock()
 
@@ -330,7 +783,7 @@ func (w *defaultWriter) getLSN() uint64 {
 
 func (w *defaultWriter) listActualDataFiles() ([]string, error) {
 	result := []string{}
-	filePathes, err := listDataFiles(w.dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
+	filePathes, err := listDataFiles(w.storage, w.dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
 	if err != nil {
 		return result, err
 	}
@@ -340,7 +793,7 @@ func (w *defaultWriter) listActualDataFiles() ([]string, error) {
 
 	lastSnapPathIdx := -1
 	for i := len(filePathes) - 1; i >= 0; i-- {
-		if strings.HasSuffix(filePathes[i], SNAP_EXTENSION) {
+		if dataFileType(filePathes[i]) == SNAP_EXTENSION {
 			lastSnapPathIdx = i
 			break
 		}
@@ -355,7 +808,7 @@ func (w *defaultWriter) listActualDataFiles() ([]string, error) {
 	}
 
 	for _, filePath := range filePathes {
-		if !strings.HasSuffix(filePath, JLOG_EXTENSION) {
+		if dataFileType(filePath) != JLOG_EXTENSION {
 			continue
 		}
 		lsn, err := getFileLsn(filePath)
@@ -371,7 +824,7 @@ func (w *defaultWriter) listActualDataFiles() ([]string, error) {
 }
 
 func (w *defaultWriter) removeOldDataFiles(lsn uint64) error {
-	filePathes, err := listDataFiles(w.dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
+	filePathes, err := listDataFiles(w.storage, w.dir, []string{SNAP_EXTENSION, JLOG_EXTENSION})
 	if err != nil {
 		return err
 	}
@@ -393,14 +846,14 @@ func (w *defaultWriter) removeOldDataFiles(lsn uint64) error {
 		return nil
 	}
 
-	if err := deleteDataFiles(toRemove); err != nil {
+	if err := deleteDataFiles(w.storage, toRemove); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (w *defaultWriter) removeOrphanFiles() error {
-	filePathes, err := listDataFiles(w.dir, []string{INPROGRESS_EXTENSION})
+func removeOrphanFiles(st Storage, dir string) error {
+	filePathes, err := listDataFiles(st, dir, []string{INPROGRESS_EXTENSION})
 	if err != nil {
 		return err
 	}
@@ -408,7 +861,7 @@ func (w *defaultWriter) removeOrphanFiles() error {
 		return nil
 	}
 
-	if err := deleteDataFiles(filePathes); err != nil {
+	if err := deleteDataFiles(st, filePathes); err != nil {
 		return err
 	}
 	return nil
@@ -6,19 +6,21 @@ import (
 	"time"
 )
 
-type readerStats struct {
-	r        io.Reader
-	bytes    int
-	readTime time.Duration
+type ReaderStats struct {
+	r          io.Reader
+	bytes      int
+	records    int
+	readTime   time.Duration
+	decodeTime time.Duration
 }
 
-// withReaderStats returns a new ReaderStats wrapping the given reader
-func withReaderStats(r io.Reader) *readerStats {
-	return &readerStats{r: r}
+// WithReaderStats returns a new ReaderStats wrapping the given reader
+func WithReaderStats(r io.Reader) *ReaderStats {
+	return &ReaderStats{r: r}
 }
 
 // Read reads from the underlying reader and records statistics
-func (rs *readerStats) Read(p []byte) (int, error) {
+func (rs *ReaderStats) Read(p []byte) (int, error) {
 	start := time.Now()
 	n, err := rs.r.Read(p)
 	rs.readTime += time.Since(start)
@@ -26,8 +28,39 @@ func (rs *readerStats) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// Decode decodes data read from rs with codec and records statistics
+func (rs *ReaderStats) Decode(codec Codec, data []byte, v any) error {
+	start := time.Now()
+	err := codec.Unmarshal(data, v)
+	rs.decodeTime += time.Since(start)
+	if err == nil {
+		rs.records++
+	}
+	return err
+}
+
+// TotalBytes returns number of bytes read
+func (rs *ReaderStats) TotalBytes() int {
+	return rs.bytes
+}
+
+// TotalRecords returns number of successfully decoded records
+func (rs *ReaderStats) TotalRecords() int {
+	return rs.records
+}
+
+// ElapsedRead returns time spent reading from the underlying reader
+func (rs *ReaderStats) ElapsedRead() time.Duration {
+	return rs.readTime
+}
+
+// ElapsedDecode returns time spent decoding records
+func (rs *ReaderStats) ElapsedDecode() time.Duration {
+	return rs.decodeTime
+}
+
 // Stats returns calls/sec and bytes/sec based only on time spent reading
-func (rs *readerStats) Stats() (bytesPerSec float64) {
+func (rs *ReaderStats) Stats() (bytesPerSec float64) {
 	seconds := rs.readTime.Seconds()
 	if seconds == 0 {
 		return 0
@@ -35,12 +68,18 @@ func (rs *readerStats) Stats() (bytesPerSec float64) {
 	return float64(rs.bytes) / seconds
 }
 
-func (rs *readerStats) HumanStats() (bytes string) {
+func (rs *ReaderStats) HumanStats() (bytes string) {
 	bytesPerSec := rs.Stats()
 	bytes = humanize(bytesPerSec)
 	return
 }
 
+// FullStats returns summary of all collected statistics
+func (rs *ReaderStats) FullStats() string {
+	return fmt.Sprintf("%d records, %d bytes, read %s (%s), decode %s",
+		rs.records, rs.bytes, rs.readTime, rs.HumanStats(), rs.decodeTime)
+}
+
 func humanize(bytesPerSec float64) string {
 	if bytesPerSec < 1024 {
 		return fmt.Sprintf("%.2f B/s", bytesPerSec)
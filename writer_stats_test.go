@@ -0,0 +1,45 @@
+package kvdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReaderStats(t *testing.T) {
+	/* test all reader stats are populated after loading jlog file */
+	content := `{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-1","value":{"name":"Alice-1","age":1}}}
+{"lsn":2,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-2","value":{"name":"Alice-2","age":2}}}
+{"lsn":3,"op":"del","time":1750280676,"record":{"tag":"users","key":"Alice-2","value":null}}
+`
+	filePath := filepath.Join(t.TempDir(), "0000000001.jlog")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write jlog file: %v", err)
+	}
+	fh, err := os.Open(filePath)
+	if err != nil {
+		t.Fatalf("failed to open jlog file: %v", err)
+	}
+	defer fh.Close()
+
+	rs := WithReaderStats(fh)
+	lsn, err := innerLoadDataFile(rs, func(op *operation) (uint64, error) { return op.LSN, nil }, JSONCodec{})
+	if err != nil {
+		t.Fatalf("failed innerLoadDataFile with error: %v", err)
+	}
+	if lsn != 3 {
+		t.Fatalf("failed result check: lsn: %d, expected: %d", lsn, 3)
+	}
+	if rs.TotalBytes() != len(content) {
+		t.Fatalf("failed result check: TotalBytes: %d, expected: %d", rs.TotalBytes(), len(content))
+	}
+	if rs.TotalRecords() != 3 {
+		t.Fatalf("failed result check: TotalRecords: %d, expected: %d", rs.TotalRecords(), 3)
+	}
+	if rs.ElapsedRead() <= 0 || rs.ElapsedDecode() <= 0 {
+		t.Fatalf("failed result check: ElapsedRead: %v, ElapsedDecode: %v, expected positive", rs.ElapsedRead(), rs.ElapsedDecode())
+	}
+	if rs.FullStats() == "" {
+		t.Fatalf("failed result check: FullStats is empty")
+	}
+}
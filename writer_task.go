@@ -5,8 +5,12 @@ type taskAction uint
 const (
 	taskActionNone taskAction = iota
 	taskActionWrite
+	taskActionWriteMany
+	taskActionExec
 	taskActionRotate
 	taskActionSnapshot
+	taskActionTruncateAll
+	taskActionUpsert
 )
 
 type task interface {
@@ -68,6 +72,71 @@ func newWriteTask(op *operation) task {
 	}
 }
 
+type taskWriteMany struct {
+	taskBase
+	ops []*operation
+}
+
+func (t *taskWriteMany) Action() taskAction {
+	return taskActionWriteMany
+}
+
+func (t *taskWriteMany) Ops() []*operation {
+	return t.ops
+}
+
+func newWriteManyTask(ops []*operation) task {
+	return &taskWriteMany{
+		taskBase: newTaskBase(),
+		ops:      ops,
+	}
+}
+
+// execFunc is called in the writer goroutine with the function writing operations
+type execFunc func(write func(op *operation) error) error
+
+type taskExec struct {
+	taskBase
+	fn execFunc
+}
+
+func (t *taskExec) Action() taskAction {
+	return taskActionExec
+}
+
+func (t *taskExec) Fn() execFunc {
+	return t.fn
+}
+
+func newExecTask(fn execFunc) task {
+	return &taskExec{
+		taskBase: newTaskBase(),
+		fn:       fn,
+	}
+}
+
+// upsertFunc is called in the writer goroutine and returns
+// the operation to write, apply is called with it once it is written
+type upsertFunc func() (*operation, error)
+
+type taskUpsert struct {
+	taskBase
+	fn    upsertFunc
+	apply func(op *operation)
+}
+
+func (t *taskUpsert) Action() taskAction {
+	return taskActionUpsert
+}
+
+func newUpsertTask(fn upsertFunc, apply func(op *operation)) task {
+	return &taskUpsert{
+		taskBase: newTaskBase(),
+		fn:       fn,
+		apply:    apply,
+	}
+}
+
 type taskRotate struct {
 	taskBase
 }
@@ -82,9 +151,24 @@ func newRotateTask() task {
 	}
 }
 
+type taskTruncateAll struct {
+	taskBase
+}
+
+func (t *taskTruncateAll) Action() taskAction {
+	return taskActionTruncateAll
+}
+
+func newTruncateAllTask() task {
+	return &taskTruncateAll{
+		taskBase: newTaskBase(),
+	}
+}
+
 type taskSnapshot struct {
 	taskBase
 	snap *map[string]Space
+	dir  string
 }
 
 func (t *taskSnapshot) Action() taskAction {
@@ -95,12 +179,17 @@ func (t *taskSnapshot) Snap() *map[string]Space {
 	return t.snap
 }
 
-func newSnapshotTask(snap *map[string]Space) task {
+func (t *taskSnapshot) Dir() string {
+	return t.dir
+}
+
+func newSnapshotTask(snap *map[string]Space, dir string) task {
 	if snap == nil {
 		return nil
 	}
 	return &taskSnapshot{
 		taskBase: newTaskBase(),
 		snap:     snap,
+		dir:      dir,
 	}
 }
@@ -0,0 +1,25 @@
+package kvdb
+
+import (
+	"testing"
+)
+
+func TestWriterHighWaterMark(t *testing.T) {
+	/* test send fails with ErrWriterBusy when the queue reaches the high watermark */
+	w := newWriter(t.TempDir(), Options{IncomingBufSize: 10, IncomingHighWaterMark: 3})
+	w.status = running
+	w.incoming = make(chan task, w.bufSize)
+
+	if cap(w.incoming) != 10 {
+		t.Fatalf("failed result check: capacity: %d, expected: %d", cap(w.incoming), 10)
+	}
+	for range 3 {
+		w.incoming <- newRotateTask()
+	}
+	if err := w.Rotate(); err != ErrWriterBusy {
+		t.Fatalf("failed Rotate: have error '%v', expected '%v'", err, ErrWriterBusy)
+	}
+	if w.Pending() != 3 {
+		t.Fatalf("failed result check: Pending: %d, expected: %d", w.Pending(), 3)
+	}
+}
@@ -2,51 +2,143 @@ package kvdb
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-var lsnRegexp = regexp.MustCompile(`^(?:.+/)?(\d+)\.([a-z]+)$`)
+var lsnRegexp = regexp.MustCompile(`^(?:.+/)?(\d+)\.([a-z]+)(?:\.([a-z]+))?$`)
 
-func writeTo(op *operation, file *os.File) error {
-	data, err := json.Marshal(op)
+// writeTo writes the operation encoded with codec to w,
+// see appendFrame. Files are synced by callers.
+func writeTo(op *operation, w io.Writer, codec Codec) error {
+	data, err := codec.Marshal(op)
 	if err != nil {
 		return err
 	}
 
-	data = append(data, '\n')
+	data = appendFrame(nil, data, codec)
 
-	if _, err = file.Write(data); err != nil {
+	if _, err = w.Write(data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func writeManyTo(ops []*operation, file *os.File) error {
+// writeManyTo writes the operations encoded with codec to w
+// by a single write. Files are synced by callers.
+func writeManyTo(ops []*operation, w io.Writer, codec Codec) error {
 	res := make([]byte, 0)
 	for _, op := range ops {
-		data, err := json.Marshal(op)
+		data, err := codec.Marshal(op)
 		if err != nil {
 			return err
 		}
-		res = append(res, data...)
-		res = append(res, '\n')
+		res = appendFrame(res, data, codec)
 	}
-	_, err := file.Write(res)
+	_, err := w.Write(res)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func listDataFiles(dir string, extensions []string) ([]string, error) {
-	entries, err := os.ReadDir(dir)
+// appendFrame appends the encoded operation to buf as a line,
+// or prefixed by its length as uvarint if codec is a BinaryCodec
+func appendFrame(buf []byte, data []byte, codec Codec) []byte {
+	if _, ok := codec.(BinaryCodec); ok {
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		return append(buf, data...)
+	}
+	buf = append(buf, data...)
+	return append(buf, '\n')
+}
+
+// readFrame reads the next operation written by appendFrame,
+// empty lines are skipped. It returns io.EOF after the last operation.
+func readFrame(r *bufio.Reader, codec Codec) ([]byte, error) {
+	if _, ok := codec.(BinaryCodec); ok {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(r, data); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return data, err
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fileCodec returns the codec of the data file. If codec is a BinaryCodec,
+// files without its extension are read as JSON Lines, so a database
+// written before switching to the codec can still be opened.
+func fileCodec(filePath string, codec Codec) Codec {
+	if bc, ok := codec.(BinaryCodec); ok && !strings.HasSuffix(filePath, "."+bc.Extension()) {
+		return JSONCodec{}
+	}
+	return codec
+}
+
+// dataFileName returns the path of the data file of the given type
+// (JLOG_EXTENSION or SNAP_EXTENSION) starting at lsn. The extension
+// of a BinaryCodec is added to the type, like 0000000001.jlog.mp.
+func dataFileName(dir string, lsn uint64, typ string, codec Codec) string {
+	name := fmt.Sprintf("%s/%s.%s", dir, lsn2str(lsn), typ)
+	if bc, ok := codec.(BinaryCodec); ok {
+		name += "." + bc.Extension()
+	}
+	return name
+}
+
+// dataFileType returns the type of the data file whatever its codec is,
+// JLOG_EXTENSION or SNAP_EXTENSION. It is empty for unfinished files.
+func dataFileType(filePath string) string {
+	match := lsnRegexp.FindStringSubmatch(filePath)
+	if match == nil || match[3] == INPROGRESS_EXTENSION {
+		return ""
+	}
+	return match[2]
+}
+
+// countingWriter adds number of bytes written to w to n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	written, err := cw.w.Write(p)
+	cw.n.Add(uint64(written))
+	return written, err
+}
+
+func listDataFiles(st Storage, dir string, extensions []string) ([]string, error) {
+	entries, err := st.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -62,20 +154,17 @@ func listDataFiles(dir string, extensions []string) ([]string, error) {
 		}
 		name = ent.Name()
 
-		for _, ext := range extensions {
-			if strings.HasSuffix(name, ext) {
-				filesNames = append(filesNames, fmt.Sprintf("%s/%s", dir, name))
-				break
-			}
+		if slices.Contains(extensions, dataFileType(name)) {
+			filesNames = append(filesNames, fmt.Sprintf("%s/%s", dir, name))
 		}
 	}
 
 	return filesNames, nil
 }
 
-func deleteDataFiles(filesPathes []string) error {
+func deleteDataFiles(st Storage, filesPathes []string) error {
 	for _, filePath := range filesPathes {
-		if err := os.Remove(filePath); err != nil {
+		if err := st.Remove(filePath); err != nil {
 			return err
 		}
 	}
@@ -98,45 +187,235 @@ func getFileLsn(filePath string) (uint64, error) {
 	return str2lsn(match[1])
 }
 
-func loadDataFile(filePath string, applyTxn func(*operation) (uint64, error)) (uint64, error) {
-	fh, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+// loadDataFile applies all operations of the file
+// returns the greatest LSN of its operations and number of operations
+// progress, if not nil, is called every progressEvery bytes
+// while the file is read (see ProgressReader).
+// Compressed snapshots are detected by the gzip header.
+func loadDataFile(st Storage, filePath string, applyTxn func(*operation) (uint64, error), codec Codec, progress func(bytesRead, totalBytes int64), progressEvery int64) (uint64, int, error) {
+	fh, err := st.OpenFile(filePath, os.O_RDONLY, 0644)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer fh.Close()
 
-	rs := withReaderStats(fh)
-	lsn, err := innerLoadDataFile(rs, applyTxn)
+	var r io.Reader = fh
+	if progress != nil {
+		fi, err := st.Stat(filePath)
+		if err != nil {
+			return 0, 0, err
+		}
+		r = NewProgressReader(fh, fi.Size(), progressEvery, progress)
+	}
+	if r, err = decompressed(r); err != nil {
+		return 0, 0, err
+	}
+
+	rs := WithReaderStats(r)
+	lsn, err := innerLoadDataFile(rs, applyTxn, fileCodec(filePath, codec))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	log.Printf("loadFile %s (lsn=%d): %s\n", fh.Name(), lsn, rs.HumanStats())
-	return lsn, nil
+	log.Printf("loadFile %s (lsn=%d): %s\n", filePath, lsn, rs.FullStats())
+	return lsn, rs.TotalRecords(), nil
 }
 
-func innerLoadDataFile(file io.Reader, applyTxn applyTxnFunc) (uint64, error) {
-	dec := json.NewDecoder(bufio.NewReader(file))
+// innerLoadDataFile applies operations read from rs.
+// Operations between begin and commit records are applied only
+// when commit is read, so unfinished transactions are dropped.
+func innerLoadDataFile(rs *ReaderStats, applyTxn applyTxnFunc, codec Codec) (uint64, error) {
+	reader := bufio.NewReader(rs)
 
-	var err error
 	var lsn uint64
+	var txn []*operation
+	inTxn := false
 	for {
+		data, err := readFrame(reader, codec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
 		var op operation
-		if err = dec.Decode(&op); err != nil {
-			if err == io.EOF {
-				break
+		if err = rs.Decode(codec, data, &op); err != nil {
+			return 0, err
+		}
+
+		switch {
+		case op.Op == begin:
+			inTxn, txn = true, nil
+		case op.Op == rollback:
+			inTxn, txn = false, nil
+		case op.Op == commit:
+			for _, txnOp := range txn {
+				if _, err = applyTxn(txnOp); err != nil {
+					return 0, err
+				}
+			}
+			inTxn, txn = false, nil
+		case inTxn:
+			txn = append(txn, &op)
+		default:
+			if _, err = applyTxn(&op); err != nil {
+				return 0, err
 			}
+		}
+		// operations of snapshots are sorted by key, not by LSN
+		lsn = max(lsn, op.LSN)
+	}
+	return lsn, nil
+}
+
+// lastDataFileLSN returns LSN of the last operation of the file
+// reading it from the end, 0 if the file is empty.
+// Files which do not support io.ReaderAt and files
+// of a BinaryCodec are read completely.
+func lastDataFileLSN(st Storage, filePath string, codec Codec) (uint64, error) {
+	fh, err := st.OpenFile(filePath, os.O_RDONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	codec = fileCodec(filePath, codec)
+	ra, ok := fh.(io.ReaderAt)
+	if _, isBinary := codec.(BinaryCodec); !ok || isBinary {
+		return innerLoadDataFile(WithReaderStats(fh), func(op *operation) (uint64, error) {
+			return op.LSN, nil
+		}, codec)
+	}
+	fi, err := st.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	for window := int64(4096); ; window *= 2 {
+		offset := max(fi.Size()-window, 0)
+		buf := make([]byte, fi.Size()-offset)
+		if _, err := ra.ReadAt(buf, offset); err != nil {
+			return 0, err
+		}
+		buf = bytes.TrimSpace(buf)
+		i := bytes.LastIndexByte(buf, '\n')
+		if i < 0 && offset > 0 {
+			// the last line may start before the window
+			continue
+		}
+		if len(buf) == 0 {
+			return 0, nil
+		}
+		var op operation
+		if err := codec.Unmarshal(bytes.TrimSpace(buf[i+1:]), &op); err != nil {
 			return 0, err
 		}
-		lsn, err = applyTxn(&op)
+		return op.LSN, nil
+	}
+}
+
+// compactSpaceFiles rewrites jlog files without operations of the space
+// with LSN up to upTo which are overwritten by a later operation
+// with the same key in these files. Returns number of removed operations.
+func compactSpaceFiles(st Storage, filePathes []string, name string, upTo uint64, codec Codec) (int, error) {
+	last := map[string]uint64{} // LSN of the last applied operation by key
+	collect := func(op *operation) (uint64, error) {
+		if op.Record != nil && op.Record.Tag == name && op.LSN <= upTo {
+			last[string(op.Record.Key)] = op.LSN
+		}
+		return op.LSN, nil
+	}
+	for _, filePath := range filePathes {
+		if _, _, err := loadDataFile(st, filePath, collect, codec, nil, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	removed := 0
+	for _, filePath := range filePathes {
+		n, err := rewriteDataFile(st, filePath, codec, func(op *operation) bool {
+			return op.Record == nil || op.Record.Tag != name || op.LSN > upTo ||
+				last[string(op.Record.Key)] == op.LSN
+		})
 		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// rewriteDataFile replaces the file with a copy containing only
+// operations for which keep returns true. The file is not touched
+// if all operations are kept. Returns number of removed operations.
+func rewriteDataFile(st Storage, filePath string, codec Codec, keep func(op *operation) bool) (int, error) {
+	src, err := st.OpenFile(filePath, os.O_RDONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	codec = fileCodec(filePath, codec)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	kept := make([]byte, 0, len(data))
+	removed := 0
+	for {
+		frame, err := readFrame(reader, codec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
 			return 0, err
 		}
+		var op operation
+		if err := codec.Unmarshal(frame, &op); err != nil {
+			return 0, err
+		}
+		if !keep(&op) {
+			removed++
+			continue
+		}
+		kept = appendFrame(kept, frame, codec)
 	}
-	return lsn, nil
+	if removed == 0 {
+		return 0, nil
+	}
+
+	tmpPath := filePath + "." + INPROGRESS_EXTENSION
+	fh, err := st.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = fh.Write(kept); err != nil {
+		fh.Close()
+		st.Remove(tmpPath)
+		return 0, err
+	}
+	if err = closeFile(fh); err != nil {
+		st.Remove(tmpPath)
+		return 0, err
+	}
+	if err = st.Rename(tmpPath, filePath); err != nil {
+		return 0, err
+	}
+	if err = syncStorageDir(st, filepath.Dir(filePath)); err != nil {
+		return 0, err
+	}
+	return removed, nil
 }
 
-func closeFile(file *os.File) error {
+// truncateDataFile removes operations with LSN greater than lsn
+// from the end of the file
+func truncateDataFile(st Storage, filePath string, lsn uint64, codec Codec) error {
+	_, err := rewriteDataFile(st, filePath, codec, func(op *operation) bool {
+		return op.LSN <= lsn
+	})
+	return err
+}
+
+func closeFile(file StorageFile) error {
 	if err := file.Sync(); err != nil {
 		return err
 	}
@@ -146,3 +425,92 @@ func closeFile(file *os.File) error {
 	}
 	return nil
 }
+
+// writeJSONFile atomically replaces the file with v encoded as JSON
+func writeJSONFile(st Storage, filePath string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + "." + INPROGRESS_EXTENSION
+	fh, err := st.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fh.Write(data); err != nil {
+		fh.Close()
+		st.Remove(tmpPath)
+		return err
+	}
+	if err = closeFile(fh); err != nil {
+		st.Remove(tmpPath)
+		return err
+	}
+	if err = st.Rename(tmpPath, filePath); err != nil {
+		st.Remove(tmpPath)
+		return err
+	}
+	return syncStorageDir(st, filepath.Dir(filePath))
+}
+
+// readJSONFile decodes JSON of the file into v,
+// it returns false if there is no such file
+func readJSONFile(st Storage, filePath string, v any) (bool, error) {
+	fh, err := st.OpenFile(filePath, os.O_RDONLY, 0644)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+
+	data, err := io.ReadAll(fh)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// metaFiles are files of the data directory besides data files,
+// they are carried by CopyTo and Restore
+var metaFiles = []string{CREATED_FILE, CHECKPOINT_FILE}
+
+// listMetaFiles returns paths of metaFiles present in dir
+func listMetaFiles(st Storage, dir string) ([]string, error) {
+	filePathes := []string{}
+	for _, name := range metaFiles {
+		filePath := filepath.Join(dir, name)
+		_, err := st.Stat(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		filePathes = append(filePathes, filePath)
+	}
+	return filePathes, nil
+}
+
+func copyFile(st Storage, src, dst string) error {
+	in, err := st.OpenFile(src, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := st.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
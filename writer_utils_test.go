@@ -0,0 +1,294 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInnerLoadDataFileTransactions(t *testing.T) {
+	/* test operations of uncommitted transactions are not applied */
+	content := `{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-1","value":{"name":"Alice-1","age":1}}}
+{"lsn":2,"op":"begin","time":1750280676,"record":null}
+{"lsn":3,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-2","value":{"name":"Alice-2","age":2}}}
+{"lsn":4,"op":"commit","time":1750280676,"record":null}
+{"lsn":5,"op":"begin","time":1750280676,"record":null}
+{"lsn":6,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-3","value":{"name":"Alice-3","age":3}}}
+`
+	applied := []string{}
+	rs := WithReaderStats(strings.NewReader(content))
+	lsn, err := innerLoadDataFile(rs, func(op *operation) (uint64, error) {
+		applied = append(applied, string(op.Record.Key))
+		return op.LSN, nil
+	}, JSONCodec{})
+	if err != nil {
+		t.Fatalf("failed innerLoadDataFile with error: %v", err)
+	}
+	if lsn != 6 {
+		t.Fatalf("failed result check: lsn: %d, expected: %d", lsn, 6)
+	}
+	if strings.Join(applied, ",") != "Alice-1,Alice-2" {
+		t.Fatalf("failed result check: applied: '%v', expected: '%v'", applied, []string{"Alice-1", "Alice-2"})
+	}
+}
+
+type hexCodec struct{}
+
+func (hexCodec) Marshal(v any) ([]byte, error) {
+	data, err := JSONCodec{}.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(data)), nil
+}
+
+func (hexCodec) Unmarshal(data []byte, v any) error {
+	raw, err := hex.DecodeString(string(data))
+	if err != nil {
+		return err
+	}
+	return JSONCodec{}.Unmarshal(raw, v)
+}
+
+func TestLoadDataFileCodec(t *testing.T) {
+	/* test operations written with a codec are loaded back with it */
+	path := filepath.Join(t.TempDir(), "00000000000000000000.jlog")
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create data file: %v", err)
+	}
+	ops := []*operation{
+		{LSN: 1, Op: OPERATION_SET, Record: &record{Tag: "users", Key: []byte("Alice"), Value: "a"}},
+		{LSN: 2, Op: OPERATION_DEL, Record: &record{Tag: "users", Key: []byte("Alice")}},
+	}
+	if err := writeManyTo(ops, fh, hexCodec{}); err != nil {
+		t.Fatalf("failed writeManyTo with error: %v", err)
+	}
+	fh.Close()
+
+	applied := []oType{}
+	lsn, n, err := loadDataFile(OSStorage{}, path, func(op *operation) (uint64, error) {
+		applied = append(applied, op.Op)
+		return op.LSN, nil
+	}, hexCodec{}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed loadDataFile with error: %v", err)
+	}
+	if lsn != 2 || n != 2 {
+		t.Fatalf("failed result check: lsn: %d, ops: %d, expected: 2, 2", lsn, n)
+	}
+	if applied[0] != OPERATION_SET || applied[1] != OPERATION_DEL {
+		t.Fatalf("failed result check: applied: %v", applied)
+	}
+
+	if _, _, err := loadDataFile(OSStorage{}, path, func(op *operation) (uint64, error) {
+		return op.LSN, nil
+	}, JSONCodec{}, nil, 0); err == nil {
+		t.Fatalf("expected error loading hex encoded file with JSON codec")
+	}
+}
+
+func TestLastDataFileLSN(t *testing.T) {
+	/* test LSN of the last line is read, also if the line is longer than the read window */
+	long := strings.Repeat("x", 10000)
+	content := `{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-1","value":{"name":"Alice-1","age":1}}}
+{"lsn":2,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice-2","value":{"name":"` + long + `","age":2}}}
+
+`
+	path := filepath.Join(t.TempDir(), "00000000000000000001.jlog")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	lsn, err := lastDataFileLSN(OSStorage{}, path, JSONCodec{})
+	if err != nil {
+		t.Fatalf("failed lastDataFileLSN with error: %v", err)
+	}
+	if lsn != 2 {
+		t.Fatalf("failed result check: lsn: %d, expected: %d", lsn, 2)
+	}
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	if lsn, err = lastDataFileLSN(OSStorage{}, path, JSONCodec{}); err != nil || lsn != 0 {
+		t.Fatalf("failed result check of empty file: lsn: %d, err: %v", lsn, err)
+	}
+}
+
+func TestEncodeTo(t *testing.T) {
+	/* test operations are written as JSON lines */
+	var buf bytes.Buffer
+	set := &operation{LSN: 1, Op: OPERATION_SET, Time: 1750280676, Record: &record{Tag: "users", Key: []byte("Alice"), Value: "a"}}
+	if err := set.EncodeTo(&buf); err != nil {
+		t.Fatalf("failed EncodeTo with error: %v", err)
+	}
+	del := &operation{LSN: 2, Op: OPERATION_DEL, Time: 1750280676, Record: &record{Tag: "users", Key: []byte("Alice")}}
+	if err := writeManyTo([]*operation{del}, &buf, JSONCodec{}); err != nil {
+		t.Fatalf("failed writeManyTo with error: %v", err)
+	}
+	expected := `{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice","value":"a"}}
+{"lsn":2,"op":"del","time":1750280676,"record":{"tag":"users","key":"Alice","value":null}}
+`
+	if buf.String() != expected {
+		t.Fatalf("failed result check: written: %q, expected: %q", buf.String(), expected)
+	}
+}
+
+func TestCompactSpaceFiles(t *testing.T) {
+	/* test only overwritten operations of the space up to the LSN are removed */
+	dir := t.TempDir()
+	first := `{"lsn":1,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice","value":1}}
+{"lsn":2,"op":"set","time":1750280676,"record":{"tag":"orders","key":"Alice","value":1}}
+{"lsn":3,"op":"set","time":1750280676,"record":{"tag":"users","key":"Bob","value":1}}
+`
+	second := `{"lsn":4,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice","value":2}}
+{"lsn":5,"op":"set","time":1750280676,"record":{"tag":"orders","key":"Alice","value":2}}
+{"lsn":6,"op":"del","time":1750280676,"record":{"tag":"users","key":"Bob","value":null}}
+{"lsn":7,"op":"set","time":1750280676,"record":{"tag":"users","key":"Alice","value":3}}
+`
+	files := []string{filepath.Join(dir, "00000000000000000001.jlog"), filepath.Join(dir, "00000000000000000004.jlog")}
+	for i, content := range []string{first, second} {
+		if err := os.WriteFile(files[i], []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write data file: %v", err)
+		}
+	}
+
+	removed, err := compactSpaceFiles(OSStorage{}, files, "users", 6, JSONCodec{})
+	if err != nil {
+		t.Fatalf("failed compactSpaceFiles with error: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("failed result check: removed: %d, expected: %d", removed, 2)
+	}
+	lsns := []uint64{}
+	for _, file := range files {
+		if _, _, err := loadDataFile(OSStorage{}, file, func(op *operation) (uint64, error) {
+			lsns = append(lsns, op.LSN)
+			return op.LSN, nil
+		}, JSONCodec{}, nil, 0); err != nil {
+			t.Fatalf("failed loadDataFile with error: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(lsns, []uint64{2, 4, 5, 6, 7}) {
+		t.Fatalf("failed result check: lsns: %v, expected: %v", lsns, []uint64{2, 4, 5, 6, 7})
+	}
+}
+
+func TestLoadDataFileMsgpack(t *testing.T) {
+	/* test operations written with MsgpackCodec are framed by length:
+	- values containing newlines are loaded back as with JSONCodec
+	- the last LSN is read and operations are truncated
+	*/
+	path := filepath.Join(t.TempDir(), "0000000001.jlog.mp")
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create data file: %v", err)
+	}
+	ops := []*operation{}
+	for i := 1; i <= 20; i++ {
+		user := TestUser{Name: fmt.Sprintf("line-%d\nline-%d", i, i+1), Age: i}
+		ops = append(ops, &operation{LSN: uint64(i), Op: OPERATION_SET, Time: 10, Record: &record{Tag: "users", Key: []byte{byte(i), '\n'}, Value: user}})
+	}
+	if err := writeManyTo(ops, fh, MsgpackCodec{}); err != nil {
+		t.Fatalf("failed writeManyTo with error: %v", err)
+	}
+	fh.Close()
+
+	values := []any{}
+	lsn, n, err := loadDataFile(OSStorage{}, path, func(op *operation) (uint64, error) {
+		values = append(values, op.Record.Value)
+		return op.LSN, nil
+	}, MsgpackCodec{}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed loadDataFile with error: %v", err)
+	}
+	if lsn != 20 || n != 20 {
+		t.Fatalf("failed result check: lsn: %d, ops: %d, expected: 20, 20", lsn, n)
+	}
+	expected := map[string]any{"name": "line-10\nline-11", "age": float64(10)}
+	if !reflect.DeepEqual(values[9], expected) {
+		t.Fatalf("failed result check: value: %#v, expected: %#v", values[9], expected)
+	}
+
+	if lsn, err = lastDataFileLSN(OSStorage{}, path, MsgpackCodec{}); err != nil || lsn != 20 {
+		t.Fatalf("failed result check: last lsn: %d, err: %v", lsn, err)
+	}
+	if err = truncateDataFile(OSStorage{}, path, 15, MsgpackCodec{}); err != nil {
+		t.Fatalf("failed truncateDataFile with error: %v", err)
+	}
+	if lsn, err = lastDataFileLSN(OSStorage{}, path, MsgpackCodec{}); err != nil || lsn != 15 {
+		t.Fatalf("failed result check: last lsn after truncate: %d, err: %v", lsn, err)
+	}
+}
+
+func TestFileCodec(t *testing.T) {
+	/* test data files are named and read by the extension of the codec */
+	if name := dataFileName("db", 1, JLOG_EXTENSION, MsgpackCodec{}); name != "db/0000000001.jlog.mp" {
+		t.Fatalf("failed result check: name: %s, expected: %s", name, "db/0000000001.jlog.mp")
+	}
+	if name := dataFileName("db", 1, SNAP_EXTENSION, JSONCodec{}); name != "db/0000000001.snap" {
+		t.Fatalf("failed result check: name: %s, expected: %s", name, "db/0000000001.snap")
+	}
+	for path, typ := range map[string]string{
+		"db/0000000001.jlog":               JLOG_EXTENSION,
+		"db/0000000001.snap.mp":            SNAP_EXTENSION,
+		"db/0000000001.snap.inprogress":    "",
+		"db/0000000001.snap.mp.inprogress": "",
+	} {
+		if got := dataFileType(path); got != typ {
+			t.Fatalf("failed result check: type of %s: %q, expected: %q", path, got, typ)
+		}
+	}
+	if _, ok := fileCodec("db/0000000001.jlog", MsgpackCodec{}).(JSONCodec); !ok {
+		t.Fatalf("failed result check: files without extension must be read as JSON")
+	}
+	if _, ok := fileCodec("db/0000000001.jlog.mp", MsgpackCodec{}).(MsgpackCodec); !ok {
+		t.Fatalf("failed result check: files with extension must be read by the codec")
+	}
+}
+
+func BenchmarkCodecSize(b *testing.B) {
+	/* benchmark encoding of typical operations, reports bytes per operation */
+	ops := make([]*operation, 1000)
+	for i := range ops {
+		user := TestUser{Name: fmt.Sprintf("Alice-%d", i), Age: i % 100}
+		ops[i] = &operation{LSN: uint64(i + 1), Op: OPERATION_SET, Time: 1750280676, Record: &record{Tag: "users", Key: []byte(user.Name), Value: user}}
+	}
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		b.Run(fmt.Sprintf("%T", codec), func(b *testing.B) {
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for b.Loop() {
+				buf.Reset()
+				if err := writeManyTo(ops, &buf, codec); err != nil {
+					b.Fatalf("failed writeManyTo with error: %v", err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len())/float64(len(ops)), "bytes/op")
+		})
+	}
+}
+
+func TestMsgpackCodecSize(t *testing.T) {
+	/* test MsgpackCodec writes operations at least 20% smaller than JSONCodec */
+	ops := make([]*operation, 1000)
+	for i := range ops {
+		user := TestUser{Name: fmt.Sprintf("Alice-%d", i), Age: i % 100}
+		ops[i] = &operation{LSN: uint64(i + 1), Op: OPERATION_SET, Time: 1750280676, Record: &record{Tag: "users", Key: []byte(user.Name), Value: user}}
+	}
+	var jsonBuf, msgpackBuf bytes.Buffer
+	if err := writeManyTo(ops, &jsonBuf, JSONCodec{}); err != nil {
+		t.Fatalf("failed writeManyTo with error: %v", err)
+	}
+	if err := writeManyTo(ops, &msgpackBuf, MsgpackCodec{}); err != nil {
+		t.Fatalf("failed writeManyTo with error: %v", err)
+	}
+	if ratio := float64(msgpackBuf.Len()) / float64(jsonBuf.Len()); ratio > 0.8 {
+		t.Fatalf("failed result check: msgpack size: %d, JSON size: %d, ratio %.2f is above 0.8", msgpackBuf.Len(), jsonBuf.Len(), ratio)
+	}
+}